@@ -0,0 +1,211 @@
+package nginxviz
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	agentQueueSize     = 10000
+	agentBatchSize     = 100
+	agentFlushInterval = 500 * time.Millisecond
+	agentMaxBackoff    = 30 * time.Second
+)
+
+// collectURL turns the collector address given to an agent into the URL of
+// its collect websocket.
+func collectURL(collector, source string) (string, error) {
+	u, err := url.Parse(collector)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http", "ws":
+		u.Scheme = "ws"
+	case "https", "wss":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/collect"
+	u.RawQuery = url.Values{"source": {source}}.Encode()
+	return u.String(), nil
+}
+
+// entryForwarder sends the entries of an agent to its collector in batches
+// over a websocket, reconnecting when the connection drops. Entries are
+// queued while the collector is unreachable and dropped once the queue is
+// full.
+type entryForwarder struct {
+	url          string
+	token        string
+	pingInterval time.Duration
+	queue        chan LogEntry
+	dropped      atomic.Int64
+}
+
+func newEntryForwarder(url, token string, pingInterval time.Duration) *entryForwarder {
+	return &entryForwarder{
+		url:          url,
+		token:        token,
+		pingInterval: pingInterval,
+		queue:        make(chan LogEntry, agentQueueSize),
+	}
+}
+
+// Enqueue queues an entry without blocking the log reader.
+func (f *entryForwarder) Enqueue(entry LogEntry) {
+	select {
+	case f.queue <- entry:
+	default:
+		if n := f.dropped.Add(1); n == 1 || n%1000 == 0 {
+			slog.Warn("Collector queue full, dropping entries", "dropped_total", n)
+		}
+	}
+}
+
+// Run forwards queued entries until ctx is cancelled.
+func (f *entryForwarder) Run(ctx context.Context) {
+	var pending []LogEntry
+	backoff := time.Second
+	for {
+		header := http.Header{"Authorization": {"Bearer " + f.token}}
+		conn, _, err := websocket.DefaultDialer.DialContext(ctx, f.url, header)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Warn("Cannot connect to collector", "url", f.url, "err", err, "retry_in", backoff)
+			if sleepContext(ctx, backoff) != nil {
+				return
+			}
+			backoff = min(2*backoff, agentMaxBackoff)
+			continue
+		}
+		slog.Info("Connected to collector", "url", f.url)
+		backoff = time.Second
+
+		pending, err = f.forward(ctx, conn, pending)
+		conn.Close()
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("Lost connection to collector", "url", f.url, "err", err)
+	}
+}
+
+// forward sends batches over conn until it fails. The batch that could not
+// be sent is returned to be sent again on the next connection.
+func (f *entryForwarder) forward(ctx context.Context, conn *websocket.Conn, pending []LogEntry) ([]LogEntry, error) {
+	// The collector never sends data, but reading is needed to handle
+	// control frames and notice the connection closing
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				closed <- err
+				return
+			}
+		}
+	}()
+
+	flush := time.NewTicker(agentFlushInterval)
+	defer flush.Stop()
+	ping := time.NewTicker(f.pingInterval)
+	defer ping.Stop()
+
+	for {
+		if len(pending) >= agentBatchSize {
+			if err := conn.WriteJSON(pending); err != nil {
+				return pending, err
+			}
+			pending = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			return pending, ctx.Err()
+		case err := <-closed:
+			return pending, err
+		case entry := <-f.queue:
+			pending = append(pending, entry)
+		case <-flush.C:
+			if len(pending) > 0 {
+				if err := conn.WriteJSON(pending); err != nil {
+					return pending, err
+				}
+				pending = nil
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(f.pingInterval)); err != nil {
+				return pending, err
+			}
+		}
+	}
+}
+
+// MakeCollectHandler receives batches of entries from agents on a
+// websocket. Each entry is labelled with the agent's ?source=, or its
+// address when it gives none, and then handled like a pushed entry.
+func (s *Server) MakeCollectHandler() http.HandlerFunc {
+	pongWait := 2 * s.cfg.PingInterval
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		rc.SetReadDeadline(time.Time{})
+		rc.SetWriteDeadline(time.Time{})
+
+		source := r.URL.Query().Get("source")
+		if source == "" {
+			source, _, _ = net.SplitHostPort(r.RemoteAddr)
+		}
+
+		conn, err := s.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			slog.Warn("WebSocket upgrade error", "err", err)
+			return
+		}
+		defer conn.Close()
+
+		slog.Info("Agent connected", "source", source, "remote", r.RemoteAddr)
+		defer slog.Info("Agent disconnected", "source", source, "remote", r.RemoteAddr)
+
+		// Agents ping every PingInterval, and the read deadline is moved
+		// with every message or ping
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		conn.SetPingHandler(func(data string) error {
+			conn.SetReadDeadline(time.Now().Add(pongWait))
+			return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		})
+
+		for {
+			var batch []LogEntry
+			if err := conn.ReadJSON(&batch); err != nil {
+				slog.Debug("Agent read error", "source", source, "err", err)
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(pongWait))
+
+			for _, entry := range batch {
+				entry.Source = source
+				entry, ok := s.ingestedEntry(entry)
+				if !ok {
+					continue
+				}
+				if err := s.submitEntry(s.ctx, entry); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package nginxviz
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// albLogParser parses AWS Application Load Balancer access logs.
+type albLogParser struct{}
+
+func (albLogParser) Parse(line string) (LogEntry, error) {
+	return parseALBLog(line)
+}
+
+// parseALBLog parses an AWS Application Load Balancer access log line:
+//
+//	type time elb client:port target:port request_processing_time target_processing_time
+//	response_processing_time elb_status_code target_status_code received_bytes sent_bytes
+//	"request" "user_agent" ssl_cipher ssl_protocol target_group_arn "trace_id" ...
+func parseALBLog(line string) (LogEntry, error) {
+	fields := splitLogFields(line)
+	if len(fields) < 14 {
+		return LogEntry{}, fmt.Errorf("failed to parse ALB log line: %s", line)
+	}
+
+	timestamp, err := time.Parse(time.RFC3339Nano, fields[1])
+	if err != nil {
+		timestamp = time.Now()
+	}
+
+	ip := fields[3]
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	} else if i := strings.LastIndexByte(ip, ':'); i > 0 {
+		ip = ip[:i]
+	}
+
+	// The request is logged as "METHOD scheme://host:port/path PROTOCOL"
+	request := strings.Fields(fields[12])
+	if len(request) < 2 {
+		return LogEntry{}, fmt.Errorf("failed to parse ALB request %q: %s", fields[12], line)
+	}
+	requestURL := request[1]
+	var protocol string
+	if len(request) >= 3 {
+		protocol = request[2]
+	}
+	var host string
+	if u, err := url.Parse(requestURL); err == nil && u.Host != "" {
+		requestURL = u.RequestURI()
+		host = u.Hostname()
+	}
+
+	statusCode, _ := strconv.Atoi(fields[8])
+	size, _ := strconv.Atoi(fields[11])
+
+	// Processing times are -1 when the request never reached a target
+	var requestTime float64
+	for _, field := range fields[5:8] {
+		requestTime += parseResponseTime(field)
+	}
+
+	// The TLS columns are "-" for plain HTTP listeners
+	var tlsCipher, tlsProtocol string
+	if len(fields) > 15 && fields[15] != "-" {
+		tlsCipher, tlsProtocol = fields[14], fields[15]
+	}
+
+	return LogEntry{
+		Timestamp:    timestamp,
+		IP:           ip,
+		Method:       request[0],
+		URL:          requestURL,
+		Protocol:     protocol,
+		StatusCode:   statusCode,
+		Size:         size,
+		UserAgent:    fields[13],
+		RequestTime:  requestTime,
+		UpstreamTime: parseResponseTime(fields[6]),
+		Host:         host,
+		TLSProtocol:  tlsProtocol,
+		TLSCipher:    tlsCipher,
+	}, nil
+}
@@ -0,0 +1,72 @@
+package nginxviz
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+)
+
+// loopbackPrefixes are allowed admin access unless NoLoopbackAdmin is set.
+var loopbackPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("127.0.0.1/32"),
+	netip.MustParsePrefix("::1/128"),
+}
+
+// CIDRAllowList restricts access to the source addresses in a set of
+// networks.
+type CIDRAllowList struct {
+	prefixes []netip.Prefix
+}
+
+// NewCIDRAllowList parses cidrs, adding the loopback addresses unless
+// noLoopback is set.
+func NewCIDRAllowList(cidrs []string, noLoopback bool) (*CIDRAllowList, error) {
+	l := &CIDRAllowList{}
+	if !noLoopback {
+		l.prefixes = append(l.prefixes, loopbackPrefixes...)
+	}
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		l.prefixes = append(l.prefixes, prefix.Masked())
+	}
+	return l, nil
+}
+
+// Allowed reports whether remoteAddr, in the host:port form of
+// http.Request.RemoteAddr, is inside one of the networks. Addresses that do
+// not parse, such as those of unix socket peers, are refused.
+func (l *CIDRAllowList) Allowed(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+
+	for _, prefix := range l.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware rejects requests from outside the allowed networks with a 403.
+func (l *CIDRAllowList) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.Allowed(r.RemoteAddr) {
+			slog.Warn("Refused admin request from disallowed address", "remote", r.RemoteAddr, "path", r.URL.Path)
+			returnError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
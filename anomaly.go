@@ -0,0 +1,112 @@
+package nginxviz
+
+import (
+	"math"
+	"net/url"
+	"strings"
+)
+
+// attackPatterns are fragments of common SQL injection, XSS and path
+// traversal payloads, matched against the lowercased, unescaped URL.
+var attackPatterns = []string{
+	// SQL injection
+	"union select", "union all select", "' or '", "' or 1=1", " or 1=1",
+	"information_schema", "sleep(", "benchmark(", ";drop ", "xp_cmdshell",
+	// XSS
+	"<script", "javascript:", "onerror=", "onload=", "alert(", "document.cookie",
+	"<iframe", "<svg",
+	// Path traversal
+	"../", "..\\", "%2e%2e", "/etc/passwd", "win.ini", "/proc/self",
+}
+
+// Feature weights sum to 1, so a single attack string is enough to exceed
+// the default threshold while unusual shapes alone only raise the score.
+const (
+	attackWeight     = 0.75
+	urlLengthWeight  = 0.08
+	pathDepthWeight  = 0.05
+	queryParamWeight = 0.05
+	uaEntropyWeight  = 0.07
+
+	maxURLLength   = 200
+	maxPathDepth   = 10
+	maxQueryParams = 10
+
+	// typicalUAEntropy is the Shannon entropy in bits per character of a
+	// common browser user agent.
+	typicalUAEntropy = 4.6
+)
+
+// TrafficClassifier scores requests by features of their URL and user
+// agent to flag scanners that stay below the rate threshold.
+type TrafficClassifier struct {
+	threshold float64
+}
+
+func NewTrafficClassifier(threshold float64) *TrafficClassifier {
+	return &TrafficClassifier{threshold: threshold}
+}
+
+// Score returns the weighted sum of the request features, from 0 (ordinary)
+// to 1 (almost certainly an attack).
+func (c *TrafficClassifier) Score(entry LogEntry) float64 {
+	rawURL := entry.URL
+	decoded, err := url.QueryUnescape(rawURL)
+	if err != nil {
+		decoded = rawURL
+	}
+
+	path, query, _ := strings.Cut(rawURL, "?")
+	var params int
+	if query != "" {
+		params = strings.Count(query, "&") + 1
+	}
+
+	var score float64
+	if containsAny(strings.ToLower(decoded), attackPatterns) {
+		score += attackWeight
+	}
+	score += urlLengthWeight * capped(float64(len(rawURL)), maxURLLength)
+	score += pathDepthWeight * capped(float64(strings.Count(path, "/")), maxPathDepth)
+	score += queryParamWeight * capped(float64(params), maxQueryParams)
+	score += uaEntropyWeight * uaEntropyFeature(entry.UserAgent)
+	return min(score, 1)
+}
+
+// Classify sets the anomaly fields of entry.
+func (c *TrafficClassifier) Classify(entry *LogEntry) {
+	entry.AnomalyScore = c.Score(*entry)
+	entry.IsAnomalous = entry.AnomalyScore > c.threshold
+}
+
+// uaEntropyFeature is 1 for a missing user agent and otherwise grows with
+// the distance of its entropy from that of a typical browser, catching both
+// random strings and short hand-written agents.
+func uaEntropyFeature(ua string) float64 {
+	if ua == "" || ua == "-" {
+		return 1
+	}
+	return capped(math.Abs(shannonEntropy(ua)-typicalUAEntropy), typicalUAEntropy)
+}
+
+// shannonEntropy returns the entropy of s in bits per character.
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	var n int
+	for _, r := range s {
+		counts[r]++
+		n++
+	}
+
+	var h float64
+	for _, count := range counts {
+		p := float64(count) / float64(n)
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+// capped scales v to [0, 1] relative to limit.
+func capped(v, limit float64) float64 {
+	return min(v/limit, 1)
+}
@@ -0,0 +1,63 @@
+package nginxviz
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apacheLogRegex matches Apache httpd's common, combined and vhost_combined
+// formats:
+//
+//	common:         %h %l %u %t "%r" %>s %b
+//	combined:       %h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-agent}i"
+//	vhost_combined: %v:%p %h %l %u %t "%r" %>s %O "%{Referer}i" "%{User-Agent}i"
+//
+// Unlike nginx, Apache logs "-" for an empty body and may quote the user.
+var apacheLogRegex = regexp.MustCompile(`^(?:(\S+):\d+ )?(\S+) \S+ (?:"[^"]*"|\S+) \[([^\]]+)\] "([^"]*)" (\d{3}) (\d+|-)(?: "([^"]*)" "([^"]*)")?`)
+
+func init() {
+	RegisterParser("apache", func(Config) (Parser, error) { return apacheLogParser{}, nil })
+}
+
+// apacheLogParser parses Apache httpd access logs.
+type apacheLogParser struct{}
+
+func (apacheLogParser) Parse(line string) (LogEntry, error) {
+	matches := apacheLogRegex.FindStringSubmatch(line)
+	if matches == nil {
+		return LogEntry{}, fmt.Errorf("failed to parse Apache log line: %s", line)
+	}
+
+	timestamp, err := time.Parse(nginxTimeLayout, matches[3])
+	if err != nil {
+		timestamp = time.Now()
+	}
+
+	// Requests that timed out before sending a request line are logged as "-"
+	var method, url, protocol string
+	if request := strings.Fields(matches[4]); len(request) >= 2 {
+		method, url = request[0], request[1]
+		if len(request) >= 3 {
+			protocol = request[2]
+		}
+	}
+
+	statusCode, _ := strconv.Atoi(matches[5])
+	size, _ := strconv.Atoi(matches[6])
+
+	return LogEntry{
+		Timestamp:  timestamp,
+		IP:         matches[2],
+		Method:     method,
+		URL:        url,
+		Protocol:   protocol,
+		StatusCode: statusCode,
+		Size:       size,
+		Referer:    matches[7],
+		UserAgent:  matches[8],
+		Host:       matches[1],
+	}, nil
+}
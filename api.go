@@ -0,0 +1,199 @@
+package nginxviz
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	ipOverviewHistoryLimit = 20
+	recentDefaultLimit     = 100
+)
+
+type ipOverview struct {
+	IP               string     `json:"ip"`
+	Country          string     `json:"country"`
+	CountryFull      string     `json:"country_full"`
+	City             string     `json:"city,omitempty"`
+	Region           string     `json:"region,omitempty"`
+	Latitude         float64    `json:"latitude,omitempty"`
+	Longitude        float64    `json:"longitude,omitempty"`
+	ASN              uint       `json:"asn,omitempty"`
+	ASOrg            string     `json:"as_org,omitempty"`
+	ISP              string     `json:"isp,omitempty"`
+	Hosting          bool       `json:"hosting,omitempty"`
+	Hostname         string     `json:"hostname,omitempty"`
+	RequestsLastHour int        `json:"requests_last_hour"`
+	BotScore         float64    `json:"bot_score"` // of the latest entry in History
+	IsBot            bool       `json:"is_bot"`
+	Alerts           []string   `json:"alerts"`
+	History          []LogEntry `json:"history"`
+}
+
+func writeJSON(w http.ResponseWriter, payload any) {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		returnError(w, http.StatusInternalServerError, "error encoding response")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(js)
+}
+
+// MakeIPOverviewHandler returns everything known about a single client IP.
+func (s *Server) MakeIPOverviewHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip, err := netip.ParseAddr(mux.Vars(r)["ip"])
+		if err != nil {
+			returnError(w, http.StatusBadRequest, "invalid ip address")
+			return
+		}
+
+		overview := ipOverview{
+			IP:      ip.String(),
+			Alerts:  []string{},
+			History: []LogEntry{},
+		}
+
+		record, err := s.lookupIP(ip)
+		if err == nil {
+			var located LogEntry
+			record.apply(&located)
+			overview.Country, overview.CountryFull = located.Country, located.CountryFull
+			overview.City, overview.Region = located.City, located.Region
+			overview.Latitude, overview.Longitude = located.Latitude, located.Longitude
+			overview.ASN, overview.ASOrg = located.ASN, located.ASOrg
+			overview.ISP, overview.Hosting = located.ISP, located.Hosting
+		}
+
+		if s.resolver != nil {
+			overview.Hostname, _ = s.resolver.Hostname(overview.IP)
+		}
+
+		if s.rates != nil && s.rates.Exceeded(s.rates.Rate(overview.IP, time.Now())) {
+			overview.Alerts = append(overview.Alerts, "rate_exceeded")
+		}
+		if s.threats != nil {
+			if _, _, listed := s.threats.Match(ip); listed {
+				overview.Alerts = append(overview.Alerts, "threat_list")
+			}
+		}
+		if s.torExits != nil {
+			if _, _, tor := s.torExits.Match(ip); tor {
+				overview.Alerts = append(overview.Alerts, "tor")
+			}
+		}
+
+		var honeypotHit, spoofedCrawler bool
+		hourAgo := time.Now().Add(-time.Hour)
+		entries := s.history.Snapshot()
+		for i := len(entries) - 1; i >= 0; i-- {
+			entry := entries[i]
+			if entry.IP != overview.IP {
+				continue
+			}
+			if len(overview.History) == 0 {
+				overview.BotScore, overview.IsBot = entry.BotScore, entry.IsBot
+			}
+			honeypotHit = honeypotHit || entry.HoneypotHit
+			spoofedCrawler = spoofedCrawler || entry.SpoofedCrawler
+			if entry.Timestamp.After(hourAgo) {
+				overview.RequestsLastHour++
+			}
+			if len(overview.History) < ipOverviewHistoryLimit {
+				overview.History = append(overview.History, s.publicEntry(entry))
+			}
+		}
+		if honeypotHit {
+			overview.Alerts = append(overview.Alerts, "honeypot_hit")
+		}
+		if spoofedCrawler {
+			overview.Alerts = append(overview.Alerts, "spoofed_crawler")
+		}
+
+		writeJSON(w, overview)
+	}
+}
+
+// MakeRecentHandler returns the last n entries from history, oldest first.
+// With ?page=, ?sort= or ?cursor= the history is paginated instead, n
+// entries per page. With ?fields= only the listed JSON fields of each entry
+// are returned.
+func (s *Server) MakeRecentHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		n := recentDefaultLimit
+		if param := query.Get("n"); param != "" {
+			var err error
+			n, err = strconv.Atoi(param)
+			if err != nil || n < 0 {
+				returnError(w, http.StatusBadRequest, "n must be a non-negative integer")
+				return
+			}
+		}
+
+		var projection FieldProjection
+		if fields := query.Get("fields"); fields != "" {
+			var err error
+			projection, err = ParseFieldProjection(fields)
+			if err != nil {
+				returnError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if !s.cfg.BroadcastCookies && slices.Contains(projection, "cookie_header") {
+				returnError(w, http.StatusBadRequest, "cookie_header requires -broadcast-cookies")
+				return
+			}
+		}
+
+		if !isPaginated(query) {
+			entries := s.history.Snapshot()
+			if n < len(entries) {
+				entries = entries[len(entries)-n:]
+			}
+			writeJSON(w, s.projectEntries(entries, projection))
+			return
+		}
+
+		req, err := parseRecentPageRequest(query, n)
+		if err != nil {
+			returnError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		entries, total, cursor := paginate(s.history.Since(0), req)
+		writeJSON(w, recentPage{
+			Total:   total,
+			Page:    req.Page,
+			PerPage: req.PerPage,
+			Sort:    req.Sort,
+			Cursor:  encodeCursor(cursor),
+			Entries: s.projectEntries(entries, projection),
+		})
+	}
+}
+
+// projectEntries applies projection to the public form of entries,
+// returning them whole when no fields were requested.
+func (s *Server) projectEntries(entries []LogEntry, projection FieldProjection) any {
+	public := make([]LogEntry, len(entries))
+	for i, entry := range entries {
+		public[i] = s.publicEntry(entry)
+	}
+	if projection == nil {
+		return public
+	}
+	projected := make([]map[string]any, len(public))
+	for i, entry := range public {
+		projected[i] = projection.Apply(entry)
+	}
+	return projected
+}
@@ -0,0 +1,67 @@
+package nginxviz
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// authConfig holds the optional credentials protecting the dashboard. When
+// neither a user/password pair nor a token is set, access stays open.
+type authConfig struct {
+	User  string
+	Pass  string
+	Token string
+}
+
+func (a authConfig) enabled() bool {
+	return a.User != "" || a.Token != ""
+}
+
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// authorized reports whether r carries valid Basic Auth credentials or the
+// shared token, either as a ?token= query parameter or a Bearer header.
+func (a authConfig) authorized(r *http.Request) bool {
+	if a.Token != "" {
+		token := r.URL.Query().Get("token")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			token = bearer
+		}
+		if token != "" && secureCompare(token, a.Token) {
+			return true
+		}
+	}
+
+	if a.User != "" {
+		user, pass, ok := r.BasicAuth()
+		if ok && secureCompare(user, a.User) && secureCompare(pass, a.Pass) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authMiddleware rejects unauthenticated requests before they reach the
+// wrapped handler, so websocket upgrades are refused before registration.
+func authMiddleware(cfg authConfig) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if !cfg.enabled() {
+			return h
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !cfg.authorized(r) {
+				if cfg.User != "" {
+					w.Header().Set("WWW-Authenticate", `Basic realm="nginxviz"`)
+				}
+				returnError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
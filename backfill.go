@@ -0,0 +1,165 @@
+package nginxviz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// backfillLineLimit caps how much of a line is read to find its timestamp.
+const backfillLineLimit = 64 << 10
+
+// backfillOffset returns where to start reading file so that only its last
+// lines lines, and only lines logged within since, are read, along with the
+// number of lines in that window. Either limit may be zero. Lines are walked
+// backwards from the end, so the cost depends on the size of the window
+// rather than the size of the file.
+func backfillOffset(file *os.File, lines int, since time.Duration, parser Parser) (int64, int, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	size := info.Size()
+	cutoff := time.Now().Add(-since)
+
+	count := 0
+	// excluded reports whether the line in [start, end) is outside the window
+	excluded := func(start, end int64) (bool, error) {
+		count++
+		if lines > 0 && count > lines {
+			return true, nil
+		}
+		if since <= 0 {
+			return false, nil
+		}
+
+		line := make([]byte, min(end-start, backfillLineLimit))
+		if _, err := file.ReadAt(line, start); err != nil {
+			return false, err
+		}
+		entry, err := parser.Parse(stripSyslogHeader(strings.TrimSpace(string(line))))
+		return err == nil && entry.Timestamp.Before(cutoff), nil
+	}
+
+	// The newline ending the last line does not start another one
+	lineEnd := size
+	if size > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, size-1); err != nil {
+			return 0, 0, err
+		}
+		if last[0] == '\n' {
+			lineEnd--
+		}
+	}
+
+	buf := make([]byte, 64<<10)
+	for pos := lineEnd; pos > 0; {
+		chunk := min(int64(len(buf)), pos)
+		pos -= chunk
+		if _, err := file.ReadAt(buf[:chunk], pos); err != nil {
+			return 0, 0, err
+		}
+
+		for i := chunk - 1; i >= 0; i-- {
+			if buf[i] != '\n' {
+				continue
+			}
+			newline := pos + i
+			if newline+1 < lineEnd {
+				out, err := excluded(newline+1, lineEnd)
+				if err != nil {
+					return 0, 0, err
+				}
+				if out {
+					return lineEnd + 1, count - 1, nil
+				}
+			}
+			lineEnd = newline
+		}
+	}
+
+	if lineEnd > 0 {
+		out, err := excluded(0, lineEnd)
+		if err != nil {
+			return 0, 0, err
+		}
+		if out {
+			return lineEnd + 1, count - 1, nil
+		}
+	}
+	return 0, count, nil
+}
+
+// backfillSeek positions a newly followed access log at the start of the
+// -backfill window, or returns 0 to read it whole when no window is set.
+// When the window reaches back past the start of the file, the rotated
+// files before it make up the rest.
+func (s *Server) backfillSeek(ctx context.Context, file *os.File) (int64, error) {
+	if s.cfg.Backfill <= 0 && s.cfg.BackfillSince <= 0 {
+		return 0, nil
+	}
+	offset, lines, err := backfillOffset(file, s.cfg.Backfill, s.cfg.BackfillSince, s.parser)
+	if err != nil {
+		return 0, fmt.Errorf("finding backfill start: %w", err)
+	}
+
+	if offset == 0 && (s.cfg.Backfill <= 0 || lines < s.cfg.Backfill) {
+		remaining := 0
+		if s.cfg.Backfill > 0 {
+			remaining = s.cfg.Backfill - lines
+		}
+		if err := s.backfillRotated(ctx, file.Name(), remaining); err != nil {
+			return 0, fmt.Errorf("backfilling rotated log files: %w", err)
+		}
+	}
+	return offset, nil
+}
+
+type backfillEntry struct {
+	Seq  uint64   `json:"seq"`
+	Data LogEntry `json:"data"`
+}
+
+// backfillUpdate carries the most recent entries to a newly connected
+// client in a single message.
+type backfillUpdate struct {
+	Type    string          `json:"type"`
+	Entries []backfillEntry `json:"entries"`
+}
+
+// backfillRequest asks the broadcaster to send recent entries to a new
+// client.
+type backfillRequest struct {
+	conn  *websocket.Conn
+	hosts hostFilter
+	tor   torFilter
+}
+
+// sendBackfill writes the last BackfillReplay entries of the history to a
+// new client. It runs on the broadcast goroutine, which is the only writer
+// to client connections.
+func (s *Server) sendBackfill(req backfillRequest) {
+	var entries []backfillEntry
+	for _, e := range s.history.Since(0) {
+		if !req.hosts.Matches(e.Entry.Host) || !req.tor.Matches(e.Entry.Tor) || s.blockList.IsMuted(e.Entry.IP) {
+			continue
+		}
+		entries = append(entries, backfillEntry{Seq: e.Seq, Data: s.publicEntry(e.Entry)})
+	}
+	if len(entries) == 0 {
+		return
+	}
+	entries = entries[max(0, len(entries)-s.cfg.BackfillReplay):]
+
+	message, err := json.Marshal(backfillUpdate{Type: "backfill", Entries: entries})
+	if err != nil {
+		return
+	}
+	req.conn.WriteMessage(websocket.TextMessage, message)
+}
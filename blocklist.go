@@ -0,0 +1,167 @@
+package nginxviz
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const maxBlockDuration = 24 * time.Hour
+
+type blockedIP struct {
+	IP        string    `json:"ip"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Muted     int       `json:"muted"` // entries withheld from the feed so far
+}
+
+// BlockList temporarily mutes IPs from the live feed. Entries from blocked
+// IPs are still recorded and counted, just not broadcast.
+type BlockList struct {
+	mu     sync.Mutex
+	blocks map[string]*blockedIP
+}
+
+func NewBlockList() *BlockList {
+	return &BlockList{blocks: make(map[string]*blockedIP)}
+}
+
+// Block mutes ip for duration, capped at maxBlockDuration.
+func (b *BlockList) Block(ip string, duration time.Duration) blockedIP {
+	if duration <= 0 || duration > maxBlockDuration {
+		duration = maxBlockDuration
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	block := &blockedIP{IP: ip, ExpiresAt: time.Now().Add(duration)}
+	b.blocks[ip] = block
+	return *block
+}
+
+func (b *BlockList) Unblock(ip string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, ok := b.blocks[ip]
+	delete(b.blocks, ip)
+	return ok
+}
+
+// Muted reports whether entries from ip should be withheld, counting them.
+// It is for the live feed, so each entry is counted once.
+func (b *BlockList) Muted(ip string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	block := b.active(ip)
+	if block == nil {
+		return false
+	}
+	block.Muted++
+	return true
+}
+
+// IsMuted reports whether entries from ip should be withheld without
+// counting them, for entries sent again from history.
+func (b *BlockList) IsMuted(ip string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.active(ip) != nil
+}
+
+// active returns the unexpired block of ip, or nil. b.mu must be held.
+func (b *BlockList) active(ip string) *blockedIP {
+	block, ok := b.blocks[ip]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(block.ExpiresAt) {
+		delete(b.blocks, ip)
+		return nil
+	}
+	return block
+}
+
+// Active returns the unexpired blocks ordered by expiry.
+func (b *BlockList) Active() []blockedIP {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	active := make([]blockedIP, 0, len(b.blocks))
+	for ip, block := range b.blocks {
+		if now.After(block.ExpiresAt) {
+			delete(b.blocks, ip)
+			continue
+		}
+		active = append(active, *block)
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].ExpiresAt.Before(active[j].ExpiresAt)
+	})
+	return active
+}
+
+type blockRequest struct {
+	IP       string `json:"ip"`
+	Duration string `json:"duration"`
+}
+
+// MakeBlockIPHandler mutes an IP from the live feed for a duration.
+func MakeBlockIPHandler(blockList *BlockList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req blockRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			returnError(w, http.StatusBadRequest, "invalid block request")
+			return
+		}
+
+		ip, err := netip.ParseAddr(req.IP)
+		if err != nil {
+			returnError(w, http.StatusBadRequest, "invalid ip address")
+			return
+		}
+
+		var duration time.Duration
+		if req.Duration != "" {
+			duration, err = time.ParseDuration(req.Duration)
+			if err != nil || duration < 0 {
+				returnError(w, http.StatusBadRequest, "invalid duration")
+				return
+			}
+		}
+
+		writeJSON(w, blockList.Block(ip.String(), duration))
+	}
+}
+
+// MakeBlockListHandler lists the active blocks.
+func MakeBlockListHandler(blockList *BlockList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, blockList.Active())
+	}
+}
+
+// MakeUnblockIPHandler removes a block before it expires.
+func MakeUnblockIPHandler(blockList *BlockList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip, err := netip.ParseAddr(mux.Vars(r)["ip"])
+		if err != nil {
+			returnError(w, http.StatusBadRequest, "invalid ip address")
+			return
+		}
+
+		if !blockList.Unblock(ip.String()) {
+			returnError(w, http.StatusNotFound, "ip is not blocked")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,113 @@
+package nginxviz
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	botThreshold           = 0.5
+	whitelistedBotMaxScore = 0.1
+)
+
+// crawlerPatterns mirror the user agent fragments the UI uses to render
+// crawlers.
+var crawlerPatterns = []string{
+	"bot", "crawler", "spider", "googlebot", "bingbot", "yahoo", "slurp",
+	"duckduckbot", "baiduspider", "yandexbot", "facebookexternalhit",
+	"twitterbot", "linkedinbot", "whatsapp", "telegrambot",
+}
+
+// scriptPatterns identify HTTP libraries and command line tools.
+var scriptPatterns = []string{
+	"curl", "wget", "python-requests", "python-urllib", "go-http-client",
+	"java/", "okhttp", "libwww-perl", "scrapy", "httpclient",
+}
+
+// WhitelistedBot is a known good crawler that should not be penalized.
+// UAPattern is matched as a substring of the user agent.
+type WhitelistedBot struct {
+	Name      string `json:"name"`
+	UAPattern string `json:"ua_pattern"`
+}
+
+// LoadBotWhitelist reads a JSON array of whitelisted bots.
+func LoadBotWhitelist(path string) ([]WhitelistedBot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var bots []WhitelistedBot
+	if err := json.Unmarshal(data, &bots); err != nil {
+		return nil, fmt.Errorf("parsing bot whitelist: %w", err)
+	}
+	for _, bot := range bots {
+		if bot.UAPattern == "" {
+			return nil, fmt.Errorf("bot whitelist entry %q has no ua_pattern", bot.Name)
+		}
+	}
+	return bots, nil
+}
+
+// botScore estimates how likely an entry was made by an automated client,
+// from 0 (browser) to 1 (certainly a bot).
+func botScore(entry LogEntry) float64 {
+	ua := strings.ToLower(entry.UserAgent)
+
+	var score float64
+	switch {
+	case ua == "" || ua == "-":
+		score = 0.6
+	case containsAny(ua, scriptPatterns):
+		score = 0.9
+	case containsAny(ua, crawlerPatterns):
+		score = 0.8
+	}
+
+	if entry.Suspicious {
+		score += 0.3
+	}
+	if entry.SpoofedCrawler {
+		score += 0.5
+	}
+	if entry.HoneypotHit {
+		score = 1
+	}
+	return min(score, 1)
+}
+
+// scoreBot sets the bot fields of entry, capping the score of whitelisted
+// and DNS verified crawlers so they are still recognized as bots but never
+// penalized.
+func scoreBot(entry *LogEntry, whitelist []WhitelistedBot) {
+	entry.BotScore = botScore(*entry)
+	entry.IsBot = entry.BotScore >= botThreshold
+
+	if entry.VerifiedCrawler != "" {
+		entry.BotScore = min(entry.BotScore, whitelistedBotMaxScore)
+		entry.IsBot = true
+		entry.IsWhitelistedBot = true
+		return
+	}
+
+	for _, bot := range whitelist {
+		if strings.Contains(entry.UserAgent, bot.UAPattern) {
+			entry.BotScore = min(entry.BotScore, whitelistedBotMaxScore)
+			entry.IsBot = true
+			entry.IsWhitelistedBot = true
+			return
+		}
+	}
+}
+
+func containsAny(s string, patterns []string) bool {
+	for _, p := range patterns {
+		if strings.Contains(s, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,402 @@
+package nginxviz
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bucketBatchObjects is how many new objects are read before their entries
+// are sorted by time and submitted.
+const bucketBatchObjects = 20
+
+// emptyPayloadHash is the SHA-256 of an empty request body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// bucketObject is an object found under the bucket prefix.
+type bucketObject struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	Size         int64     `xml:"Size"`
+}
+
+// bucketClient lists and downloads objects through the S3 API, which GCS
+// also serves for HMAC keys. Requests are signed with AWS Signature
+// Version 4 when credentials are set and anonymous otherwise.
+type bucketClient struct {
+	base      string // URL that object keys are appended to
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+	token     string // session token of temporary credentials
+	http      *http.Client
+}
+
+// newBucketClient returns a client for a location such as
+// s3://bucket/prefix/ or gs://bucket/prefix/. endpoint replaces the
+// provider's, for S3 compatible stores such as MinIO. Credentials come from
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, and the
+// S3 region from AWS_REGION.
+func newBucketClient(location, endpoint string) (*bucketClient, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing bucket name")
+	}
+	c := &bucketClient{
+		bucket:    u.Host,
+		prefix:    strings.TrimPrefix(u.Path, "/"),
+		region:    os.Getenv("AWS_REGION"),
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+		http:      &http.Client{Timeout: 5 * time.Minute},
+	}
+	if c.region == "" {
+		c.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if c.region == "" {
+		c.region = "us-east-1"
+	}
+
+	switch {
+	case u.Scheme != "s3" && u.Scheme != "gs":
+		return nil, fmt.Errorf("must start with s3:// or gs://")
+	case endpoint != "":
+		c.base = strings.TrimSuffix(endpoint, "/") + "/" + c.bucket
+	case u.Scheme == "gs":
+		c.base = "https://storage.googleapis.com/" + c.bucket
+		c.region = "auto"
+	default:
+		c.base = "https://" + c.bucket + ".s3." + c.region + ".amazonaws.com"
+	}
+	return c, nil
+}
+
+func (c *bucketClient) get(ctx context.Context, key string, query url.Values) (*http.Response, error) {
+	u, err := url.Parse(c.base + "/" + awsURIEncode(key, false))
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = awsCanonicalQuery(query)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.accessKey != "" {
+		c.sign(req, time.Now().UTC())
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var apiErr struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr)
+		return nil, fmt.Errorf("bucket %s: %s %s %s", c.bucket, resp.Status, apiErr.Code, apiErr.Message)
+	}
+	return resp, nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to a GET
+// request without a body.
+func (c *bucketClient) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	values := []string{req.URL.Host, emptyPayloadHash, amzDate}
+	if c.token != "" {
+		req.Header.Set("X-Amz-Security-Token", c.token)
+		headers = append(headers, "x-amz-security-token")
+		values = append(values, c.token)
+	}
+
+	var canonicalHeaders strings.Builder
+	for i, name := range headers {
+		canonicalHeaders.WriteString(name + ":" + values[i] + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	scope := day + "/" + c.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), day)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsURIEncode percent-encodes everything but unreserved characters, the
+// way Signature Version 4 expects. Slashes are kept in paths and encoded in
+// query values.
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// awsCanonicalQuery encodes query sorted by name, as signing requires.
+func awsCanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, awsURIEncode(name, true)+"="+awsURIEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// list returns every object under the prefix, in key order.
+func (c *bucketClient) list(ctx context.Context) ([]bucketObject, error) {
+	var objects []bucketObject
+	query := url.Values{"list-type": {"2"}, "prefix": {c.prefix}}
+	for {
+		resp, err := c.get(ctx, "", query)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents              []bucketObject `xml:"Contents"`
+			IsTruncated           bool           `xml:"IsTruncated"`
+			NextContinuationToken string         `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decoding object list: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			if !strings.HasSuffix(object.Key, "/") {
+				objects = append(objects, object)
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// readObject passes every line of an object to handle, decompressing keys
+// ending in .gz as ALB and CloudFront deliver them.
+func (c *bucketClient) readObject(ctx context.Context, key string, handle lineHandler) error {
+	resp, err := c.get(ctx, key, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var r io.Reader = resp.Body
+	if strings.HasSuffix(key, ".gz") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return forEachLine(ctx, r, handle)
+}
+
+// bucketObjects is the set of object keys already read.
+type bucketObjects struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+func newBucketObjects(saved []string) *bucketObjects {
+	keys := make(map[string]bool, len(saved))
+	for _, key := range saved {
+		keys[key] = true
+	}
+	return &bucketObjects{keys: keys}
+}
+
+func (o *bucketObjects) add(key string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.keys[key] = true
+}
+
+func (o *bucketObjects) has(key string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.keys[key]
+}
+
+// retain forgets the keys that are no longer listed, such as objects a
+// lifecycle rule expired, so the set does not grow forever.
+func (o *bucketObjects) retain(objects []bucketObject) {
+	listed := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		listed[object.Key] = true
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for key := range o.keys {
+		if !listed[key] {
+			delete(o.keys, key)
+		}
+	}
+}
+
+func (o *bucketObjects) snapshot() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	keys := make([]string, 0, len(o.keys))
+	for key := range o.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// readBucket lists the bucket every BucketInterval and reads the objects it
+// has not read yet, until ctx is cancelled. On the first listing without a
+// saved state, -from-end marks the objects already there as read and
+// -backfill-since skips those last modified before its window.
+func (s *Server) readBucket(ctx context.Context, client *bucketClient) {
+	first := len(s.objects.snapshot()) == 0
+	for {
+		objects, err := client.list(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Error("Error listing bucket", "bucket", s.cfg.Bucket, "err", err)
+		} else {
+			s.objects.retain(objects)
+			if first {
+				s.skipOldObjects(objects)
+				first = false
+			}
+			if err := s.readNewObjects(ctx, client, objects); err != nil && ctx.Err() == nil {
+				slog.Error("Error reading bucket", "bucket", s.cfg.Bucket, "err", err)
+			}
+		}
+		if sleepContext(ctx, s.cfg.BucketInterval) != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) skipOldObjects(objects []bucketObject) {
+	since := time.Now().Add(-s.cfg.BackfillSince)
+	for _, object := range objects {
+		if s.cfg.FromEnd || s.cfg.BackfillSince > 0 && object.LastModified.Before(since) {
+			s.objects.add(object.Key)
+		}
+	}
+}
+
+// readNewObjects reads the unread objects in key order, which for ALB and
+// CloudFront logs is the order of time. Each batch's entries are sorted by
+// timestamp before being submitted, and its objects marked as read after.
+func (s *Server) readNewObjects(ctx context.Context, client *bucketClient, objects []bucketObject) error {
+	var batch []string
+	for _, object := range objects {
+		if !s.objects.has(object.Key) {
+			batch = append(batch, object.Key)
+		}
+		if len(batch) == bucketBatchObjects {
+			if err := s.readObjectBatch(ctx, client, batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		return s.readObjectBatch(ctx, client, batch)
+	}
+	return nil
+}
+
+func (s *Server) readObjectBatch(ctx context.Context, client *bucketClient, keys []string) error {
+	var entries []LogEntry
+	for _, key := range keys {
+		slog.Info("Reading bucket object", "bucket", client.bucket, "key", key)
+		err := client.readObject(ctx, key, func(ctx context.Context, line string) error {
+			if entry, ok := s.processLogLine(line); ok {
+				entries = append(entries, entry)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", key, err)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Timestamp.Before(entries[j].Timestamp) })
+	for _, entry := range entries {
+		if err := s.submitEntry(ctx, entry); err != nil {
+			return err
+		}
+	}
+	for _, key := range keys {
+		s.objects.add(key)
+	}
+	return nil
+}
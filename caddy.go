@@ -0,0 +1,153 @@
+package nginxviz
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterParser("caddy", func(cfg Config) (Parser, error) {
+		return caddyLogParser{trustXFF: cfg.TrustXFF}, nil
+	})
+}
+
+// caddyLogEntry is the part of a Caddy access log line that is used.
+// Timestamps and durations are numbers unless Caddy's time_format or
+// duration_format options changed them to strings.
+type caddyLogEntry struct {
+	TS      json.RawMessage `json:"ts"`
+	Request struct {
+		RemoteIP   string              `json:"remote_ip"`
+		RemoteAddr string              `json:"remote_addr"` // Caddy before 2.5
+		ClientIP   string              `json:"client_ip"`
+		Method     string              `json:"method"`
+		Proto      string              `json:"proto"`
+		Host       string              `json:"host"`
+		URI        string              `json:"uri"`
+		Headers    map[string][]string `json:"headers"`
+		TLS        *struct {
+			Version     uint16 `json:"version"`
+			CipherSuite uint16 `json:"cipher_suite"`
+		} `json:"tls"`
+	} `json:"request"`
+	Duration    json.RawMessage     `json:"duration"`
+	Size        int                 `json:"size"`
+	Status      int                 `json:"status"`
+	RespHeaders map[string][]string `json:"resp_headers"`
+}
+
+// caddyLogParser parses Caddy's structured JSON access logs.
+type caddyLogParser struct {
+	trustXFF bool
+}
+
+func (p caddyLogParser) Parse(line string) (LogEntry, error) {
+	var c caddyLogEntry
+	if err := json.Unmarshal([]byte(line), &c); err != nil {
+		return LogEntry{}, fmt.Errorf("failed to parse Caddy log line: %w", err)
+	}
+
+	// client_ip already accounts for Caddy's trusted_proxies
+	ip := c.Request.ClientIP
+	if ip == "" {
+		ip = c.Request.RemoteIP
+	}
+	if ip == "" {
+		ip, _, _ = net.SplitHostPort(c.Request.RemoteAddr)
+	}
+	if ip == "" {
+		return LogEntry{}, fmt.Errorf("failed to parse Caddy log line, missing client address: %s", line)
+	}
+
+	entry := LogEntry{
+		Timestamp:           parseCaddyTimestamp(c.TS),
+		IP:                  ip,
+		Method:              c.Request.Method,
+		URL:                 c.Request.URI,
+		StatusCode:          c.Status,
+		Size:                c.Size,
+		UserAgent:           caddyHeader(c.Request.Headers, "User-Agent"),
+		Referer:             caddyHeader(c.Request.Headers, "Referer"),
+		RequestID:           caddyHeader(c.Request.Headers, "X-Request-Id"),
+		CookieHeader:        strings.Join(c.Request.Headers["Cookie"], "; "),
+		ResponseContentType: caddyHeader(c.RespHeaders, "Content-Type"),
+		RequestTime:         parseCaddyDuration(c.Duration),
+		Host:                c.Request.Host,
+		Protocol:            c.Request.Proto,
+	}
+	if t := c.Request.TLS; t != nil {
+		entry.TLSProtocol = caddyTLSVersion(t.Version)
+		entry.TLSCipher = tls.CipherSuiteName(t.CipherSuite)
+	}
+	if p.trustXFF {
+		applyForwardedFor(&entry, caddyHeader(c.Request.Headers, "X-Forwarded-For"))
+	}
+	return entry, nil
+}
+
+// caddyHeader returns the first value of a header. Caddy logs headers in
+// their canonical form.
+func caddyHeader(headers map[string][]string, name string) string {
+	if values := headers[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// caddyTLSVersion names a TLS version the way nginx's $ssl_protocol does,
+// so both servers land in the same buckets.
+func caddyTLSVersion(version uint16) string {
+	switch version {
+	case 0:
+		return ""
+	case tls.VersionTLS10:
+		return "TLSv1"
+	case tls.VersionTLS11:
+		return "TLSv1.1"
+	case tls.VersionTLS12:
+		return "TLSv1.2"
+	case tls.VersionTLS13:
+		return "TLSv1.3"
+	}
+	return fmt.Sprintf("0x%04X", version)
+}
+
+// parseCaddyTimestamp accepts Unix seconds with a fraction, the default, or
+// an RFC 3339 string, falling back to the current time.
+func parseCaddyTimestamp(raw json.RawMessage) time.Time {
+	var seconds float64
+	if err := json.Unmarshal(raw, &seconds); err == nil {
+		whole, frac := math.Modf(seconds)
+		return time.Unix(int64(whole), int64(frac*1e9))
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return parseJSONTimestamp(s)
+	}
+	return time.Now()
+}
+
+// parseCaddyDuration returns a duration in seconds, logged either as a
+// number of seconds or as a Go duration string.
+func parseCaddyDuration(raw json.RawMessage) float64 {
+	var seconds float64
+	if err := json.Unmarshal(raw, &seconds); err == nil {
+		return seconds
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		if d, err := time.ParseDuration(s); err == nil {
+			return d.Seconds()
+		}
+		seconds, _ = strconv.ParseFloat(s, 64)
+	}
+	return seconds
+}
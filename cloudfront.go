@@ -0,0 +1,130 @@
+package nginxviz
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const cloudFrontTimeLayout = "2006-01-02 15:04:05"
+
+// Columns of the CloudFront standard log format. AWS only ever appends new
+// fields, so the positions are stable.
+const (
+	cfDate = iota
+	cfTime
+	cfEdgeLocation
+	cfBytes
+	cfClientIP
+	cfMethod
+	cfHost
+	cfURIStem
+	cfStatus
+	cfReferer
+	cfUserAgent
+	cfURIQuery
+	cfCookie
+	cfResultType
+	cfRequestID
+	cfHostHeader
+	cfProtocol
+	cfRequestBytes
+	cfTimeTaken
+	cfForwardedFor
+	cfSSLProtocol
+	cfSSLCipher
+	cfResponseResultType
+	cfProtocolVersion
+	cfContentType = 29
+)
+
+func init() {
+	RegisterParser("cloudfront", func(cfg Config) (Parser, error) {
+		return cloudFrontLogParser{trustXFF: cfg.TrustXFF}, nil
+	})
+}
+
+// cloudFrontLogParser parses CloudFront standard (access) logs, as synced
+// down from S3: tab separated, with the date and time in separate columns
+// and #Version and #Fields header lines.
+type cloudFrontLogParser struct {
+	trustXFF bool
+}
+
+func (p cloudFrontLogParser) Parse(line string) (LogEntry, error) {
+	if strings.HasPrefix(line, "#") {
+		return LogEntry{}, ErrSkipLine
+	}
+
+	fields := strings.Split(line, "\t")
+	if len(fields) == 1 {
+		fields = strings.Fields(line)
+	}
+	if len(fields) <= cfURIQuery {
+		return LogEntry{}, fmt.Errorf("failed to parse CloudFront log line: %s", line)
+	}
+	get := func(column int) string {
+		if column >= len(fields) || fields[column] == "-" {
+			return ""
+		}
+		return fields[column]
+	}
+
+	// Without a valid date the line is not a CloudFront log line at all,
+	// which matters since space separated lines are accepted too
+	timestamp, err := time.Parse(cloudFrontTimeLayout, get(cfDate)+" "+get(cfTime))
+	if err != nil {
+		return LogEntry{}, fmt.Errorf("failed to parse CloudFront log line, invalid date: %s", line)
+	}
+
+	requestURL := get(cfURIStem)
+	if query := get(cfURIQuery); query != "" {
+		requestURL += "?" + query
+	}
+
+	// CloudFront URL-encodes the user agent and referer
+	userAgent, err := url.PathUnescape(get(cfUserAgent))
+	if err != nil {
+		userAgent = get(cfUserAgent)
+	}
+	referer, err := url.PathUnescape(get(cfReferer))
+	if err != nil {
+		referer = get(cfReferer)
+	}
+
+	statusCode, _ := strconv.Atoi(get(cfStatus))
+	size, _ := strconv.Atoi(get(cfBytes))
+	requestTime, _ := strconv.ParseFloat(get(cfTimeTaken), 64)
+
+	entry := LogEntry{
+		Timestamp:           timestamp,
+		IP:                  get(cfClientIP),
+		Method:              get(cfMethod),
+		URL:                 requestURL,
+		StatusCode:          statusCode,
+		Size:                size,
+		UserAgent:           userAgent,
+		Referer:             referer,
+		RequestID:           get(cfRequestID),
+		CookieHeader:        get(cfCookie),
+		ResponseContentType: get(cfContentType),
+		RequestTime:         requestTime,
+		Host:                get(cfHostHeader),
+		Protocol:            get(cfProtocolVersion),
+		TLSProtocol:         get(cfSSLProtocol),
+		TLSCipher:           get(cfSSLCipher),
+		Extra: map[string]string{
+			"edge_location": get(cfEdgeLocation),
+			"result_type":   get(cfResultType),
+		},
+	}
+	if entry.IP == "" {
+		return LogEntry{}, fmt.Errorf("failed to parse CloudFront log line, missing c-ip: %s", line)
+	}
+	if p.trustXFF {
+		applyForwardedFor(&entry, get(cfForwardedFor))
+	}
+	return entry, nil
+}
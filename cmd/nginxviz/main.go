@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kif11/nginxviz"
+	"gopkg.in/yaml.v3"
+)
+
+// newLogger builds a JSON structured logger writing level, ts and msg to stderr.
+func newLogger(level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, err
+	}
+
+	handler := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
+		Level: lvl,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				a.Key = "ts"
+			}
+			return a
+		},
+	})
+
+	return slog.New(handler), nil
+}
+
+// fatal logs err at error level and exits the process.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+// listen opens a TCP listener, or a UNIX domain socket when address has the
+// "unix:" prefix. Socket files are created with 0660 permissions.
+func listen(address string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(address, "unix:")
+	if !ok {
+		return net.Listen("tcp", address)
+	}
+
+	// Remove a stale socket left behind by an unclean exit
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// stringList is a flag.Value collecting repeated or comma-separated values.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*s = append(*s, v)
+		}
+	}
+	return nil
+}
+
+// repeatedList is a flag.Value collecting the values of a repeated flag as
+// given, for values such as regular expressions and URLs that may contain
+// commas.
+type repeatedList []string
+
+func (s *repeatedList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *repeatedList) Set(value string) error {
+	if value = strings.TrimSpace(value); value != "" {
+		*s = append(*s, value)
+	}
+	return nil
+}
+
+// options are the settings used by the command itself rather than the
+// server. They can be set in the same config file as nginxviz.Config.
+type options struct {
+	Listen       string        `yaml:"listen"`
+	LogLevel     string        `yaml:"log_level"`
+	WSLogLevel   string        `yaml:"ws_log_level"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+}
+
+// loadConfigFile applies a YAML config file, then re-applies the flags given
+// on the command line so they take precedence over the file.
+func loadConfigFile(path string, cfg *nginxviz.Config, opts *options) error {
+	// Repeated flags are kept whole, as their values cannot be split back
+	// out of String
+	explicit := make(map[string]string)
+	repeated := make(map[string][]string)
+	flag.Visit(func(f *flag.Flag) {
+		if list, ok := f.Value.(*repeatedList); ok {
+			repeated[f.Name] = slices.Clone(*list)
+			return
+		}
+		explicit[f.Name] = f.Value.String()
+	})
+
+	if err := nginxviz.LoadConfigFile(path, cfg); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(data, opts); err != nil {
+		return err
+	}
+
+	for name, value := range explicit {
+		f := flag.Lookup(name)
+		switch list := f.Value.(type) {
+		case *stringList:
+			*list = nil
+		case *nginxviz.ExtractRules:
+			*list = nil
+		}
+		if err := f.Value.Set(value); err != nil {
+			return err
+		}
+	}
+	for name, values := range repeated {
+		*flag.Lookup(name).Value.(*repeatedList) = values
+	}
+	return nil
+}
+
+// flagKeys returns the config file keys of the cfg fields set by flags
+// given on the command line, found by the fields the flags point to.
+func flagKeys(cfg *nginxviz.Config) []string {
+	fields := make(map[uintptr]string)
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		key, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		if key != "" && key != "-" {
+			fields[v.Field(i).Addr().Pointer()] = key
+		}
+	}
+
+	var keys []string
+	flag.Visit(func(f *flag.Flag) {
+		value := reflect.ValueOf(f.Value)
+		if value.Kind() != reflect.Pointer {
+			return
+		}
+		if key, ok := fields[value.Pointer()]; ok {
+			keys = append(keys, key)
+		}
+	})
+	return keys
+}
+
+func main() {
+
+	// Parse command line arguments
+	cfg := nginxviz.DefaultConfig()
+	opts := options{
+		Listen:       "127.0.0.1:9001",
+		LogLevel:     "info",
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+	flag.StringVar(&cfg.ConfigFile, "config", cfg.ConfigFile, "YAML config file; flags given on the command line take precedence over it")
+	flag.DurationVar(&cfg.ConfigWatchInterval, "config-watch-interval", cfg.ConfigWatchInterval, "How often -config is checked for changes")
+	flag.StringVar(&cfg.LogFile, "i", cfg.LogFile, "Path to the nginx log file to watch, - for stdin, journald:nginx.service for a unit's systemd journal, ssh://user@host:/var/log/nginx/access.log for a file on another host, or a quoted glob such as '/var/log/nginx/*.access.log' to follow every matching file")
+	flag.StringVar(&cfg.DockerContainer, "docker", cfg.DockerContainer, "Read the access log from the stdout and the error log from the stderr of this Docker container instead of -i")
+	flag.StringVar(&cfg.DockerSocket, "docker-socket", cfg.DockerSocket, "Unix socket of the Docker Engine API used by -docker")
+	flag.StringVar(&cfg.ListenSyslog, "listen-syslog", cfg.ListenSyslog, "Receive log lines as syslog messages over UDP and TCP on this address, e.g. :5140, instead of reading -i")
+	flag.StringVar(&cfg.ListenForward, "listen-forward", cfg.ListenForward, "Receive events over the Fluentd forward protocol on this TCP address, e.g. :24224, instead of reading -i")
+	flag.StringVar(&cfg.KafkaBrokers, "kafka-brokers", cfg.KafkaBrokers, "Comma separated Kafka brokers of -kafka-topic")
+	flag.StringVar(&cfg.KafkaTopic, "kafka-topic", cfg.KafkaTopic, "Read log lines from the messages of this Kafka topic instead of -i; requires kcat")
+	flag.StringVar(&cfg.KafkaGroup, "kafka-group", cfg.KafkaGroup, "Kafka consumer group to join, so offsets are committed and the partitions shared with other members")
+	flag.StringVar(&cfg.RedisURL, "redis-url", cfg.RedisURL, "URL of the Redis server holding -redis-stream, e.g. redis://:password@host:6379/0")
+	flag.StringVar(&cfg.RedisStream, "redis-stream", cfg.RedisStream, "Read log lines or entries from this Redis stream instead of -i")
+	flag.BoolVar(&cfg.Demo, "demo", cfg.Demo, "Generate fake traffic from around the world instead of reading -i, for trying out or developing the frontend")
+	flag.Float64Var(&cfg.DemoRate, "demo-rate", cfg.DemoRate, "Average requests a second -demo generates")
+	flag.BoolVar(&cfg.Replay, "replay", cfg.Replay, "Play -i back paced by its timestamps, as if it were happening now, instead of following it")
+	flag.Var(&cfg.ReplaySpeed, "speed", "How many times faster than it happened -replay plays, e.g. 10x")
+	flag.TextVar(&cfg.ReplayStart, "replay-start", cfg.ReplayStart, "Start -replay at this time, e.g. 2024-01-01T00:00:00Z")
+	flag.TextVar(&cfg.ReplayEnd, "replay-end", cfg.ReplayEnd, "Stop -replay after this time")
+	flag.StringVar(&cfg.Bucket, "bucket", cfg.Bucket, "Read log objects delivered under this s3://bucket/prefix or gs://bucket/prefix instead of -i, e.g. ALB or CloudFront logs")
+	flag.StringVar(&cfg.BucketEndpoint, "bucket-endpoint", cfg.BucketEndpoint, "URL of an S3 compatible store holding -bucket, such as MinIO")
+	flag.DurationVar(&cfg.BucketInterval, "bucket-interval", cfg.BucketInterval, "How often -bucket is listed for new objects")
+	flag.BoolVar(&cfg.Collector, "collector", cfg.Collector, "Receive entries from -agent instances instead of reading -i; requires -ingest-token")
+	flag.StringVar(&cfg.Agent, "agent", cfg.Agent, "Forward parsed entries to the collector at this URL, e.g. https://viz.example.com, authenticating with -ingest-token")
+	flag.StringVar(&cfg.AgentSource, "agent-source", cfg.AgentSource, "Label of this agent's entries at the collector (default: the hostname)")
+	flag.StringVar(&cfg.KubeSelector, "kube-selector", cfg.KubeSelector, "Read the logs of the running Kubernetes pods matching this label selector instead of -i, e.g. app.kubernetes.io/name=ingress-nginx")
+	flag.StringVar(&cfg.KubeNamespace, "kube-namespace", cfg.KubeNamespace, "Namespace of the -kube-selector pods (default: the service account's namespace)")
+	flag.StringVar(&cfg.KubeContainer, "kube-container", cfg.KubeContainer, "Container of the -kube-selector pods to read, needed when the pods have several")
+	flag.StringVar(&cfg.KubeAPI, "kube-api", cfg.KubeAPI, "Kubernetes API URL, such as http://127.0.0.1:8001 for kubectl proxy (default: the in-cluster API server)")
+	flag.StringVar(&cfg.ErrorLogFile, "error-log", cfg.ErrorLogFile, "Path to an nginx error.log to watch as well, broadcast as error_entry messages")
+	flag.DurationVar(&cfg.IncidentWindow, "incident-window", cfg.IncidentWindow, "Pair 5xx entries with the error log lines for the same request logged within this long as incident messages (0 disables)")
+	flag.StringVar(&opts.Listen, "listen", opts.Listen, "Address to listen on, or unix:/path/to.sock for a UNIX domain socket")
+	flag.StringVar(&opts.LogLevel, "log-level", opts.LogLevel, "Log level: debug, info, warn or error")
+	flag.StringVar(&opts.WSLogLevel, "ws-log-level", opts.WSLogLevel, "Also send server logs at or above this level to admin websocket clients (disabled when empty)")
+	flag.IntVar(&cfg.HistorySize, "history-size", cfg.HistorySize, "Number of recent log entries kept in memory")
+	flag.StringVar(&cfg.GeoIPFile, "geoip", cfg.GeoIPFile, "MaxMind or DB-IP .mmdb country or city database to use instead of the embedded one, reloaded when it changes")
+	flag.StringVar(&cfg.GeoIPUpdate, "geoip-update", cfg.GeoIPUpdate, "Download the country database from maxmind, dbip or an .mmdb URL on startup and every -geoip-refresh, saving it to -geoip when set")
+	flag.DurationVar(&cfg.GeoIPRefresh, "geoip-refresh", cfg.GeoIPRefresh, "How often -geoip-update downloads the database")
+	flag.StringVar(&cfg.GeoIPAccountID, "geoip-account-id", cfg.GeoIPAccountID, "MaxMind account ID for -geoip-update maxmind")
+	flag.StringVar(&cfg.GeoIPLicenseKey, "geoip-license-key", cfg.GeoIPLicenseKey, "MaxMind license key for -geoip-update maxmind")
+	flag.Var((*repeatedList)(&cfg.ThreatLists), "threat-list", "IP blocklist file or URL (FireHOL .netset/.ipset, CIDR per line, CSV or AbuseIPDB JSON) whose addresses are tagged with threat_list. Repeatable")
+	flag.DurationVar(&cfg.ThreatRefresh, "threat-refresh", cfg.ThreatRefresh, "How often -threat-list files and URLs are reloaded")
+	flag.DurationVar(&cfg.SessionTimeout, "session-timeout", cfg.SessionTimeout, "Idle time after which a visitor's next request starts a new session, 0 disables visitor IDs and sessions")
+	flag.Var((*stringList)(&cfg.InternalDomains), "internal-domains", "Comma separated domains of the site, whose referers are classified as internal along with each entry's host")
+	flag.BoolVar(&cfg.Tor, "tor", cfg.Tor, "Tag entries from Tor exit nodes, using the list the Tor Project publishes")
+	flag.StringVar(&cfg.TorExitList, "tor-exit-list", cfg.TorExitList, "File or URL of the Tor exit node addresses used by -tor")
+	flag.DurationVar(&cfg.TorRefresh, "tor-refresh", cfg.TorRefresh, "How often -tor reloads the exit node list")
+	flag.StringVar(&cfg.ASNFile, "asn-db", cfg.ASNFile, "GeoLite2-ASN, DB-IP ASN Lite or GeoIP2-ISP .mmdb database adding the network and ISP of each address")
+	flag.BoolVar(&cfg.RDNS, "rdns", cfg.RDNS, "Resolve client IPs to hostnames with reverse DNS")
+	flag.DurationVar(&cfg.RDNSTTL, "rdns-ttl", cfg.RDNSTTL, "How long -rdns caches a hostname")
+	flag.IntVar(&cfg.RateThreshold, "rate-threshold", cfg.RateThreshold, "Requests per -rate-window after which an IP is flagged as suspicious (0 disables)")
+	flag.DurationVar(&cfg.RateWindow, "rate-window", cfg.RateWindow, "Sliding window used for per-IP rate detection")
+	flag.BoolVar(&cfg.DetectFormat, "detect-format", cfg.DetectFormat, "Detect the log format from the first lines of -i, falling back to -format when none matches")
+	flag.StringVar(&cfg.Format, "format", cfg.Format, "Log format: "+strings.Join(nginxviz.Parsers(), ", ")+" or an nginx log_format definition such as '$remote_addr [$time_local] \"$request\" $status'. JSON lines are auto-detected in combined logs")
+	flag.StringVar(&cfg.JSONKeys, "json-keys", cfg.JSONKeys, "Comma separated field=key overrides for JSON logs, e.g. ip=client,url=uri")
+	flag.StringVar(&cfg.DBOut, "db-out", cfg.DBOut, "Path to a SQLite database that every parsed request is written to")
+	flag.StringVar(&cfg.AuthUser, "auth-user", cfg.AuthUser, "Username required via HTTP Basic Auth (requires -auth-pass)")
+	flag.StringVar(&cfg.AuthPass, "auth-pass", cfg.AuthPass, "Password required via HTTP Basic Auth")
+	flag.StringVar(&cfg.AuthToken, "auth-token", cfg.AuthToken, "Token required as ?token= query parameter or Bearer header")
+	flag.StringVar(&cfg.IngestToken, "ingest-token", cfg.IngestToken, "Enable POST /api/ingest, requiring this token as ?token= query parameter or Bearer header")
+	flag.BoolVar(&cfg.TrustXFF, "trust-xff", cfg.TrustXFF, "Use the leftmost public IP of the logged X-Forwarded-For header as the client IP")
+	flag.IntVar(&cfg.MaxFieldSize, "max-field-size", cfg.MaxFieldSize, "Truncate URL, user agent and referer to this many bytes (0 disables)")
+	flag.IntVar(&cfg.MaxLineSize, "max-line-size", cfg.MaxLineSize, "Skip log lines longer than this many bytes (0 disables)")
+	flag.IntVar(&cfg.RequestIDField, "request-id-field", cfg.RequestIDField, "Column of $request_id in the log line, counting the 8 combined fields first (e.g. 9)")
+	flag.BoolVar(&cfg.Debug, "debug", cfg.Debug, "Send unparseable log lines to clients as parse_error messages")
+	flag.DurationVar(&opts.ReadTimeout, "read-timeout", opts.ReadTimeout, "Maximum duration for reading an HTTP request")
+	flag.DurationVar(&opts.WriteTimeout, "write-timeout", opts.WriteTimeout, "Maximum duration for writing an HTTP response (websockets are exempt)")
+	flag.DurationVar(&opts.IdleTimeout, "idle-timeout", opts.IdleTimeout, "How long idle keep-alive HTTP connections are kept open")
+	flag.DurationVar(&cfg.PingInterval, "ping-interval", cfg.PingInterval, "Interval between websocket pings; clients missing two pongs are dropped")
+	flag.BoolVar(&cfg.TrackSessions, "track-sessions", cfg.TrackSessions, "Alert admin clients when a session cookie is used from more than one IP")
+	flag.StringVar(&cfg.SessionCookie, "session-cookie", cfg.SessionCookie, "Name of the session cookie inspected by -track-sessions")
+	flag.IntVar(&cfg.CookieField, "cookie-field", cfg.CookieField, "Column of $http_cookie in the log line, counting the 8 combined fields first (e.g. 9)")
+	flag.BoolVar(&cfg.BroadcastCookies, "broadcast-cookies", cfg.BroadcastCookies, "Include the logged cookie header in websocket messages")
+	flag.StringVar(&cfg.TraceEndpoint, "trace-endpoint", cfg.TraceEndpoint, "Zipkin v2 collector URL to export a span per log entry to, e.g. http://localhost:9411/api/v2/spans")
+	flag.Float64Var(&cfg.TraceSampleRate, "trace-sample-rate", cfg.TraceSampleRate, "Fraction of log entries exported as spans")
+	flag.IntVar(&cfg.ContentTypeField, "content-type-field", cfg.ContentTypeField, "Column of $sent_http_content_type in the log line, counting the 8 combined fields first (e.g. 9)")
+	flag.IntVar(&cfg.RateLimitField, "rate-limit-field", cfg.RateLimitField, "Column of $limit_req_status in the log line, counting the 8 combined fields first (e.g. 9)")
+	flag.IntVar(&cfg.RequestTimeField, "request-time-field", cfg.RequestTimeField, "Column of $request_time in the log line, counting the 8 combined fields first (e.g. 9)")
+	flag.IntVar(&cfg.UpstreamTimeField, "upstream-time-field", cfg.UpstreamTimeField, "Column of $upstream_response_time in the log line, counting the 8 combined fields first (e.g. 10)")
+	flag.IntVar(&cfg.HostField, "host-field", cfg.HostField, "Column of $host in the log line, counting the 8 combined fields first (e.g. 9)")
+	flag.IntVar(&cfg.SSLProtocolField, "ssl-protocol-field", cfg.SSLProtocolField, "Column of $ssl_protocol in the log line, counting the 8 combined fields first (e.g. 9)")
+	flag.IntVar(&cfg.SSLCipherField, "ssl-cipher-field", cfg.SSLCipherField, "Column of $ssl_cipher in the log line, counting the 8 combined fields first (e.g. 10)")
+	flag.Float64Var(&cfg.RateLimitAlertThreshold, "rate-limit-alert-threshold", cfg.RateLimitAlertThreshold, "Fraction of requests rejected or delayed by limit_req within a minute that raises an admin alert")
+	flag.DurationVar(&cfg.LagAlertThreshold, "lag-alert-threshold", cfg.LagAlertThreshold, "Delay between nginx serving a request and its entry being processed that triggers a lag_update (0 disables)")
+	flag.Float64Var(&cfg.AnomalyThreshold, "anomaly-threshold", cfg.AnomalyThreshold, "Anomaly score (0-1) from URL and user agent features above which entries are flagged is_anomalous")
+	flag.BoolVar(&cfg.FromEnd, "from-end", cfg.FromEnd, "Start following log files at their end instead of reading what they already contain")
+	flag.IntVar(&cfg.Backfill, "backfill", cfg.Backfill, "Read only the last N lines of the log file on startup instead of all of it")
+	flag.DurationVar(&cfg.BackfillSince, "backfill-since", cfg.BackfillSince, "Read only the lines of the log file logged within this duration on startup, e.g. 24h")
+	flag.IntVar(&cfg.BackfillReplay, "backfill-replay", cfg.BackfillReplay, "Send each new websocket client the last N entries in a single backfill message (0 disables)")
+	flag.StringVar(&cfg.PositionFile, "position-file", cfg.PositionFile, "File that state such as log file positions and the learned URL patterns is saved to and restored from across restarts")
+	flag.StringVar(&cfg.SnapshotFile, "snapshot-file", cfg.SnapshotFile, "JSON lines file that a /api/stats snapshot is appended to every -snapshot-interval")
+	flag.DurationVar(&cfg.SnapshotInterval, "snapshot-interval", cfg.SnapshotInterval, "Interval between stats snapshots written to -snapshot-file")
+	flag.DurationVar(&cfg.ParseErrorSummaryInterval, "parse-error-summary-interval", cfg.ParseErrorSummaryInterval, "How often clients are told how many lines failed to parse, 0 to disable")
+	flag.Var(&cfg.SnapshotMaxSize, "snapshot-max-size", "Rotate -snapshot-file to a .1 file beyond this size, e.g. 100MB (0 disables)")
+	flag.IntVar(&cfg.GeoWorkers, "geo-workers", cfg.GeoWorkers, "Number of goroutines performing GeoIP lookups")
+	flag.IntVar(&cfg.GeoCacheSize, "geo-cache-size", cfg.GeoCacheSize, "Number of IPs whose GeoIP records are cached in memory")
+	flag.Var((*stringList)(&cfg.HoneypotURLs), "honeypot-urls", "Comma separated trap URLs (e.g. from robots.txt Disallow) that raise an admin alert when requested")
+	flag.StringVar(&cfg.BotWhitelistFile, "bot-whitelist-file", cfg.BotWhitelistFile, `JSON file listing good bots exempt from the bot score, e.g. [{"name":"Googlebot","ua_pattern":"Googlebot/"}]`)
+	flag.Var((*stringList)(&cfg.AdminAllowedCIDRs), "admin-allowed-cidrs", "Comma separated networks allowed to reach /api/admin (e.g. 192.168.0.0/16,10.0.0.0/8); loopback is always allowed")
+	flag.BoolVar(&cfg.NoLoopbackAdmin, "no-loopback-admin", cfg.NoLoopbackAdmin, "Do not implicitly allow loopback addresses with -admin-allowed-cidrs")
+	flag.StringVar(&cfg.NormalizeURLs, "normalize-urls", cfg.NormalizeURLs, "Split the decoded path from the query string (split), and replace numeric and UUID path segments with :id (collapse) or drop them (strip)")
+	flag.Var((*stringList)(&cfg.ScrubQueryParams), "scrub-query-params", "Comma separated query parameters whose values are redacted from URLs and referers, added to the defaults")
+	flag.Var(&cfg.ExtractFields, "extract", "Value to copy from each line into extra, as name=column (counting the 8 combined fields first) or name=regex whose first group is used. Repeatable")
+	flag.Var((*repeatedList)(&cfg.IgnoreURLs), "ignore-url", "URL pattern to hide from the visualization (wildcard, or regex with re: prefix). Repeatable")
+	flag.Parse()
+
+	if cfg.ConfigFile != "" {
+		if err := loadConfigFile(cfg.ConfigFile, &cfg, &opts); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -config: %v\n", err)
+			os.Exit(2)
+		}
+		cfg.FlagKeys = flagKeys(&cfg)
+	}
+
+	logger, err := newLogger(opts.LogLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -log-level: %v\n", err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+
+	vizServer, err := nginxviz.New(cfg)
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+	defer vizServer.Close()
+
+	if opts.WSLogLevel != "" {
+		var lvl slog.Level
+		if err := lvl.UnmarshalText([]byte(opts.WSLogLevel)); err != nil {
+			fatal("Invalid -ws-log-level", err)
+		}
+		slog.SetDefault(slog.New(vizServer.NewWebSocketSlogHandler(logger.Handler(), lvl)))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	runDone := make(chan struct{})
+	go func() {
+		defer close(runDone)
+		if err := vizServer.Run(ctx); err != nil {
+			fatal("Error running server", err)
+		}
+	}()
+
+	srv := &http.Server{
+		Handler:      vizServer.Handler(),
+		WriteTimeout: opts.WriteTimeout,
+		ReadTimeout:  opts.ReadTimeout,
+		IdleTimeout:  opts.IdleTimeout,
+	}
+
+	listener, err := listen(opts.Listen)
+	if err != nil {
+		fatal("Error opening listener", err)
+	}
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		slog.Info("Shutting down server")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Error shutting down server", "err", err)
+		}
+	}()
+
+	socketPath, isUnix := strings.CutPrefix(opts.Listen, "unix:")
+	if isUnix {
+		slog.Info("Starting server on UNIX socket", "path", socketPath)
+	} else {
+		slog.Info("Starting server on TCP", "addr", listener.Addr().String())
+	}
+
+	if err := srv.Serve(listener); err != http.ErrServerClosed {
+		fatal("Server stopped", err)
+	}
+	<-shutdownDone
+	<-runDone
+
+	if isUnix {
+		if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+			slog.Error("Error removing UNIX socket", "path", socketPath, "err", err)
+		}
+	}
+
+}
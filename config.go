@@ -0,0 +1,227 @@
+package nginxviz
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadConfigFile overlays the options set in a YAML config file onto cfg.
+// Keys missing from the file keep their current value.
+func LoadConfigFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("parsing config file: %w", err)
+	}
+	return nil
+}
+
+// ConfigWatcher polls the config file and applies changes to the
+// hot-reloadable options. Changes to any other key, including keys only
+// read by cmd/nginxviz such as listen, are recorded as pending a restart.
+type ConfigWatcher struct {
+	s        *Server
+	path     string
+	interval time.Duration
+
+	mu       sync.Mutex
+	modTime  time.Time
+	baseline map[string]any // keys of the file the server was started with
+	pending  []string
+}
+
+func newConfigWatcher(s *Server, path string, interval time.Duration) (*ConfigWatcher, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid watch interval: must be positive")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	baseline, err := readRawConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConfigWatcher{
+		s:        s,
+		path:     path,
+		interval: interval,
+		modTime:  info.ModTime(),
+		baseline: baseline,
+		pending:  []string{},
+	}, nil
+}
+
+func readRawConfig(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw := make(map[string]any)
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing config file: %w", err)
+	}
+	return raw, nil
+}
+
+// Run checks the file's mtime every interval until ctx is cancelled.
+func (w *ConfigWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+func (w *ConfigWatcher) check() {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		slog.Warn("Error checking config file", "file", w.path, "err", err)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if info.ModTime().Equal(w.modTime) {
+		return
+	}
+	w.modTime = info.ModTime()
+
+	raw, err := readRawConfig(w.path)
+	if err != nil {
+		slog.Error("Error reloading config file", "file", w.path, "err", err)
+		return
+	}
+
+	// Start from the running configuration so removed keys fall back to
+	// the values the server was started with
+	cfg := w.s.cfg
+	if err := LoadConfigFile(w.path, &cfg); err != nil {
+		slog.Error("Error reloading config file", "file", w.path, "err", err)
+		return
+	}
+	keepFlagValues(&cfg, w.s.cfg)
+	if err := w.s.applyReloadable(cfg); err != nil {
+		slog.Error("Error applying config file", "file", w.path, "err", err)
+		return
+	}
+
+	w.pending = w.pending[:0]
+	for key := range mergeKeys(w.baseline, raw) {
+		if !reflect.DeepEqual(w.baseline[key], raw[key]) && !slices.Contains(cfg.FlagKeys, key) && !w.s.hotReloadable(key, cfg) {
+			w.pending = append(w.pending, key)
+		}
+	}
+	sort.Strings(w.pending)
+
+	slog.Info("Reloaded config file", "file", w.path, "pending_restart", w.pending)
+}
+
+// keepFlagValues resets the options of cfg given as command-line flags to
+// their running values, as flags take precedence over the file.
+func keepFlagValues(cfg *Config, running Config) {
+	v, r := reflect.ValueOf(cfg).Elem(), reflect.ValueOf(running)
+	for i := 0; i < v.NumField(); i++ {
+		key, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		if slices.Contains(running.FlagKeys, key) {
+			v.Field(i).Set(r.Field(i))
+		}
+	}
+}
+
+// Pending returns the changed keys that only take effect after a restart.
+func (w *ConfigWatcher) Pending() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return append([]string{}, w.pending...)
+}
+
+func mergeKeys(a, b map[string]any) map[string]bool {
+	keys := make(map[string]bool, len(a)+len(b))
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	return keys
+}
+
+// hotReloadable reports whether a changed config key is applied without a
+// restart. The rate detector can be retuned but not switched on or off.
+func (s *Server) hotReloadable(key string, cfg Config) bool {
+	switch key {
+	case "allowed_origins", "ignore_urls", "extract_fields", "honeypot_urls", "rate_limit_alert_threshold", "lag_alert_threshold":
+		return true
+	case "rate_threshold":
+		return s.rates != nil && cfg.RateThreshold > 0
+	default:
+		return false
+	}
+}
+
+// applyReloadable swaps in the hot-reloadable options of cfg.
+func (s *Server) applyReloadable(cfg Config) error {
+	dropRules, err := compileFilterRules(cfg.IgnoreURLs)
+	if err != nil {
+		return err
+	}
+	extractors, err := compileExtractRules(cfg.ExtractFields)
+	if err != nil {
+		return err
+	}
+
+	s.reloadMu.Lock()
+	s.dropRules = dropRules
+	s.extractors = extractors
+	s.honeypots = newHoneypotSet(cfg.HoneypotURLs)
+	s.allowedOrigins = cfg.AllowedOrigins
+	s.reloadMu.Unlock()
+
+	if s.rates != nil && cfg.RateThreshold > 0 {
+		s.rates.SetThreshold(cfg.RateThreshold)
+	}
+	s.rateLimits.SetThreshold(cfg.RateLimitAlertThreshold)
+	s.lag.SetThreshold(cfg.LagAlertThreshold)
+	return nil
+}
+
+type pendingRestart struct {
+	ConfigFile string   `json:"config_file,omitempty"`
+	Fields     []string `json:"fields"`
+}
+
+// MakePendingRestartHandler lists config file keys that changed since
+// startup but only take effect after a restart.
+func (s *Server) MakePendingRestartHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.configWatcher == nil {
+			writeJSON(w, pendingRestart{Fields: []string{}})
+			return
+		}
+		writeJSON(w, pendingRestart{ConfigFile: s.configWatcher.path, Fields: s.configWatcher.Pending()})
+	}
+}
@@ -0,0 +1,75 @@
+package nginxviz
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// connectionAgeBuckets are the upper bounds of the histogram buckets. The
+// last bucket collects everything above the previous bound.
+var connectionAgeBuckets = []struct {
+	Label string
+	Max   time.Duration
+}{
+	{"<1s", time.Second},
+	{"1-10s", 10 * time.Second},
+	{"10-60s", time.Minute},
+	{"1-10min", 10 * time.Minute},
+	{">10min", 0},
+}
+
+// connectionAgeHistogram records how long WebSocket connections stayed open.
+type connectionAgeHistogram struct {
+	mu     sync.Mutex
+	counts [5]int
+	total  time.Duration
+}
+
+func (h *connectionAgeHistogram) Observe(age time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	bucket := len(connectionAgeBuckets) - 1
+	for i, b := range connectionAgeBuckets[:bucket] {
+		if age < b.Max {
+			bucket = i
+			break
+		}
+	}
+	h.counts[bucket]++
+	h.total += age
+}
+
+type connectionAgeBucket struct {
+	Label string `json:"label"`
+	Count int    `json:"count"`
+}
+
+type connectionAgeStats struct {
+	Buckets                 []connectionAgeBucket `json:"buckets"`
+	Connections             int                   `json:"connections"`
+	AvgConnectionAgeSeconds float64               `json:"avg_connection_age_seconds"`
+}
+
+func (h *connectionAgeHistogram) Stats() connectionAgeStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stats := connectionAgeStats{Buckets: make([]connectionAgeBucket, len(connectionAgeBuckets))}
+	for i, b := range connectionAgeBuckets {
+		stats.Buckets[i] = connectionAgeBucket{Label: b.Label, Count: h.counts[i]}
+		stats.Connections += h.counts[i]
+	}
+	if stats.Connections > 0 {
+		stats.AvgConnectionAgeSeconds = h.total.Seconds() / float64(stats.Connections)
+	}
+	return stats
+}
+
+// MakeConnectionAgesHandler returns the histogram of closed WebSocket connection ages.
+func MakeConnectionAgesHandler(connectionAges *connectionAgeHistogram) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, connectionAges.Stats())
+	}
+}
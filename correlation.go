@@ -0,0 +1,68 @@
+package nginxviz
+
+import (
+	"container/list"
+	"sync"
+)
+
+const (
+	correlationMaxIDs     = 1000
+	correlationMaxEntries = 20
+)
+
+type correlatedRequest struct {
+	Type      string     `json:"type"`
+	RequestID string     `json:"request_id"`
+	Entries   []LogEntry `json:"entries"`
+}
+
+type correlationItem struct {
+	requestID string
+	entries   []LogEntry
+}
+
+// RequestIDCorrelationStore groups entries sharing a $request_id, keeping the
+// most recently seen IDs and evicting the least recently used ones.
+type RequestIDCorrelationStore struct {
+	mu    sync.Mutex
+	order *list.List
+	items map[string]*list.Element
+}
+
+func NewRequestIDCorrelationStore() *RequestIDCorrelationStore {
+	return &RequestIDCorrelationStore{
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// Add records an entry and returns all entries seen for its request ID once
+// there is more than one.
+func (s *RequestIDCorrelationStore) Add(entry LogEntry) ([]LogEntry, bool) {
+	if entry.RequestID == "" {
+		return nil, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[entry.RequestID]; ok {
+		s.order.MoveToFront(el)
+		item := el.Value.(*correlationItem)
+		if len(item.entries) < correlationMaxEntries {
+			item.entries = append(item.entries, entry)
+		}
+		return append([]LogEntry(nil), item.entries...), true
+	}
+
+	s.items[entry.RequestID] = s.order.PushFront(&correlationItem{
+		requestID: entry.RequestID,
+		entries:   []LogEntry{entry},
+	})
+	if s.order.Len() > correlationMaxIDs {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.items, oldest.Value.(*correlationItem).requestID)
+	}
+	return nil, false
+}
@@ -0,0 +1,107 @@
+package nginxviz
+
+import (
+	"encoding/json"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const debugFakeEntryInterval = 2 * time.Second
+
+var debugFakeRequests = []struct {
+	IP, Country, CountryFull, Method, URL, UserAgent string
+	StatusCode                                       int
+}{
+	{"8.8.8.8", "US", "United States", "GET", "/", "Mozilla/5.0 (X11; Linux x86_64) Firefox/128.0", 200},
+	{"1.1.1.1", "AU", "Australia", "GET", "/about", "Mozilla/5.0 (Macintosh) Safari/605.1.15", 200},
+	{"77.88.8.8", "RU", "Russia", "GET", "/wp-login.php", "python-requests/2.31", 404},
+	{"66.249.66.1", "US", "United States", "GET", "/robots.txt", "Mozilla/5.0 (compatible; Googlebot/2.1)", 200},
+	{"81.2.69.142", "GB", "United Kingdom", "POST", "/api/login", "curl/8.5.0", 500},
+	{"10.0.0.12", privateCountry, privateCountryFull, "GET", "/healthz", "kube-probe/1.30", 200},
+}
+
+// fakeLogEntry returns a synthetic entry for UI development.
+func fakeLogEntry() LogEntry {
+	r := debugFakeRequests[mathrand.IntN(len(debugFakeRequests))]
+	return LogEntry{
+		Timestamp:   time.Now(),
+		IP:          r.IP,
+		Method:      r.Method,
+		URL:         r.URL,
+		StatusCode:  r.StatusCode,
+		Size:        mathrand.IntN(50000),
+		UserAgent:   r.UserAgent,
+		Referer:     "-",
+		Country:     r.Country,
+		CountryFull: r.CountryFull,
+	}
+}
+
+// MakeDebugWebSocketHandler echoes every text message back to the sender and
+// sends a synthetic log_entry every two seconds. Debug clients are not
+// registered, so they never receive real log traffic.
+func (s *Server) MakeDebugWebSocketHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		rc.SetReadDeadline(time.Time{})
+		rc.SetWriteDeadline(time.Time{})
+
+		conn, err := s.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			slog.Warn("WebSocket upgrade error", "err", err)
+			return
+		}
+		defer conn.Close()
+
+		slog.Info("Debug WebSocket client connected", "remote", r.RemoteAddr)
+
+		// Only this goroutine writes; the reader hands messages over
+		messages := make(chan []byte)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				messageType, message, err := conn.ReadMessage()
+				if err != nil {
+					slog.Debug("Debug WebSocket read error", "remote", r.RemoteAddr, "err", err)
+					return
+				}
+				if messageType != websocket.TextMessage {
+					continue
+				}
+				select {
+				case messages <- message:
+				case <-s.ctx.Done():
+					return
+				}
+			}
+		}()
+
+		ticker := time.NewTicker(debugFakeEntryInterval)
+		defer ticker.Stop()
+
+		for {
+			var err error
+			select {
+			case message := <-messages:
+				err = conn.WriteMessage(websocket.TextMessage, message)
+			case <-ticker.C:
+				message, _ := json.Marshal(LogUpdate{Type: "log_entry", LogType: logTypeAccess, Data: fakeLogEntry()})
+				err = conn.WriteMessage(websocket.TextMessage, message)
+			case <-done:
+				slog.Info("Debug WebSocket client disconnected", "remote", r.RemoteAddr)
+				return
+			case <-s.ctx.Done():
+				return
+			}
+			if err != nil {
+				slog.Debug("Debug WebSocket write error", "remote", r.RemoteAddr, "err", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package nginxviz
+
+import (
+	"context"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+// demoIPsPerCountry is how many client addresses the demo generator finds
+// for each country.
+const demoIPsPerCountry = 16
+
+// weighted is a choice of the demo generator with its relative frequency.
+type weighted[T any] struct {
+	value  T
+	weight int
+}
+
+func pick[T any](r *mathrand.Rand, choices []weighted[T]) T {
+	total := 0
+	for _, c := range choices {
+		total += c.weight
+	}
+	n := r.IntN(total)
+	for _, c := range choices {
+		if n -= c.weight; n < 0 {
+			return c.value
+		}
+	}
+	return choices[len(choices)-1].value
+}
+
+var demoCountries = []weighted[string]{
+	{"US", 30}, {"DE", 8}, {"CN", 8}, {"GB", 7}, {"IN", 7}, {"FR", 5}, {"BR", 5},
+	{"JP", 4}, {"RU", 4}, {"CA", 3}, {"AU", 3}, {"NL", 3}, {"KR", 2}, {"SG", 2},
+	{"MX", 2}, {"ES", 2}, {"IT", 2}, {"PL", 2}, {"SE", 1}, {"ZA", 1}, {"AR", 1},
+	{"TR", 1}, {"ID", 1}, {"VN", 1}, {"NG", 1},
+}
+
+// demoPage is a path of the demo site with the statuses it answers with.
+type demoPage struct {
+	method   string
+	path     string
+	size     int
+	statuses []weighted[int]
+}
+
+var (
+	demoOK       = []weighted[int]{{200, 90}, {304, 8}, {500, 1}, {503, 1}}
+	demoNotFound = []weighted[int]{{404, 1}}
+	demoAPI      = []weighted[int]{{200, 85}, {201, 4}, {400, 4}, {401, 3}, {429, 2}, {502, 2}}
+)
+
+var demoPages = []weighted[demoPage]{
+	{demoPage{"GET", "/", 14200, demoOK}, 20},
+	{demoPage{"GET", "/about", 8300, demoOK}, 4},
+	{demoPage{"GET", "/pricing", 11800, demoOK}, 5},
+	{demoPage{"GET", "/blog/", 9600, demoOK}, 4},
+	{demoPage{"GET", "/blog/2024/06/scaling-websockets", 23100, demoOK}, 3},
+	{demoPage{"GET", "/blog/2024/09/geoip-on-a-budget", 19800, demoOK}, 2},
+	{demoPage{"GET", "/docs/getting-started", 17400, demoOK}, 4},
+	{demoPage{"GET", "/static/app.js", 182000, demoOK}, 12},
+	{demoPage{"GET", "/static/style.css", 24500, demoOK}, 12},
+	{demoPage{"GET", "/favicon.ico", 1150, demoOK}, 6},
+	{demoPage{"GET", "/robots.txt", 68, demoOK}, 2},
+	{demoPage{"GET", "/api/v1/products", 4100, demoAPI}, 6},
+	{demoPage{"GET", "/api/v1/products/1042", 890, demoAPI}, 4},
+	{demoPage{"POST", "/api/v1/cart", 240, demoAPI}, 2},
+	{demoPage{"POST", "/login", 310, []weighted[int]{{302, 7}, {401, 3}}}, 1},
+	{demoPage{"GET", "/missing-page", 153, demoNotFound}, 1},
+}
+
+// demoProbes are what scanners request during a burst.
+var demoProbes = []weighted[demoPage]{
+	{demoPage{"GET", "/wp-login.php", 153, demoNotFound}, 4},
+	{demoPage{"GET", "/.env", 153, demoNotFound}, 3},
+	{demoPage{"GET", "/.git/config", 153, demoNotFound}, 2},
+	{demoPage{"GET", "/phpmyadmin/", 153, demoNotFound}, 2},
+	{demoPage{"POST", "/login", 310, []weighted[int]{{401, 9}, {429, 1}}}, 4},
+}
+
+var demoUserAgents = []weighted[string]{
+	{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/128.0.0.0 Safari/537.36", 30},
+	{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.6 Safari/605.1.15", 14},
+	{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.6 Mobile/15E148 Safari/604.1", 16},
+	{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/128.0.0.0 Mobile Safari/537.36", 12},
+	{"Mozilla/5.0 (X11; Linux x86_64; rv:130.0) Gecko/20100101 Firefox/130.0", 6},
+	{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", 4},
+	{"Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)", 2},
+	{"curl/8.5.0", 1},
+}
+
+var demoScannerAgents = []weighted[string]{
+	{"python-requests/2.32.3", 3},
+	{"Go-http-client/1.1", 2},
+	{"Mozilla/5.0 zgrab/0.x", 1},
+}
+
+var demoReferers = []weighted[string]{
+	{"", 10}, {"https://www.google.com/", 5}, {"https://demo.nginxviz.local/", 6},
+	{"https://news.ycombinator.com/", 1}, {"https://t.co/", 1},
+}
+
+// demoGenerator synthesizes traffic for -demo: mostly browsers spread over
+// countries by weight, with now and then a burst from a single scanner.
+type demoGenerator struct {
+	rand *mathrand.Rand
+	ips  map[string][]string // addresses the GeoIP database places in each country
+
+	burstIP    string
+	burstUntil time.Time
+	nextBurst  time.Time
+}
+
+// newDemoGenerator finds addresses for each demo country by looking random
+// ones up in the GeoIP database, so entries go through the same enrichment
+// as real ones and land where they claim to.
+func (s *Server) newDemoGenerator() *demoGenerator {
+	g := &demoGenerator{
+		rand: mathrand.New(mathrand.NewPCG(mathrand.Uint64(), mathrand.Uint64())),
+		ips:  make(map[string][]string),
+	}
+
+	wanted := len(demoCountries) * demoIPsPerCountry
+	found := 0
+	for tries := 0; found < wanted && tries < 2_000_000; tries++ {
+		var b [4]byte
+		for i := range b {
+			b[i] = byte(g.rand.UintN(256))
+		}
+		ip := netip.AddrFrom4(b)
+		if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+			continue
+		}
+		// The database is read directly to keep the misses out of the cache
+		var record ipRecord
+		if err := s.db.Load().Lookup(ip).Decode(&record); err != nil {
+			continue
+		}
+		country := record.Country.ISOCode
+		if isDemoCountry(country) && len(g.ips[country]) < demoIPsPerCountry {
+			g.ips[country] = append(g.ips[country], ip.String())
+			found++
+		}
+	}
+	return g
+}
+
+func isDemoCountry(country string) bool {
+	for _, c := range demoCountries {
+		if c.value == country {
+			return true
+		}
+	}
+	return false
+}
+
+// ip returns an address of a country picked by weight.
+func (g *demoGenerator) ip() string {
+	for range 10 {
+		if ips := g.ips[pick(g.rand, demoCountries)]; len(ips) > 0 {
+			return ips[g.rand.IntN(len(ips))]
+		}
+	}
+	for _, ips := range g.ips {
+		return ips[g.rand.IntN(len(ips))]
+	}
+	return "192.0.2." + strconv.Itoa(1+g.rand.IntN(254))
+}
+
+// next returns the entry for a request at now, and whether it is part of a
+// burst.
+func (g *demoGenerator) next(now time.Time) (LogEntry, bool) {
+	if now.After(g.nextBurst) {
+		if !g.nextBurst.IsZero() {
+			g.burstIP = g.ip()
+			g.burstUntil = now.Add(time.Duration(5+g.rand.IntN(10)) * time.Second)
+		}
+		g.nextBurst = now.Add(time.Duration(30+g.rand.IntN(60)) * time.Second)
+	}
+
+	bursting := now.Before(g.burstUntil)
+	page, ip, agent, referer := pick(g.rand, demoPages), g.ip(), pick(g.rand, demoUserAgents), pick(g.rand, demoReferers)
+	if bursting && g.rand.IntN(4) > 0 {
+		page, ip, agent, referer = pick(g.rand, demoProbes), g.burstIP, pick(g.rand, demoScannerAgents), ""
+	}
+
+	status := pick(g.rand, page.statuses)
+	size := page.size/2 + g.rand.IntN(page.size+1)
+	if status == 304 {
+		size = 0
+	}
+	return LogEntry{
+		Timestamp:   now,
+		IP:          ip,
+		Method:      page.method,
+		URL:         page.path,
+		Protocol:    "HTTP/1.1",
+		StatusCode:  status,
+		Size:        size,
+		UserAgent:   agent,
+		Referer:     referer,
+		Host:        "demo.nginxviz.local",
+		RequestTime: float64(5+g.rand.IntN(120)) / 1000,
+	}, bursting
+}
+
+// readDemo submits generated entries at about DemoRate a second, and ten
+// times that during bursts, until ctx is cancelled.
+func (s *Server) readDemo(ctx context.Context) {
+	g := s.newDemoGenerator()
+	slog.Info("Generating demo traffic", "rate", s.cfg.DemoRate, "countries", len(g.ips))
+
+	for {
+		entry, bursting := g.next(time.Now())
+		if entry, ok := s.ingestedEntry(entry); ok {
+			if s.submitEntry(ctx, entry) != nil {
+				return
+			}
+		}
+
+		// Exponential gaps make arrivals a Poisson process, as real
+		// traffic roughly is
+		rate := s.cfg.DemoRate
+		if bursting {
+			rate *= 10
+		}
+		gap := time.Duration(g.rand.ExpFloat64() / rate * float64(time.Second))
+		if sleepContext(ctx, gap) != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package nginxviz
+
+import (
+	"bufio"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+const detectSampleLines = 100
+
+// detectOrder breaks ties between formats that parse the same lines, such
+// as nginx and Apache combined, in favour of the more specific one.
+var detectOrder = []string{formatJSON, formatCombined, "apache", "caddy", "haproxy", formatALB, "cloudfront"}
+
+// FormatDetection records how the log format was chosen with DetectFormat.
+type FormatDetection struct {
+	Format      string         `json:"format"`   // format in use
+	Detector    string         `json:"detector"` // format that parsed the most sampled lines, empty if none did
+	SampleLines int            `json:"sample_lines"`
+	Scores      map[string]int `json:"scores"` // sampled lines each format parsed cleanly
+}
+
+// detectLogFormat parses the first lines of the log file with every
+// registered format and picks the one that handles the most of them without
+// errors or ParseWarnings. The configured format is kept when no format
+// parses any line, including when the file is empty or missing.
+func detectLogFormat(cfg Config) FormatDetection {
+	detection := FormatDetection{Format: cfg.Format, Scores: map[string]int{}}
+
+	if cfg.LogFile == stdinLogFile {
+		slog.Warn("Cannot detect the log format of stdin, using the configured one", "format", cfg.Format)
+		return detection
+	}
+	if cfg.DockerContainer != "" || cfg.KubeSelector != "" || cfg.ListenSyslog != "" || cfg.ListenForward != "" || cfg.KafkaTopic != "" || cfg.RedisStream != "" || cfg.Bucket != "" || cfg.Demo {
+		slog.Warn("Cannot detect the log format of logs that are not files, using the configured one", "format", cfg.Format)
+		return detection
+	}
+	if _, ok := journalUnit(cfg.LogFile); ok {
+		slog.Warn("Cannot detect the log format of the systemd journal, using the configured one", "format", cfg.Format)
+		return detection
+	}
+	if strings.HasPrefix(cfg.LogFile, sshPrefix) {
+		slog.Warn("Cannot detect the log format of a remote log file, using the configured one", "format", cfg.Format)
+		return detection
+	}
+
+	logFile := firstLogFile(cfg.LogFile)
+	if isFIFO(logFile) {
+		slog.Warn("Cannot detect the log format of a named pipe, using the configured one", "format", cfg.Format)
+		return detection
+	}
+	lines, err := sampleLines(logFile, detectSampleLines, cfg.MaxLineSize)
+	if err != nil {
+		slog.Warn("Cannot sample log file for format detection", "file", logFile, "err", err)
+		return detection
+	}
+	detection.SampleLines = len(lines)
+
+	best := 0
+	for _, format := range detectCandidates(cfg.Format) {
+		candidate := cfg
+		candidate.Format = format
+		parser, err := newParser(candidate)
+		if err != nil {
+			continue
+		}
+
+		score := 0
+		for _, line := range lines {
+			entry, err := parser.Parse(line)
+			if err == nil && len(entry.ParseWarnings) == 0 {
+				score++
+			}
+		}
+		detection.Scores[format] = score
+		if score > best {
+			best, detection.Detector, detection.Format = score, format, format
+		}
+	}
+
+	if detection.Detector == "" {
+		slog.Warn("No log format matched the sampled lines, using the configured one", "format", cfg.Format, "sampled", len(lines))
+	} else {
+		slog.Info("Detected log format", "format", detection.Format, "matched", best, "sampled", len(lines))
+	}
+	return detection
+}
+
+// detectCandidates lists the built-in formats in detectOrder, then any other
+// registered ones. A configured log_format definition goes first, as it is
+// more specific than any of them.
+func detectCandidates(configured string) []string {
+	var candidates []string
+	if isLogFormat(configured) {
+		candidates = append(candidates, configured)
+	}
+	for _, format := range append(slices.Clone(detectOrder), Parsers()...) {
+		if !slices.Contains(candidates, format) {
+			candidates = append(candidates, format)
+		}
+	}
+	return candidates
+}
+
+// sampleLines returns up to n non-empty lines from the start of a file,
+// prepared the way processLogLine prepares them. Lines without a request,
+// such as CloudFront's headers, are left out.
+func sampleLines(path string, n, maxLineSize int) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if maxLineSize > 0 {
+		scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize+1)
+	}
+
+	var lines []string
+	for len(lines) < n && scanner.Scan() {
+		line := stripSyslogHeader(strings.TrimSpace(scanner.Text()))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, bufio.ErrTooLong) {
+		return nil, err
+	}
+	return lines, nil
+}
+
+type statusResponse struct {
+	Format          string           `json:"format"`
+	FormatDetection *FormatDetection `json:"format_detection,omitempty"` // nil unless DetectFormat is set
+}
+
+// MakeStatusHandler returns the log format in use and, with DetectFormat,
+// how it was detected.
+func (s *Server) MakeStatusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, statusResponse{Format: s.cfg.Format, FormatDetection: s.detected})
+	}
+}
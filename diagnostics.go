@@ -0,0 +1,156 @@
+package nginxviz
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const lastErrorsSize = 100
+
+// ParseError records a log line that could not be parsed.
+type ParseError struct {
+	Line      string
+	Error     error
+	Timestamp time.Time
+}
+
+func (p ParseError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Line      string    `json:"line"`
+		Error     string    `json:"error"`
+		Timestamp time.Time `json:"timestamp"`
+	}{p.Line, p.Error.Error(), p.Timestamp})
+}
+
+// LastErrorsCache keeps the most recent parse failures so operators can
+// spot log format mismatches without tailing the server log.
+type LastErrorsCache struct {
+	mu         sync.Mutex
+	LastErrors [lastErrorsSize]ParseError
+	next       int
+	count      int
+}
+
+func (c *LastErrorsCache) Add(line string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.LastErrors[c.next] = ParseError{Line: line, Error: err, Timestamp: time.Now()}
+	c.next = (c.next + 1) % lastErrorsSize
+	if c.count < lastErrorsSize {
+		c.count++
+	}
+}
+
+// Newest returns the most recent parse error, if any.
+func (c *LastErrorsCache) Newest() (ParseError, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.count == 0 {
+		return ParseError{}, false
+	}
+	return c.LastErrors[(c.next-1+lastErrorsSize)%lastErrorsSize], true
+}
+
+// Recent returns the stored parse errors, newest first.
+func (c *LastErrorsCache) Recent() []ParseError {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	recent := make([]ParseError, 0, c.count)
+	for i := 1; i <= c.count; i++ {
+		recent = append(recent, c.LastErrors[(c.next-i+lastErrorsSize)%lastErrorsSize])
+	}
+	return recent
+}
+
+// MakeParseErrorsHandler returns the most recent log lines that failed to parse.
+func MakeParseErrorsHandler(parseErrors *LastErrorsCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, parseErrors.Recent())
+	}
+}
+
+const parseErrorFeedPerSecond = 5
+
+type parseErrorUpdate struct {
+	Type string     `json:"type"`
+	Data ParseError `json:"data"`
+}
+
+// parseErrorLimiter caps how many parse errors per second are sent to
+// clients so a completely wrong log format does not flood the socket.
+type parseErrorLimiter struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	sent        int
+}
+
+func (l *parseErrorLimiter) Allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.windowStart) >= time.Second {
+		l.windowStart = now
+		l.sent = 0
+	}
+	if l.sent >= parseErrorFeedPerSecond {
+		return false
+	}
+	l.sent++
+	return true
+}
+
+// publishParseError forwards a parse failure to the websocket feed when
+// Debug is enabled, dropping it if the feed is rate limited or backed up.
+func (s *Server) publishParseError(line string, err error) {
+	if s.parseErrorFeed == nil || !s.parseErrorFeedLimit.Allow(time.Now()) {
+		return
+	}
+
+	select {
+	case s.parseErrorFeed <- ParseError{Line: line, Error: err, Timestamp: time.Now()}:
+	default:
+	}
+}
+
+type parseErrorSummary struct {
+	Type  string     `json:"type"`
+	Count int64      `json:"count"` // lines that failed to parse since the last summary
+	Total int64      `json:"total"`
+	Last  ParseError `json:"last"`
+}
+
+// summarizeParseErrors tells clients every interval how many lines failed to
+// parse since the previous summary, with the latest one as an example, so a
+// log format mismatch shows up in the browser without -debug.
+func (s *Server) summarizeParseErrors(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	reported := s.counters.Unparseable.Load()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		total := s.counters.Unparseable.Load()
+		last, ok := s.parseErrors.Newest()
+		if total == reported || !ok {
+			continue
+		}
+		s.publishEvent(parseErrorSummary{
+			Type:  "parse_error_summary",
+			Count: total - reported,
+			Total: total,
+			Last:  last,
+		})
+		reported = total
+	}
+}
@@ -0,0 +1,188 @@
+package nginxviz
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultDockerSocket = "/var/run/docker.sock"
+
+// Docker multiplexes stdout and stderr of containers without a TTY into
+// frames, each behind an 8 byte header holding the stream and payload size.
+const (
+	dockerStdout = 1
+	dockerStderr = 2
+)
+
+// dockerClient talks to the Docker Engine API over its unix socket.
+type dockerClient struct {
+	http *http.Client
+}
+
+func newDockerClient(socket string) *dockerClient {
+	return &dockerClient{http: &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}}
+}
+
+func (c *dockerClient) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://docker"+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return nil, fmt.Errorf("docker API %s: %s %s", path, resp.Status, apiErr.Message)
+	}
+	return resp, nil
+}
+
+// containerTTY reports whether the container was started with a TTY, in
+// which case its log stream is not multiplexed.
+func (c *dockerClient) containerTTY(ctx context.Context, container string) (bool, error) {
+	resp, err := c.get(ctx, "/containers/"+url.PathEscape(container)+"/json", nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	var info struct {
+		Config struct {
+			Tty bool `json:"Tty"`
+		} `json:"Config"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return false, fmt.Errorf("decoding container: %w", err)
+	}
+	return info.Config.Tty, nil
+}
+
+// dockerLogQuery returns the log stream parameters that start where
+// -from-end and -backfill say a log file would start, or at since when the
+// stream is being resumed.
+func (s *Server) dockerLogQuery(since time.Time) url.Values {
+	query := url.Values{"follow": {"1"}, "stdout": {"1"}, "stderr": {"1"}}
+	switch {
+	case !since.IsZero():
+		query.Set("since", strconv.FormatInt(since.Unix(), 10))
+	case s.cfg.FromEnd:
+		query.Set("tail", "0")
+	case s.cfg.Backfill > 0:
+		query.Set("tail", strconv.Itoa(s.cfg.Backfill))
+	case s.cfg.BackfillSince > 0:
+		query.Set("since", strconv.FormatInt(time.Now().Add(-s.cfg.BackfillSince).Unix(), 10))
+	}
+	return query
+}
+
+// readDocker passes the container's stdout to the access log handler and
+// its stderr to the error log handler, the way the official nginx image
+// links its logs, until ctx is cancelled. The stream is reopened after a
+// pause when it ends, for example because the container restarted.
+func (s *Server) readDocker(ctx context.Context, container string) {
+	client := newDockerClient(s.cfg.DockerSocket)
+	var since time.Time
+	for {
+		slog.Info("Reading container logs", "container", container)
+		err := s.followDocker(ctx, client, container, since)
+		if ctx.Err() != nil {
+			slog.Info("Stopped reading container logs", "container", container)
+			return
+		}
+		if err != nil {
+			slog.Error("Error reading container logs", "container", container, "err", err)
+		} else {
+			slog.Info("Container log stream ended", "container", container)
+		}
+		since = time.Now()
+		if sleepContext(ctx, 2*time.Second) != nil {
+			return
+		}
+	}
+}
+
+// followDocker reads one log stream of container until it ends.
+func (s *Server) followDocker(ctx context.Context, client *dockerClient, container string, since time.Time) error {
+	tty, err := client.containerTTY(ctx, container)
+	if err != nil {
+		return err
+	}
+	resp, err := client.get(ctx, "/containers/"+url.PathEscape(container)+"/logs", s.dockerLogQuery(since))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// With a TTY both streams arrive as one, unframed
+	if tty {
+		return forEachLine(ctx, resp.Body, s.handleAccessLogLine)
+	}
+	return demuxDockerLogs(ctx, resp.Body, map[byte]lineHandler{
+		dockerStdout: s.handleAccessLogLine,
+		dockerStderr: s.handleErrorLogLine,
+	})
+}
+
+// demuxDockerLogs splits a multiplexed log stream into lines, passing each
+// to the handler of its stream. Lines may span several frames.
+func demuxDockerLogs(ctx context.Context, r io.Reader, handlers map[byte]lineHandler) error {
+	reader := bufio.NewReader(r)
+	partial := make(map[byte]string)
+	header := make([]byte, 8)
+	for ctx.Err() == nil {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		stream := header[0]
+		payload := make([]byte, binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return err
+		}
+
+		handle, ok := handlers[stream]
+		if !ok {
+			continue
+		}
+		data := partial[stream] + string(payload)
+		for {
+			line, rest, found := strings.Cut(data, "\n")
+			if !found {
+				break
+			}
+			if err := handle(ctx, line); err != nil {
+				return err
+			}
+			data = rest
+		}
+		partial[stream] = data
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,121 @@
+package nginxviz
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const nginxErrorTimeLayout = "2006/01/02 15:04:05"
+
+// Example: 2024/05/01 12:00:00 [error] 1234#0: *5678 open() "/var/www/x" failed
+// (2: No such file or directory), client: 1.2.3.4, server: example.com,
+// request: "GET /x HTTP/1.1", host: "example.com"
+var (
+	errorLogRegex   = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}) \[(\w+)\] (\d+)#\d+: (?:\*(\d+) )?(.*)$`)
+	errorLogContext = regexp.MustCompile(`, (client|server|request|upstream|host|referrer): ("[^"]*"|[^,]*)`)
+)
+
+// ErrorEntry is a line of the nginx error log.
+type ErrorEntry struct {
+	Timestamp    time.Time `json:"timestamp"`
+	Severity     string    `json:"severity"`
+	PID          int       `json:"pid"`
+	ConnectionID int64     `json:"connection_id,omitempty"`
+	Message      string    `json:"message"`
+	ClientIP     string    `json:"client_ip,omitempty"`
+	Country      string    `json:"country,omitempty"`
+	Server       string    `json:"server,omitempty"`
+	Request      string    `json:"request,omitempty"`
+	Upstream     string    `json:"upstream,omitempty"`
+	Host         string    `json:"host,omitempty"`
+	Referrer     string    `json:"referrer,omitempty"`
+}
+
+type errorUpdate struct {
+	Type    string     `json:"type"`
+	LogType string     `json:"log_type"`
+	Data    ErrorEntry `json:"data"`
+}
+
+// parseErrorLog parses an nginx error log line. The client, server,
+// request, upstream, host and referrer details nginx appends to the message
+// are split out into their own fields.
+func parseErrorLog(line string) (ErrorEntry, error) {
+	matches := errorLogRegex.FindStringSubmatch(line)
+	if matches == nil {
+		return ErrorEntry{}, fmt.Errorf("failed to parse error log line: %s", line)
+	}
+
+	// nginx writes error log timestamps in the server's local time
+	timestamp, err := time.ParseInLocation(nginxErrorTimeLayout, matches[1], time.Local)
+	if err != nil {
+		timestamp = time.Now()
+	}
+
+	entry := ErrorEntry{
+		Timestamp: timestamp,
+		Severity:  matches[2],
+		Message:   matches[5],
+	}
+	entry.PID, _ = strconv.Atoi(matches[3])
+	entry.ConnectionID, _ = strconv.ParseInt(matches[4], 10, 64)
+
+	if loc := errorLogContext.FindStringIndex(entry.Message); loc != nil {
+		details := entry.Message[loc[0]:]
+		entry.Message = entry.Message[:loc[0]]
+
+		for _, detail := range errorLogContext.FindAllStringSubmatch(details, -1) {
+			value := strings.Trim(detail[2], `"`)
+			switch detail[1] {
+			case "client":
+				entry.ClientIP = value
+			case "server":
+				entry.Server = value
+			case "request":
+				entry.Request = value
+			case "upstream":
+				entry.Upstream = value
+			case "host":
+				entry.Host = value
+			case "referrer":
+				entry.Referrer = value
+			}
+		}
+	}
+
+	return entry, nil
+}
+
+// handleErrorLogLine parses an error log line and broadcasts it to clients
+// as an error_entry message.
+func (s *Server) handleErrorLogLine(ctx context.Context, line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+
+	entry, err := parseErrorLog(line)
+	if err != nil {
+		slog.Debug("Skipping error log line", "err", err)
+		return nil
+	}
+
+	if ip, err := netip.ParseAddr(entry.ClientIP); err == nil {
+		if record, err := s.lookupIP(ip); err == nil {
+			entry.Country = record.Country.ISOCode
+		}
+	}
+
+	s.publishEvent(errorUpdate{Type: "error_entry", LogType: logTypeError, Data: entry})
+	if incident, ok := s.incidents.AddError(entry); ok {
+		incident.Access = s.publicEntry(incident.Access)
+		s.publishEvent(incident)
+	}
+	return nil
+}
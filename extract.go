@@ -0,0 +1,122 @@
+package nginxviz
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ExtractRule copies a value from each log line into LogEntry.Extra under
+// Name. The value is either the first capture group of Pattern, or the
+// whole match if it has none, or the 1-based Field of an extended combined
+// format, counting the 8 combined fields first like -request-id-field.
+type ExtractRule struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern,omitempty"`
+	Field   int    `yaml:"field,omitempty"`
+}
+
+// ExtractRules is a flag.Value for rules written as name=column or
+// name=regex, e.g. cache_status=12 or 'upstream_cache=X-Cache: (\w+)'.
+type ExtractRules []ExtractRule
+
+// ParseExtractRule parses a rule written as name=column or name=regex.
+func ParseExtractRule(s string) (ExtractRule, error) {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" || value == "" {
+		return ExtractRule{}, fmt.Errorf("invalid extract rule %q: want name=column or name=regex", s)
+	}
+	if field, err := strconv.Atoi(value); err == nil {
+		return ExtractRule{Name: name, Field: field}, nil
+	}
+	return ExtractRule{Name: name, Pattern: value}, nil
+}
+
+func (r ExtractRules) String() string {
+	rules := make([]string, len(r))
+	for i, rule := range r {
+		if rule.Pattern != "" {
+			rules[i] = rule.Name + "=" + rule.Pattern
+		} else {
+			rules[i] = rule.Name + "=" + strconv.Itoa(rule.Field)
+		}
+	}
+	// Patterns may contain commas, so rules are joined with newlines
+	return strings.Join(rules, "\n")
+}
+
+// Set implements flag.Value, adding a rule each time the flag is given.
+func (r *ExtractRules) Set(s string) error {
+	for _, line := range strings.Split(s, "\n") {
+		rule, err := ParseExtractRule(line)
+		if err != nil {
+			return err
+		}
+		*r = append(*r, rule)
+	}
+	return nil
+}
+
+type fieldExtractor struct {
+	name  string
+	regex *regexp.Regexp
+	field int
+}
+
+func compileExtractRules(rules []ExtractRule) ([]fieldExtractor, error) {
+	var extractors []fieldExtractor
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("invalid extract rule: missing name")
+		}
+		switch {
+		case rule.Pattern != "" && rule.Field != 0:
+			return nil, fmt.Errorf("invalid extract rule %q: set either pattern or field", rule.Name)
+		case rule.Pattern != "":
+			regex, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid extract rule %q: %w", rule.Name, err)
+			}
+			extractors = append(extractors, fieldExtractor{name: rule.Name, regex: regex})
+		case rule.Field >= 9:
+			extractors = append(extractors, fieldExtractor{name: rule.Name, field: rule.Field})
+		default:
+			return nil, fmt.Errorf("invalid extract rule %q: field must be 9 or more", rule.Name)
+		}
+	}
+	return extractors, nil
+}
+
+// extractFields applies the extract rules to a raw line, adding the values
+// found to the entry's Extra.
+func extractFields(entry *LogEntry, line string, extractors []fieldExtractor) {
+	var columns []string
+	for _, e := range extractors {
+		var value string
+		if e.regex != nil {
+			match := e.regex.FindStringSubmatch(line)
+			switch {
+			case len(match) > 1:
+				value = match[1]
+			case len(match) == 1:
+				value = match[0]
+			}
+		} else {
+			// Only split the line once, and only when a rule needs it
+			if columns == nil {
+				columns, _ = splitCombinedFields(line)
+				columns = columns[min(len(columns), 9):]
+			}
+			value = extraField(columns, e.field)
+		}
+
+		if value == "" {
+			continue
+		}
+		if entry.Extra == nil {
+			entry.Extra = make(map[string]string)
+		}
+		entry.Extra[e.name] = value
+	}
+}
@@ -0,0 +1,82 @@
+package nginxviz
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// isFIFO reports whether path is a named pipe, such as one made with mkfifo
+// for nginx to write its log to.
+func isFIFO(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// readFIFO passes each line written to the named pipe at path to
+// handleLine until ctx is cancelled. A pipe that is replaced by a new one at
+// the same path is reopened.
+func (s *Server) readFIFO(ctx context.Context, path string, handleLine lineHandler) {
+	for {
+		err := followFIFO(ctx, path, handleLine)
+		if ctx.Err() != nil {
+			slog.Info("Stopped reading named pipe", "file", path)
+			return
+		}
+		if err != nil {
+			slog.Error("Error reading named pipe", "file", path, "err", err)
+			if sleepContext(ctx, 2*time.Second) != nil {
+				return
+			}
+			continue
+		}
+		slog.Info("Named pipe replaced, reopening", "file", path)
+	}
+}
+
+// followFIFO reads the pipe at path until ctx is cancelled, returning nil
+// when the path has been replaced by another pipe.
+//
+// The pipe is opened for writing as well as reading. Holding a writer end
+// keeps reads from hitting EOF when nginx closes the pipe on a reload or
+// restart, so lines simply resume once it reopens it, and the open never
+// blocks waiting for nginx. Opening the pipe also keeps nginx from blocking
+// in its own open while nginxviz is running.
+func followFIFO(ctx context.Context, path string, handleLine lineHandler) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	inode, err := getInode(path)
+	if err != nil {
+		return err
+	}
+	slog.Info("Reading named pipe", "file", path)
+
+	fileCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rotated := make(chan bool, 1)
+	go inodeChecker(fileCtx, path, inode, rotated)
+
+	// Reads of a pipe block until there is data, so closing the file is
+	// what interrupts them
+	var replaced atomic.Bool
+	go func() {
+		select {
+		case <-fileCtx.Done():
+		case <-rotated:
+			replaced.Store(true)
+		}
+		file.Close()
+	}()
+
+	err = forEachLine(fileCtx, file, handleLine)
+	if replaced.Load() {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+package nginxviz
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// WildcardMatcher matches URL paths against glob-style patterns. A single
+// "*" matches within one path segment, "**" matches across segments and "?"
+// matches one character. Everything else is matched literally.
+type WildcardMatcher struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+func NewWildcardMatcher(pattern string) (*WildcardMatcher, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				expr.WriteString(".*")
+				i++
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid wildcard pattern %q: %w", pattern, err)
+	}
+	return &WildcardMatcher{pattern: pattern, re: re}, nil
+}
+
+// Match reports whether the path part of url matches the pattern.
+func (m *WildcardMatcher) Match(url string) bool {
+	if i := strings.IndexByte(url, '?'); i >= 0 {
+		url = url[:i]
+	}
+	return m.re.MatchString(url)
+}
+
+type urlMatcher interface {
+	Match(url string) bool
+}
+
+type regexMatcher struct {
+	re *regexp.Regexp
+}
+
+func (m regexMatcher) Match(url string) bool {
+	return m.re.MatchString(url)
+}
+
+// FilterRule selects log entries by URL. URLPattern is a wildcard pattern
+// unless prefixed with "re:", in which case the rest is a full regular
+// expression matched against the whole URL including the query string.
+type FilterRule struct {
+	URLPattern string
+	matcher    urlMatcher
+}
+
+func NewFilterRule(urlPattern string) (FilterRule, error) {
+	rule := FilterRule{URLPattern: urlPattern}
+
+	if expr, ok := strings.CutPrefix(urlPattern, "re:"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return FilterRule{}, fmt.Errorf("invalid regex %q: %w", expr, err)
+		}
+		rule.matcher = regexMatcher{re: re}
+		return rule, nil
+	}
+
+	matcher, err := NewWildcardMatcher(urlPattern)
+	if err != nil {
+		return FilterRule{}, err
+	}
+	rule.matcher = matcher
+	return rule, nil
+}
+
+func (f FilterRule) Matches(entry LogEntry) bool {
+	return f.matcher.Match(entry.URL)
+}
+
+// compileFilterRules builds the rules for a list of -ignore-url patterns.
+func compileFilterRules(patterns []string) ([]FilterRule, error) {
+	var rules []FilterRule
+	for _, pattern := range patterns {
+		rule, err := NewFilterRule(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
@@ -0,0 +1,284 @@
+package nginxviz
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// forwardListener receives events over the Fluentd forward protocol, as
+// sent by Fluent Bit's and Fluentd's forward outputs.
+type forwardListener struct {
+	tcp net.Listener
+}
+
+func listenForward(addr string) (*forwardListener, error) {
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &forwardListener{tcp: tcp}, nil
+}
+
+// forwardEvent is a single event of a forward message.
+type forwardEvent struct {
+	Time   time.Time
+	Record map[string]any
+}
+
+// Serve passes the events of every connection to handle until ctx is
+// cancelled, then closes the listener.
+func (l *forwardListener) Serve(ctx context.Context, handle func(context.Context, forwardEvent) error) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := l.tcp.Accept()
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Error("Error accepting forward connection", "err", err)
+				}
+				return
+			}
+			go func() {
+				defer conn.Close()
+				stop := context.AfterFunc(ctx, func() { conn.Close() })
+				defer stop()
+				if err := readForwardStream(ctx, conn, handle); err != nil && ctx.Err() == nil {
+					slog.Debug("Forward connection closed", "remote", conn.RemoteAddr(), "err", err)
+				}
+			}()
+		}
+	}()
+
+	<-ctx.Done()
+	l.Close()
+	wg.Wait()
+}
+
+// Close stops the listener, for servers that never called Serve.
+func (l *forwardListener) Close() {
+	l.tcp.Close()
+}
+
+// readForwardStream reads forward messages from conn until it is closed,
+// acknowledging those that ask for it once their events are handled.
+func readForwardStream(ctx context.Context, conn net.Conn, handle func(context.Context, forwardEvent) error) error {
+	reader := bufio.NewReader(conn)
+	for ctx.Err() == nil {
+		value, err := decodeMsgpack(reader)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		events, options, err := parseForwardMessage(value)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := handle(ctx, event); err != nil {
+				return err
+			}
+		}
+
+		if chunk, ok := options["chunk"].(string); ok {
+			ack := appendMsgpackString([]byte{0x81}, "ack")
+			ack = appendMsgpackString(ack, chunk)
+			if _, err := conn.Write(ack); err != nil {
+				return err
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// parseForwardMessage returns the events and options of a message in any of
+// the protocol's modes:
+//
+//	Message:       [tag, time, record, options]
+//	Forward:       [tag, [[time, record], ...], options]
+//	PackedForward: [tag, <msgpack stream of [time, record]>, options]
+//
+// PackedForward streams may be gzipped, as options["compressed"] says.
+func parseForwardMessage(value any) ([]forwardEvent, map[string]any, error) {
+	message, ok := value.([]any)
+	if !ok || len(message) < 2 {
+		return nil, nil, fmt.Errorf("forward: unexpected message %T", value)
+	}
+
+	// Options follow the entries of Forward and PackedForward messages. In
+	// Message mode the third element is the record, and options are read
+	// below only when a fourth follows it
+	var options map[string]any
+	switch message[1].(type) {
+	case []any, []byte, string:
+		if len(message) > 2 {
+			options, _ = message[2].(map[string]any)
+		}
+	}
+
+	switch entries := message[1].(type) {
+	case []any:
+		events := make([]forwardEvent, 0, len(entries))
+		for _, entry := range entries {
+			pair, ok := entry.([]any)
+			if !ok || len(pair) < 2 {
+				return nil, nil, fmt.Errorf("forward: unexpected entry %T", entry)
+			}
+			event, err := newForwardEvent(pair[0], pair[1])
+			if err != nil {
+				return nil, nil, err
+			}
+			events = append(events, event)
+		}
+		return events, options, nil
+
+	case []byte, string:
+		packed := toBytes(entries)
+		if options["compressed"] == "gzip" {
+			gz, err := gzip.NewReader(bytes.NewReader(packed))
+			if err != nil {
+				return nil, nil, err
+			}
+			if packed, err = io.ReadAll(io.LimitReader(gz, msgpackMaxLen)); err != nil {
+				return nil, nil, err
+			}
+		}
+		var events []forwardEvent
+		r := bufio.NewReader(bytes.NewReader(packed))
+		for {
+			entry, err := decodeMsgpack(r)
+			if errors.Is(err, io.EOF) {
+				return events, options, nil
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			pair, ok := entry.([]any)
+			if !ok || len(pair) < 2 {
+				return nil, nil, fmt.Errorf("forward: unexpected packed entry %T", entry)
+			}
+			event, err := newForwardEvent(pair[0], pair[1])
+			if err != nil {
+				return nil, nil, err
+			}
+			events = append(events, event)
+		}
+
+	default:
+		if len(message) < 3 {
+			return nil, nil, fmt.Errorf("forward: unexpected message")
+		}
+		if len(message) > 3 {
+			options, _ = message[3].(map[string]any)
+		}
+		event, err := newForwardEvent(message[1], message[2])
+		if err != nil {
+			return nil, nil, err
+		}
+		return []forwardEvent{event}, options, nil
+	}
+}
+
+// newForwardEvent decodes an event time, given in seconds or as an
+// EventTime extension with nanoseconds, and its record.
+func newForwardEvent(t, record any) (forwardEvent, error) {
+	event := forwardEvent{}
+	switch t := t.(type) {
+	case int64:
+		event.Time = time.Unix(t, 0)
+	case uint64:
+		event.Time = time.Unix(int64(t), 0)
+	case float64:
+		event.Time = time.Unix(0, int64(t*1e9))
+	case msgpackExt:
+		if t.Type != 0 || len(t.Data) != 8 {
+			return event, fmt.Errorf("forward: unexpected time extension %d", t.Type)
+		}
+		event.Time = time.Unix(int64(binary.BigEndian.Uint32(t.Data)), int64(binary.BigEndian.Uint32(t.Data[4:])))
+	default:
+		return event, fmt.Errorf("forward: unexpected time %T", t)
+	}
+
+	var ok bool
+	if event.Record, ok = record.(map[string]any); !ok {
+		return event, fmt.Errorf("forward: unexpected record %T", record)
+	}
+	return event, nil
+}
+
+func toBytes(v any) []byte {
+	switch v := v.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	}
+	return nil
+}
+
+// recordString returns a record field as a string, whatever type it was
+// sent as.
+func recordString(record map[string]any, key string) string {
+	switch v := record[key].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// handleForwardEvent handles a record holding a raw access or error log line
+// in "log", as produced by Fluent Bit's tail input, or in "message". Records
+// already parsed with Fluent Bit's nginx parser are taken field by field.
+func (s *Server) handleForwardEvent(ctx context.Context, event forwardEvent) error {
+	for _, key := range []string{"log", "message"} {
+		if line := strings.TrimSpace(recordString(event.Record, key)); line != "" {
+			if errorLogRegex.MatchString(line) {
+				return s.handleErrorLogLine(ctx, line)
+			}
+			return s.handleAccessLogLine(ctx, line)
+		}
+	}
+
+	entry := LogEntry{
+		Timestamp: event.Time,
+		IP:        recordString(event.Record, "remote"),
+		Method:    recordString(event.Record, "method"),
+		URL:       recordString(event.Record, "path"),
+		Referer:   recordString(event.Record, "referer"),
+		UserAgent: recordString(event.Record, "agent"),
+	}
+	entry.StatusCode, _ = strconv.Atoi(recordString(event.Record, "code"))
+	entry.Size, _ = strconv.Atoi(recordString(event.Record, "size"))
+	if entry, ok := s.ingestedEntry(entry); ok {
+		return s.submitEntry(ctx, entry)
+	}
+	slog.Debug("Skipping forward record without a log line", "record", event.Record)
+	return nil
+}
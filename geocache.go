@@ -0,0 +1,190 @@
+package nginxviz
+
+import (
+	"container/list"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+)
+
+type geoCacheItem struct {
+	ip     netip.Addr
+	record ipRecord
+}
+
+// geoIPCache is an LRU of decoded GeoIP records so repeat visitors skip the
+// mmdb lookup.
+type geoIPCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[netip.Addr]*list.Element
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func newGeoIPCache(size int) *geoIPCache {
+	return &geoIPCache{
+		size:  size,
+		order: list.New(),
+		items: make(map[netip.Addr]*list.Element),
+	}
+}
+
+func (c *geoIPCache) Get(ip netip.Addr) (ipRecord, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[ip]
+	if !ok {
+		c.misses.Add(1)
+		return ipRecord{}, false
+	}
+	c.hits.Add(1)
+	c.order.MoveToFront(el)
+	return el.Value.(*geoCacheItem).record, true
+}
+
+func (c *geoIPCache) Contains(ip netip.Addr) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.items[ip]
+	return ok
+}
+
+// Clear empties the cache, after the database it was filled from changed.
+func (c *geoIPCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	clear(c.items)
+}
+
+func (c *geoIPCache) Put(ip netip.Addr, record ipRecord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[ip]; ok {
+		el.Value.(*geoCacheItem).record = record
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[ip] = c.order.PushFront(&geoCacheItem{ip: ip, record: record})
+
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*geoCacheItem).ip)
+	}
+}
+
+type geoCacheStats struct {
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hit_rate"` // hits per lookup, 0 before the first
+	Entries int     `json:"entries"`
+	Size    int     `json:"size"`
+}
+
+// Stats returns the lookups the cache answered and missed since startup.
+func (c *geoIPCache) Stats() geoCacheStats {
+	c.mu.Lock()
+	entries := c.order.Len()
+	c.mu.Unlock()
+
+	stats := geoCacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Entries: entries, Size: c.size}
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(lookups)
+	}
+	return stats
+}
+
+// MakeGeoCacheHandler returns the GeoIP cache's hit and miss counts.
+func MakeGeoCacheHandler(cache *geoIPCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, cache.Stats())
+	}
+}
+
+type cacheWarmupResult struct {
+	Warmed  int `json:"warmed"`
+	Skipped int `json:"skipped"`
+	Errors  int `json:"errors"`
+}
+
+type cacheWarmupProgress struct {
+	Type string `json:"type"`
+	Pct  int    `json:"pct"`
+	*cacheWarmupResult
+}
+
+// MakeWarmCacheHandler starts pre-populating the GeoIP cache with the IPs in
+// the history buffer. Progress and the final counts are sent to admin
+// websocket clients as cache_warmup_progress messages.
+func (s *Server) MakeWarmCacheHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.cacheWarmupRunning.CompareAndSwap(false, true) {
+			returnError(w, http.StatusConflict, "a cache warmup is already running")
+			return
+		}
+
+		ips := s.uniqueHistoryIPs()
+		go func() {
+			defer s.cacheWarmupRunning.Store(false)
+			s.warmGeoIPCache(ips)
+		}()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]int{"ips": len(ips)})
+	}
+}
+
+func (s *Server) uniqueHistoryIPs() []string {
+	seen := make(map[string]bool)
+	var ips []string
+	for _, e := range s.history.Snapshot() {
+		if !seen[e.IP] {
+			seen[e.IP] = true
+			ips = append(ips, e.IP)
+		}
+	}
+	return ips
+}
+
+func (s *Server) warmGeoIPCache(ips []string) {
+	var result cacheWarmupResult
+	lastPct := -1
+
+	for i, addr := range ips {
+		ip, err := netip.ParseAddr(addr)
+		switch {
+		case err != nil:
+			result.Errors++
+		case s.geoCache.Contains(ip):
+			result.Skipped++
+		default:
+			if _, err := s.lookupIP(ip); err != nil {
+				result.Errors++
+			} else {
+				result.Warmed++
+			}
+		}
+
+		// Report in 5% steps to avoid flooding admin clients
+		pct := (i + 1) * 100 / len(ips)
+		if pct/5 != lastPct/5 && pct < 100 {
+			lastPct = pct
+			s.publishAdminEvent(cacheWarmupProgress{Type: "cache_warmup_progress", Pct: pct})
+		}
+	}
+
+	slog.Info("GeoIP cache warmed", "warmed", result.Warmed, "skipped", result.Skipped, "errors", result.Errors)
+	s.publishAdminEvent(cacheWarmupProgress{Type: "cache_warmup_progress", Pct: 100, cacheWarmupResult: &result})
+}
@@ -0,0 +1,122 @@
+package nginxviz
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+const geoIPCheckInterval = 30 * time.Second
+
+// privateCountry and privateCountryFull are the country of addresses that
+// are not on the public internet, which no GeoIP database can place.
+const (
+	privateCountry     = "PRIVATE"
+	privateCountryFull = "Private network"
+)
+
+// cgnatPrefix is the shared address space of RFC 6598, used by carriers
+// for NAT between their customers and the internet.
+var cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// isPrivateIP reports whether ip is a private (RFC 1918 or a unique local
+// IPv6 address), loopback, link-local or carrier-grade NAT address.
+func isPrivateIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || cgnatPrefix.Contains(ip)
+}
+
+// privateIPRecord is the record of every private address.
+func privateIPRecord() ipRecord {
+	var record ipRecord
+	record.Country.ISOCode = privateCountry
+	record.Country.Names = map[string]string{"en": privateCountryFull}
+	return record
+}
+
+// openGeoIPFile reads a MaxMind or DB-IP country or city database, both of
+// which have the country of an address where ipRecord expects it. It is
+// read into memory rather than mapped, so a file overwritten in place by an
+// update cannot pull the data from under a running lookup.
+func openGeoIPFile(path string) (*maxminddb.Reader, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return maxminddb.OpenBytes(data)
+}
+
+// watchGeoIPFile checks GeoIPFile for changes until ctx is cancelled and
+// switches to the new database when it was updated, for example by
+// geoipupdate. A file that cannot be opened is logged and the database in
+// use is kept.
+func (s *Server) watchGeoIPFile(ctx context.Context) {
+	path := s.cfg.GeoIPFile
+	info, err := os.Stat(path)
+	if err != nil {
+		slog.Warn("Cannot watch GeoIP database", "file", path, "err", err)
+	}
+
+	ticker := time.NewTicker(geoIPCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		fresh, err := os.Stat(path)
+		if err != nil {
+			slog.Debug("Error checking GeoIP database", "file", path, "err", err)
+			continue
+		}
+		if info != nil && fresh.ModTime().Equal(info.ModTime()) && fresh.Size() == info.Size() {
+			continue
+		}
+		info = fresh
+
+		db, err := openGeoIPFile(path)
+		if err != nil {
+			slog.Error("Error reloading GeoIP database, keeping the current one", "file", path, "err", err)
+			continue
+		}
+		s.useGeoIPDatabase(db)
+	}
+}
+
+// useGeoIPDatabase switches lookups to db and clears the cache of the old
+// database's records.
+func (s *Server) useGeoIPDatabase(db *maxminddb.Reader) {
+	// The old reader is left to the garbage collector rather than closed,
+	// as the GeoIP workers may still be using it
+	s.db.Store(db)
+	s.geoCache.Clear()
+	slog.Info("Switched GeoIP database", "type", db.Metadata.DatabaseType,
+		"built", time.Unix(int64(db.Metadata.BuildEpoch), 0).UTC())
+}
+
+// apply sets the location and network fields of entry from the record, in
+// English.
+func (r ipRecord) apply(entry *LogEntry) {
+	entry.Country = r.Country.ISOCode
+	entry.CountryFull = r.Country.Names["en"]
+	entry.City = r.City.Names["en"]
+	entry.Region = ""
+	if len(r.Subdivisions) > 0 {
+		entry.Region = r.Subdivisions[0].Names["en"]
+	}
+	entry.Latitude, entry.Longitude = r.Location.Latitude, r.Location.Longitude
+	entry.ASN, entry.ASOrg = r.ASN, r.ASOrg
+	// GeoIP2-ISP has the ISP separately from the AS organization
+	entry.ISP = r.ISP
+	if entry.ISP == "" {
+		entry.ISP = r.Organization
+	}
+	entry.Hosting = isHostingASN(r.ASN)
+}
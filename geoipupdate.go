@@ -0,0 +1,259 @@
+package nginxviz
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+const (
+	geoIPMaxDownload   = 512 << 20
+	maxMindDownloadURL = "https://download.maxmind.com/geoip/databases/GeoLite2-Country/download"
+	dbIPDownloadURL    = "https://download.db-ip.com/free/dbip-country-lite-%s.mmdb.gz"
+)
+
+// errGeoIPNotFound is returned for a download that does not exist (yet).
+var errGeoIPNotFound = errors.New("not found")
+
+// geoIPUpdater downloads country databases from MaxMind, DB-IP or any URL
+// serving an .mmdb file, gzipped or in a tar.gz.
+type geoIPUpdater struct {
+	source     string // "maxmind", "dbip" or a URL
+	accountID  string
+	licenseKey string
+	http       *http.Client
+}
+
+func newGeoIPUpdater(cfg Config) (*geoIPUpdater, error) {
+	u := &geoIPUpdater{
+		source:     cfg.GeoIPUpdate,
+		accountID:  cfg.GeoIPAccountID,
+		licenseKey: cfg.GeoIPLicenseKey,
+		http:       &http.Client{Timeout: 5 * time.Minute},
+	}
+	switch u.source {
+	case "maxmind":
+		if u.accountID == "" || u.licenseKey == "" {
+			return nil, fmt.Errorf("maxmind needs an account ID and a license key")
+		}
+	case "dbip":
+	default:
+		parsed, err := url.Parse(u.source)
+		if err != nil || parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return nil, fmt.Errorf("must be maxmind, dbip or an http(s) URL")
+		}
+	}
+	return u, nil
+}
+
+// download fetches the latest database.
+func (u *geoIPUpdater) download(ctx context.Context) ([]byte, error) {
+	switch u.source {
+	case "maxmind":
+		return u.downloadMaxMind(ctx)
+	case "dbip":
+		// DB-IP publishes each month's database on its first days, so the
+		// previous month's is used until then
+		now := time.Now().UTC()
+		data, err := u.get(ctx, fmt.Sprintf(dbIPDownloadURL, now.Format("2006-01")), "")
+		if errors.Is(err, errGeoIPNotFound) {
+			data, err = u.get(ctx, fmt.Sprintf(dbIPDownloadURL, now.AddDate(0, 0, -now.Day()).Format("2006-01")), "")
+		}
+		if err != nil {
+			return nil, err
+		}
+		return unpackGeoIPDatabase("db.mmdb.gz", data)
+	default:
+		data, err := u.get(ctx, u.source, "")
+		if err != nil {
+			return nil, err
+		}
+		parsed, _ := url.Parse(u.source)
+		return unpackGeoIPDatabase(parsed.Path, data)
+	}
+}
+
+// downloadMaxMind fetches GeoLite2-Country and checks it against the
+// SHA-256 MaxMind publishes next to it.
+func (u *geoIPUpdater) downloadMaxMind(ctx context.Context) ([]byte, error) {
+	archive, err := u.get(ctx, maxMindDownloadURL+"?suffix=tar.gz", u.accountID+":"+u.licenseKey)
+	if err != nil {
+		return nil, err
+	}
+	checksum, err := u.get(ctx, maxMindDownloadURL+"?suffix=tar.gz.sha256", u.accountID+":"+u.licenseKey)
+	if err != nil {
+		return nil, fmt.Errorf("downloading checksum: %w", err)
+	}
+	want, _, _ := strings.Cut(strings.TrimSpace(string(checksum)), " ")
+	got := sha256.Sum256(archive)
+	if !strings.EqualFold(want, hex.EncodeToString(got[:])) {
+		return nil, fmt.Errorf("checksum mismatch: got %x, want %s", got, want)
+	}
+	return unpackGeoIPDatabase("db.tar.gz", archive)
+}
+
+// get downloads url, with basic auth when userinfo is set.
+func (u *geoIPUpdater) get(ctx context.Context, url, userinfo string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if user, pass, ok := strings.Cut(userinfo, ":"); ok {
+		req.SetBasicAuth(user, pass)
+	}
+
+	resp, err := u.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errGeoIPNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("downloading GeoIP database: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, geoIPMaxDownload+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > geoIPMaxDownload {
+		return nil, fmt.Errorf("GeoIP database larger than %d bytes", geoIPMaxDownload)
+	}
+	return data, nil
+}
+
+// unpackGeoIPDatabase returns the .mmdb in a download named name: the
+// file itself, gzipped, or the first .mmdb in a tar.gz as MaxMind ships it.
+func unpackGeoIPDatabase(name string, data []byte) ([]byte, error) {
+	if !strings.HasSuffix(name, ".gz") && !strings.HasSuffix(name, ".tgz") {
+		return data, nil
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	r := io.LimitReader(gz, geoIPMaxDownload)
+
+	if !strings.HasSuffix(name, ".tar.gz") && !strings.HasSuffix(name, ".tgz") {
+		return io.ReadAll(r)
+	}
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("no .mmdb file in archive")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg && strings.HasSuffix(header.Name, ".mmdb") {
+			return io.ReadAll(archive)
+		}
+	}
+}
+
+// verifyGeoIPDatabase opens a downloaded database and checks that it knows
+// the country of a well known address and is not older than current.
+func verifyGeoIPDatabase(data []byte, current *maxminddb.Reader) (*maxminddb.Reader, error) {
+	db, err := maxminddb.OpenBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	var record ipRecord
+	if err := db.Lookup(netip.MustParseAddr("8.8.8.8")).Decode(&record); err != nil || record.Country.ISOCode == "" {
+		return nil, fmt.Errorf("%s database has no countries", db.Metadata.DatabaseType)
+	}
+	if current != nil && db.Metadata.BuildEpoch < current.Metadata.BuildEpoch {
+		return nil, fmt.Errorf("downloaded database built %s is older than the one in use",
+			time.Unix(int64(db.Metadata.BuildEpoch), 0).UTC().Format(time.DateOnly))
+	}
+	return db, nil
+}
+
+// updateGeoIP downloads the database every GeoIPRefresh until ctx is
+// cancelled. With GeoIPFile set, the download replaces that file, where
+// watchGeoIPFile picks it up and a restart finds it, and an existing file
+// younger than the interval is not downloaded again on startup. Otherwise
+// the new database is used straight away.
+func (s *Server) updateGeoIP(ctx context.Context, updater *geoIPUpdater) {
+	wait := time.Duration(0)
+	if info, err := os.Stat(s.cfg.GeoIPFile); s.cfg.GeoIPFile != "" && err == nil {
+		wait = max(0, s.cfg.GeoIPRefresh-time.Since(info.ModTime()))
+	}
+
+	for {
+		if sleepContext(ctx, wait) != nil {
+			return
+		}
+		wait = s.cfg.GeoIPRefresh
+
+		if err := s.updateGeoIPOnce(ctx, updater); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// Retry sooner than a full interval after a failure
+			wait = min(wait, time.Hour)
+			slog.Error("Error updating GeoIP database", "source", updater.source, "err", err, "retry_in", wait.String())
+		}
+	}
+}
+
+func (s *Server) updateGeoIPOnce(ctx context.Context, updater *geoIPUpdater) error {
+	slog.Info("Downloading GeoIP database", "source", updater.source)
+	data, err := updater.download(ctx)
+	if err != nil {
+		return err
+	}
+	db, err := verifyGeoIPDatabase(data, s.db.Load())
+	if err != nil {
+		return err
+	}
+
+	if s.cfg.GeoIPFile != "" {
+		return writeFileAtomic(s.cfg.GeoIPFile, data, 0o644)
+	}
+	s.useGeoIPDatabase(db)
+	return nil
+}
+
+// writeFileAtomic replaces path with data by renaming a temporary file over
+// it, so readers never see it half written.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
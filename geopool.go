@@ -0,0 +1,62 @@
+package nginxviz
+
+import "context"
+
+type geoJob struct {
+	entry  LogEntry
+	result chan<- LogEntry
+}
+
+// GeoIPWorkerPool enriches parsed entries on a fixed number of goroutines so
+// GeoIP latency is decoupled from the log read loop. enrich must be safe for
+// concurrent use; it returns false for entries that should be dropped.
+// Entries may be forwarded out of order.
+type GeoIPWorkerPool struct {
+	enrich func(LogEntry) (LogEntry, bool)
+	jobs   chan geoJob
+}
+
+func NewGeoIPWorkerPool(enrich func(LogEntry) (LogEntry, bool)) *GeoIPWorkerPool {
+	return &GeoIPWorkerPool{
+		enrich: enrich,
+		jobs:   make(chan geoJob),
+	}
+}
+
+// Start launches the workers. They exit when ctx is cancelled.
+func (p *GeoIPWorkerPool) Start(ctx context.Context, workers int) {
+	for i := 0; i < workers; i++ {
+		go p.worker(ctx)
+	}
+}
+
+// Submit hands an entry to the pool. The enriched entry is sent to result.
+// It blocks until a worker is free, applying backpressure to the reader.
+func (p *GeoIPWorkerPool) Submit(ctx context.Context, entry LogEntry, result chan<- LogEntry) error {
+	select {
+	case p.jobs <- geoJob{entry: entry, result: result}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *GeoIPWorkerPool) worker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-p.jobs:
+			entry, ok := p.enrich(job.entry)
+			if !ok {
+				continue
+			}
+
+			select {
+			case job.result <- entry:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package nginxviz
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const haproxyTimeLayout = "02/Jan/2006:15:04:05.000"
+
+// haproxyLogRegex matches HAProxy's HTTP log format ("option httplog"),
+// with or without the syslog header in front of it:
+//
+//	10.0.1.2:33317 [06/Feb/2009:12:14:14.655] http-in static/srv1 10/0/30/69/109 200 2750
+//	- - ---- 1/1/1/1/0 0/0 {1wt.eu} {} "GET /index.html HTTP/1.1"
+var haproxyLogRegex = regexp.MustCompile(`(?:^|\s)(\S+):\d+ \[([^\]]+)\] (\S+) ([^/\s]+)/(\S+) (-?\d+)/(-?\d+)/(-?\d+)/(-?\d+)/\+?(-?\d+) (\d+) \+?(\d+) \S+ \S+ (\S{4}) \S+ \S+(?: \{[^}]*\})*(?: "([^"]*)")?`)
+
+func init() {
+	RegisterParser("haproxy", func(Config) (Parser, error) { return haproxyLogParser{}, nil })
+}
+
+// haproxyLogParser parses HAProxy HTTP logs. The backend becomes the entry's
+// Upstream; the frontend, server and termination state are kept in Extra.
+type haproxyLogParser struct{}
+
+func (haproxyLogParser) Parse(line string) (LogEntry, error) {
+	matches := haproxyLogRegex.FindStringSubmatch(line)
+	if matches == nil {
+		return LogEntry{}, fmt.Errorf("failed to parse HAProxy log line: %s", line)
+	}
+
+	// The accept date is logged in the local time of the HAProxy host
+	timestamp, err := time.ParseInLocation(haproxyTimeLayout, matches[2], time.Local)
+	if err != nil {
+		timestamp = time.Now()
+	}
+
+	var method, url, protocol string
+	if request := strings.Fields(matches[14]); len(request) >= 2 {
+		method, url = request[0], request[1]
+		if len(request) >= 3 {
+			protocol = request[2]
+		}
+	}
+
+	statusCode, _ := strconv.Atoi(matches[11])
+	size, _ := strconv.Atoi(matches[12])
+
+	return LogEntry{
+		Timestamp:  timestamp,
+		IP:         matches[1],
+		Method:     method,
+		URL:        url,
+		Protocol:   protocol,
+		StatusCode: statusCode,
+		Size:       size,
+		// Tr is the server's response time and Ta the total active time,
+		// both in milliseconds and -1 when the request did not get that far
+		RequestTime:  haproxyTimer(matches[10]),
+		UpstreamTime: haproxyTimer(matches[9]),
+		Upstream:     matches[4],
+		Extra: map[string]string{
+			"frontend":          matches[3],
+			"server":            matches[5],
+			"termination_state": matches[13],
+		},
+	}, nil
+}
+
+// haproxyTimer converts a timer in milliseconds to seconds.
+func haproxyTimer(value string) float64 {
+	ms, err := strconv.Atoi(value)
+	if err != nil || ms < 0 {
+		return 0
+	}
+	return float64(ms) / 1000
+}
@@ -0,0 +1,77 @@
+package nginxviz
+
+import "sync"
+
+// sequencedEntry is a log entry with the sequence number it was broadcast with.
+type sequencedEntry struct {
+	Seq   uint64
+	Entry LogEntry
+}
+
+// entryHistory is a fixed-size ring buffer holding the most recent log entries.
+type entryHistory struct {
+	mu      sync.RWMutex
+	entries []sequencedEntry
+	next    int
+	full    bool
+	lastSeq uint64
+}
+
+func newEntryHistory(size int) *entryHistory {
+	if size < 1 {
+		size = 1
+	}
+	return &entryHistory{entries: make([]sequencedEntry, size)}
+}
+
+// Add stores an entry, overwriting the oldest one when the buffer is full,
+// and returns the sequence number assigned to it.
+func (h *entryHistory) Add(entry LogEntry) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastSeq++
+	h.entries[h.next] = sequencedEntry{Seq: h.lastSeq, Entry: entry}
+	h.next = (h.next + 1) % len(h.entries)
+	if h.next == 0 {
+		h.full = true
+	}
+	return h.lastSeq
+}
+
+// LastSeq returns the sequence number of the most recent entry.
+func (h *entryHistory) LastSeq() uint64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.lastSeq
+}
+
+// Snapshot returns a copy of the buffered entries, oldest first.
+func (h *entryHistory) Snapshot() []LogEntry {
+	ordered := h.Since(0)
+	snapshot := make([]LogEntry, len(ordered))
+	for i, e := range ordered {
+		snapshot[i] = e.Entry
+	}
+	return snapshot
+}
+
+// Since returns the buffered entries with a sequence number above seq, oldest first.
+func (h *entryHistory) Since(seq uint64) []sequencedEntry {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	ordered := h.entries[:h.next]
+	if h.full {
+		ordered = append(append([]sequencedEntry(nil), h.entries[h.next:]...), h.entries[:h.next]...)
+	}
+
+	since := make([]sequencedEntry, 0, len(ordered))
+	for _, e := range ordered {
+		if e.Seq > seq {
+			since = append(since, e)
+		}
+	}
+	return since
+}
@@ -0,0 +1,24 @@
+package nginxviz
+
+// honeypotAlert is sent to admin clients when a trap URL is requested.
+type honeypotAlert struct {
+	Type string   `json:"type"`
+	Data LogEntry `json:"data"`
+}
+
+// honeypotSet holds the trap URLs planted in robots.txt. Well behaved
+// crawlers never request them, so any hit is a bot ignoring robots.txt.
+type honeypotSet map[string]bool
+
+func newHoneypotSet(urls []string) honeypotSet {
+	set := make(honeypotSet, len(urls))
+	for _, u := range urls {
+		set[u] = true
+	}
+	return set
+}
+
+// Matches reports whether url is exactly one of the trap URLs.
+func (h honeypotSet) Matches(url string) bool {
+	return h[url]
+}
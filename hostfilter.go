@@ -0,0 +1,80 @@
+package nginxviz
+
+import (
+	"encoding/json"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// hostFilter is the set of virtual hosts a websocket client wants entries
+// for. A nil filter lets every entry through.
+type hostFilter map[string]bool
+
+func newHostFilter(hosts []string) hostFilter {
+	var filter hostFilter
+	for _, host := range hosts {
+		if host = normalizeHost(host); host == "" {
+			continue
+		}
+		if filter == nil {
+			filter = make(hostFilter)
+		}
+		filter[host] = true
+	}
+	return filter
+}
+
+// hostFilterFromQuery reads ?host=a.com,b.com, which may also be repeated.
+func hostFilterFromQuery(query url.Values) hostFilter {
+	var hosts []string
+	for _, value := range query["host"] {
+		hosts = append(hosts, strings.Split(value, ",")...)
+	}
+	return newHostFilter(hosts)
+}
+
+func (f hostFilter) Matches(host string) bool {
+	return f == nil || f[normalizeHost(host)]
+}
+
+// normalizeHost lowercases host and strips a port, so example.com matches
+// entries logged as Example.com:443.
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host
+}
+
+// clientFilter is sent by websocket clients to change the hosts they
+// receive entries for, and whether those from Tor exit nodes, "only" or
+// "exclude". An empty list and tor clear the filter.
+type clientFilter struct {
+	Type  string   `json:"type"`
+	Hosts []string `json:"hosts"`
+	Tor   string   `json:"tor"`
+}
+
+// parseClientFilter reports whether message is a filter message.
+func parseClientFilter(message []byte) (clientFilter, bool) {
+	var filter clientFilter
+	if err := json.Unmarshal(message, &filter); err != nil {
+		return clientFilter{}, false
+	}
+	return filter, filter.Type == "filter"
+}
+
+// setClientFilter replaces the host and Tor filters of a connected client.
+func (s *Server) setClientFilter(conn *websocket.Conn, filter clientFilter) {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+
+	if info, ok := s.clients[conn]; ok {
+		info.Hosts = newHostFilter(filter.Hosts)
+		info.Tor = parseTorFilter(filter.Tor)
+	}
+}
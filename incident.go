@@ -0,0 +1,138 @@
+package nginxviz
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// incidentMaxRecent is how many error entries and server errors are kept
+// for matching against each other.
+const incidentMaxRecent = 500
+
+type incidentUpdate struct {
+	Type   string       `json:"type"`
+	ID     uint64       `json:"id"`
+	Access LogEntry     `json:"access"`
+	Errors []ErrorEntry `json:"errors"`
+}
+
+// pendingIncident is a 5xx access entry and the error log lines matched to
+// it so far.
+type pendingIncident struct {
+	id     uint64
+	access LogEntry
+	errors []ErrorEntry
+}
+
+// IncidentCorrelator pairs 5xx access log entries with the error log lines
+// nginx wrote for the same request: same client, the same request line when
+// the error names one, and timestamps within window of each other. The two
+// logs are read independently, so either side may arrive first.
+type IncidentCorrelator struct {
+	mu     sync.Mutex
+	window time.Duration
+	nextID uint64
+	errors []ErrorEntry
+	access []*pendingIncident
+}
+
+// NewIncidentCorrelator returns a correlator, or nil when window is not
+// positive. A nil correlator matches nothing.
+func NewIncidentCorrelator(window time.Duration) *IncidentCorrelator {
+	if window <= 0 {
+		return nil
+	}
+	return &IncidentCorrelator{window: window}
+}
+
+// AddAccess records a server error and returns an incident when error log
+// lines for it have already been seen.
+func (c *IncidentCorrelator) AddAccess(entry LogEntry) (incidentUpdate, bool) {
+	if c == nil || entry.StatusCode < 500 {
+		return incidentUpdate{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextID++
+	pending := &pendingIncident{id: c.nextID, access: entry}
+	for _, errEntry := range c.errors {
+		if c.matches(entry, errEntry) {
+			pending.errors = append(pending.errors, errEntry)
+		}
+	}
+	c.access = appendRecent(c.access, pending)
+	if len(pending.errors) == 0 {
+		return incidentUpdate{}, false
+	}
+	return pending.update(), true
+}
+
+// AddError records an error log line and returns the incident of the server
+// error it belongs to, if that was already seen. Incidents are sent again
+// with the same ID as more of their lines arrive.
+func (c *IncidentCorrelator) AddError(entry ErrorEntry) (incidentUpdate, bool) {
+	if c == nil || entry.ClientIP == "" {
+		return incidentUpdate{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.errors = appendRecent(c.errors, entry)
+	// The newest server error is the likeliest to be this line's
+	for i := len(c.access) - 1; i >= 0; i-- {
+		pending := c.access[i]
+		if c.matches(pending.access, entry) {
+			pending.errors = append(pending.errors, entry)
+			return pending.update(), true
+		}
+	}
+	return incidentUpdate{}, false
+}
+
+func (c *IncidentCorrelator) matches(access LogEntry, errEntry ErrorEntry) bool {
+	if access.IP != errEntry.ClientIP {
+		return false
+	}
+	if gap := access.Timestamp.Sub(errEntry.Timestamp).Abs(); gap > c.window {
+		return false
+	}
+	if errEntry.Request == "" {
+		return true
+	}
+	// The error log names the request line, with the URL as requested
+	method, rest, _ := strings.Cut(errEntry.Request, " ")
+	target, _, _ := strings.Cut(rest, " ")
+	return method == access.Method && unescapedPath(target) == unescapedPath(access.URL)
+}
+
+func unescapedPath(target string) string {
+	path, _, _ := strings.Cut(target, "?")
+	if unescaped, err := url.PathUnescape(path); err == nil {
+		return unescaped
+	}
+	return path
+}
+
+func (p *pendingIncident) update() incidentUpdate {
+	return incidentUpdate{
+		Type:   "incident",
+		ID:     p.id,
+		Access: p.access,
+		Errors: append([]ErrorEntry(nil), p.errors...),
+	}
+}
+
+// appendRecent appends v, dropping the oldest values beyond
+// incidentMaxRecent.
+func appendRecent[T any](values []T, v T) []T {
+	values = append(values, v)
+	if len(values) > incidentMaxRecent {
+		values = append(values[:0], values[len(values)-incidentMaxRecent:]...)
+	}
+	return values
+}
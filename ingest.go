@@ -0,0 +1,98 @@
+package nginxviz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// ingestMaxBody caps the size of a single ingest request.
+const ingestMaxBody = 16 << 20
+
+type ingestResponse struct {
+	Accepted int `json:"accepted"`
+	Skipped  int `json:"skipped"`
+}
+
+// MakeIngestHandler accepts log entries pushed by other hosts: raw log lines
+// separated by newlines, parsed like lines of the log file, or with a JSON
+// content type an array of LogEntry objects. Entries go through the same
+// filtering and enrichment as those read from the log.
+func (s *Server) MakeIngestHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := http.MaxBytesReader(w, r.Body, ingestMaxBody)
+
+		var resp ingestResponse
+		submit := func(ctx context.Context, entry LogEntry, ok bool) error {
+			if !ok {
+				resp.Skipped++
+				return nil
+			}
+			resp.Accepted++
+			return s.submitEntry(ctx, entry)
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		var err error
+		if mediaType == "application/json" {
+			var entries []LogEntry
+			if err = json.NewDecoder(body).Decode(&entries); err == nil {
+				for _, entry := range entries {
+					entry, ok := s.ingestedEntry(entry)
+					if err = submit(r.Context(), entry, ok); err != nil {
+						break
+					}
+				}
+			}
+		} else {
+			err = forEachLine(r.Context(), body, func(ctx context.Context, line string) error {
+				entry, ok := s.processLogLine(line)
+				return submit(ctx, entry, ok)
+			})
+		}
+
+		var tooLarge *http.MaxBytesError
+		var syntax *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &tooLarge):
+			returnError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		case errors.As(err, &syntax), errors.As(err, &typeErr):
+			returnError(w, http.StatusBadRequest, "invalid JSON: expected an array of log entries")
+			return
+		case err != nil:
+			returnError(w, http.StatusBadRequest, "error reading request")
+			return
+		}
+		writeJSON(w, resp)
+	}
+}
+
+// ingestedEntry keeps the logged fields of a pushed entry, leaving the ones
+// the server derives itself to be filled in as for any other entry.
+func (s *Server) ingestedEntry(entry LogEntry) (LogEntry, bool) {
+	if entry.IP == "" {
+		return LogEntry{}, false
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	entry.Country, entry.CountryFull, entry.Hostname = "", "", ""
+	entry.City, entry.Region, entry.Latitude, entry.Longitude = "", "", 0, 0
+	entry.ASN, entry.ASOrg, entry.ISP, entry.Hosting = 0, "", "", false
+	entry.Rate, entry.Suspicious = 0, false
+	entry.HoneypotHit = false
+	entry.BotScore, entry.IsBot, entry.IsWhitelistedBot = 0, false, false
+	entry.VerifiedCrawler, entry.SpoofedCrawler = "", false
+	entry.ThreatList, entry.ThreatScore, entry.Tor = "", 0, false
+	entry.VisitorID, entry.SessionID = "", ""
+	entry.Browser, entry.BrowserVersion, entry.OS, entry.DeviceType = "", "", "", ""
+	entry.RefererDomain, entry.RefererType = "", ""
+	entry.AnomalyScore, entry.IsAnomalous = 0, false
+	entry.ParseWarnings = nil
+	return s.filterEntry(entry)
+}
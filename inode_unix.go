@@ -0,0 +1,29 @@
+//go:build unix
+
+package nginxviz
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// getInode returns the inode of a file, which tells a rotated log apart
+// from the new file that replaced it at the same path.
+func getInode(logFile string) (uint64, error) {
+	freshInfo, err := os.Stat(logFile)
+	if err != nil {
+		return 0.0, err
+	}
+	freshStat, ok := freshInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0.0, fmt.Errorf("Syscall Error")
+	}
+
+	return uint64(freshStat.Ino), nil
+}
+
+// openLogFile opens a log file for reading.
+func openLogFile(path string) (*os.File, error) {
+	return os.Open(path)
+}
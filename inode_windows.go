@@ -0,0 +1,40 @@
+package nginxviz
+
+import (
+	"os"
+	"syscall"
+)
+
+// getInode returns the NTFS file index of a file, which like an inode stays
+// with the file when it is renamed and tells a rotated log apart from the
+// new file that replaced it at the same path.
+func getInode(logFile string) (uint64, error) {
+	file, err := openLogFile(logFile)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var info syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(syscall.Handle(file.Fd()), &info); err != nil {
+		return 0, &os.PathError{Op: "GetFileInformationByHandle", Path: logFile, Err: err}
+	}
+	return uint64(info.FileIndexHigh)<<32 | uint64(info.FileIndexLow), nil
+}
+
+// openLogFile opens a log file for reading without locking it. os.Open
+// leaves out FILE_SHARE_DELETE, so while the file is followed whoever
+// rotates it could not rename or delete it.
+func openLogFile(path string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	handle, err := syscall.CreateFile(name, syscall.GENERIC_READ,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(handle), path), nil
+}
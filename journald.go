@@ -0,0 +1,103 @@
+package nginxviz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// journalPrefix marks a log file name that reads the systemd journal of a
+// unit, such as journald:nginx.service, instead of a file.
+const journalPrefix = "journald:"
+
+// journalUnit returns the unit of a journald: log file name.
+func journalUnit(path string) (string, bool) {
+	return strings.CutPrefix(path, journalPrefix)
+}
+
+// journalRecord is the part of a `journalctl -o json` record that is used.
+// MESSAGE is a string, or an array of bytes when it is not valid UTF-8.
+type journalRecord struct {
+	Message json.RawMessage `json:"MESSAGE"`
+}
+
+func (r journalRecord) message() (string, error) {
+	var s string
+	if err := json.Unmarshal(r.Message, &s); err == nil {
+		return s, nil
+	}
+	var raw []int
+	if err := json.Unmarshal(r.Message, &raw); err != nil {
+		return "", fmt.Errorf("unexpected journal MESSAGE: %s", r.Message)
+	}
+	b := make([]byte, len(raw))
+	for i, c := range raw {
+		b[i] = byte(c)
+	}
+	return string(b), nil
+}
+
+// journalArgs returns the journalctl arguments that follow unit, starting
+// where -from-end and -backfill say a log file would start.
+func (s *Server) journalArgs(unit string) []string {
+	args := []string{"--output=json", "--follow", "--unit=" + unit}
+	switch {
+	case s.cfg.FromEnd:
+		args = append(args, "--lines=0")
+	case s.cfg.Backfill > 0:
+		args = append(args, "--lines="+strconv.Itoa(s.cfg.Backfill))
+	default:
+		args = append(args, "--lines=all")
+	}
+	if s.cfg.BackfillSince > 0 {
+		args = append(args, "--since="+time.Now().Add(-s.cfg.BackfillSince).Format(time.DateTime))
+	}
+	return args
+}
+
+// readJournal passes the message of each journal entry of unit to
+// handleLine until ctx is cancelled. journalctl is restarted after a pause
+// if it exits; messages it had already passed on are then seen again.
+func (s *Server) readJournal(ctx context.Context, unit string, handleLine lineHandler) {
+	args := s.journalArgs(unit)
+	for {
+		slog.Info("Reading systemd journal", "unit", unit)
+		err := s.followJournal(ctx, args, handleLine)
+		if ctx.Err() != nil {
+			slog.Info("Stopped reading systemd journal", "unit", unit)
+			return
+		}
+		if errors.Is(err, exec.ErrNotFound) {
+			slog.Error("Cannot read systemd journal", "unit", unit, "err", err)
+			return
+		}
+		slog.Error("Error reading systemd journal", "unit", unit, "err", err)
+		if sleepContext(ctx, 2*time.Second) != nil {
+			return
+		}
+	}
+}
+
+// followJournal runs journalctl with args until it exits or ctx is
+// cancelled.
+func (s *Server) followJournal(ctx context.Context, args []string, handleLine lineHandler) error {
+	return followCommand(ctx, "journalctl", args, func(ctx context.Context, line string) error {
+		var record journalRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			slog.Debug("Skipping journal record", "err", err)
+			return nil
+		}
+		message, err := record.message()
+		if err != nil {
+			slog.Debug("Skipping journal record", "err", err)
+			return nil
+		}
+		return handleLine(ctx, message)
+	})
+}
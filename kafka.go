@@ -0,0 +1,72 @@
+package nginxviz
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os/exec"
+	"time"
+)
+
+// kafkaConsumers are the names kcat is installed under, newest first.
+var kafkaConsumers = []string{"kcat", "kafkacat"}
+
+// kafkaConsumer returns the path of the installed kcat.
+func kafkaConsumer() (string, error) {
+	var err error
+	for _, name := range kafkaConsumers {
+		var path string
+		if path, err = exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", err
+}
+
+// kafkaArgs returns the kcat arguments that print the value of every
+// message of the topic on a line of its own. In a consumer group the
+// committed offsets say where to start, and -from-end only applies to a
+// group without any; otherwise the topic is read from its beginning unless
+// -from-end is set.
+func (s *Server) kafkaArgs() []string {
+	offset := "beginning"
+	reset := "earliest"
+	if s.cfg.FromEnd {
+		offset, reset = "end", "latest"
+	}
+
+	args := []string{"-b", s.cfg.KafkaBrokers, "-q", "-u", "-f", `%s\n`}
+	if s.cfg.KafkaGroup != "" {
+		return append(args, "-X", "auto.offset.reset="+reset, "-G", s.cfg.KafkaGroup, s.cfg.KafkaTopic)
+	}
+	return append(args, "-C", "-t", s.cfg.KafkaTopic, "-o", offset)
+}
+
+// readKafka passes the value of each message of the topic to the access log
+// handler until ctx is cancelled. Values are raw log lines or JSON, parsed
+// with the configured format. kcat is restarted after a pause if it exits.
+func (s *Server) readKafka(ctx context.Context) {
+	consumer, err := kafkaConsumer()
+	if err != nil {
+		slog.Error("Cannot consume Kafka topic", "err", err)
+		return
+	}
+
+	args := s.kafkaArgs()
+	for {
+		slog.Info("Consuming Kafka topic", "brokers", s.cfg.KafkaBrokers, "topic", s.cfg.KafkaTopic, "group", s.cfg.KafkaGroup)
+		err := followCommand(ctx, consumer, args, s.handleAccessLogLine)
+		if ctx.Err() != nil {
+			slog.Info("Stopped consuming Kafka topic", "topic", s.cfg.KafkaTopic)
+			return
+		}
+		if errors.Is(err, exec.ErrNotFound) {
+			slog.Error("Cannot consume Kafka topic", "err", err)
+			return
+		}
+		slog.Error("Error consuming Kafka topic", "topic", s.cfg.KafkaTopic, "err", err)
+		if sleepContext(ctx, 2*time.Second) != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package nginxviz
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	kubeServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	kubeRescanInterval    = 10 * time.Second
+)
+
+// kubeClient talks to the Kubernetes API, either from inside the cluster
+// with the pod's service account or through a URL such as kubectl proxy's.
+type kubeClient struct {
+	base      string
+	tokenFile string // empty when the API needs no token
+	http      *http.Client
+}
+
+// newKubeClient returns a client for api, or for the in-cluster API server
+// when api is empty.
+func newKubeClient(api string) (*kubeClient, error) {
+	if api != "" {
+		return &kubeClient{base: strings.TrimSuffix(api, "/"), http: &http.Client{}}, nil
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster, set the API URL")
+	}
+	ca, err := os.ReadFile(kubeServiceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("reading service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in service account CA")
+	}
+	return &kubeClient{
+		base:      "https://" + net.JoinHostPort(host, port),
+		tokenFile: kubeServiceAccountDir + "/token",
+		http: &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}},
+	}, nil
+}
+
+// kubeNamespace returns namespace, or the service account's own namespace
+// when it is empty.
+func kubeNamespace(namespace string) string {
+	if namespace != "" {
+		return namespace
+	}
+	if data, err := os.ReadFile(kubeServiceAccountDir + "/namespace"); err == nil {
+		return strings.TrimSpace(string(data))
+	}
+	return "default"
+}
+
+func (c *kubeClient) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	// Service account tokens are rotated, so the file is read every time
+	if c.tokenFile != "" {
+		token, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading service account token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var status struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&status)
+		return nil, fmt.Errorf("kubernetes API %s: %s %s", path, resp.Status, status.Message)
+	}
+	return resp, nil
+}
+
+// runningPods returns the names of the running pods matching selector.
+func (c *kubeClient) runningPods(ctx context.Context, namespace, selector string) ([]string, error) {
+	resp, err := c.get(ctx, "/api/v1/namespaces/"+url.PathEscape(namespace)+"/pods", url.Values{"labelSelector": {selector}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Status struct {
+				Phase string `json:"phase"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("decoding pod list: %w", err)
+	}
+
+	var pods []string
+	for _, pod := range list.Items {
+		if pod.Status.Phase == "Running" {
+			pods = append(pods, pod.Metadata.Name)
+		}
+	}
+	return pods, nil
+}
+
+// kubeLogQuery returns the pod log parameters. Pods found on startup start
+// where -from-end and -backfill say a log file would start, pods found later
+// are read whole, and streams that are reopened resume at since.
+func (s *Server) kubeLogQuery(initial bool, since time.Time) url.Values {
+	query := url.Values{"follow": {"true"}}
+	if s.cfg.KubeContainer != "" {
+		query.Set("container", s.cfg.KubeContainer)
+	}
+	switch {
+	case !since.IsZero():
+		query.Set("sinceTime", since.UTC().Format(time.RFC3339))
+	case !initial:
+	case s.cfg.FromEnd:
+		query.Set("tailLines", "0")
+	case s.cfg.Backfill > 0:
+		query.Set("tailLines", strconv.Itoa(s.cfg.Backfill))
+	case s.cfg.BackfillSince > 0:
+		query.Set("sinceSeconds", strconv.Itoa(int(s.cfg.BackfillSince.Seconds())))
+	}
+	return query
+}
+
+// watchKubePods follows the logs of every running pod matching the
+// -kube-selector. The pod list is refreshed every kubeRescanInterval: new
+// pods get a stream of their own and the streams of pods that are gone are
+// stopped. Entries are tagged with their pod in Extra["pod"].
+func (s *Server) watchKubePods(ctx context.Context) {
+	client, err := newKubeClient(s.cfg.KubeAPI)
+	if err != nil {
+		slog.Error("Cannot read pod logs", "err", err)
+		return
+	}
+	namespace := kubeNamespace(s.cfg.KubeNamespace)
+	selector := s.cfg.KubeSelector
+
+	ticker := time.NewTicker(kubeRescanInterval)
+	defer ticker.Stop()
+
+	followed := make(map[string]context.CancelFunc)
+	initial := true
+	for {
+		pods, err := client.runningPods(ctx, namespace, selector)
+		if err != nil {
+			slog.Error("Error listing pods", "namespace", namespace, "selector", selector, "err", err)
+		} else {
+			if len(pods) == 0 && len(followed) == 0 {
+				slog.Info("No running pods match selector, waiting...", "namespace", namespace, "selector", selector)
+			}
+
+			current := make(map[string]bool, len(pods))
+			for _, pod := range pods {
+				current[pod] = true
+				if followed[pod] != nil {
+					continue
+				}
+				slog.Info("Pod matched selector", "pod", pod, "namespace", namespace, "selector", selector)
+				podCtx, stop := context.WithCancel(ctx)
+				followed[pod] = stop
+				go s.readPodLogs(podCtx, client, namespace, pod, initial)
+			}
+			initial = false
+
+			for pod, stop := range followed {
+				if !current[pod] {
+					slog.Info("Pod no longer running", "pod", pod, "namespace", namespace)
+					stop()
+					delete(followed, pod)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// readPodLogs follows the log of one pod until ctx is cancelled, reopening
+// the stream after a pause when it ends.
+func (s *Server) readPodLogs(ctx context.Context, client *kubeClient, namespace, pod string, initial bool) {
+	path := "/api/v1/namespaces/" + url.PathEscape(namespace) + "/pods/" + url.PathEscape(pod) + "/log"
+	handle := s.podLineHandler(pod)
+
+	var since time.Time
+	for {
+		err := func() error {
+			resp, err := client.get(ctx, path, s.kubeLogQuery(initial, since))
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			return forEachLine(ctx, resp.Body, handle)
+		}()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Error("Error reading pod logs", "pod", pod, "err", err)
+		}
+		since = time.Now()
+		if sleepContext(ctx, 2*time.Second) != nil {
+			return
+		}
+	}
+}
+
+// podLineHandler handles the lines of a pod's log, which carries both of
+// nginx's logs: error log lines go to the error log handler, and access log
+// entries are tagged with the pod they came from.
+func (s *Server) podLineHandler(pod string) lineHandler {
+	return func(ctx context.Context, line string) error {
+		if errorLogRegex.MatchString(strings.TrimSpace(line)) {
+			return s.handleErrorLogLine(ctx, line)
+		}
+
+		logEntry, ok := s.processLogLine(line)
+		if !ok {
+			return nil
+		}
+		if logEntry.Extra == nil {
+			logEntry.Extra = make(map[string]string)
+		}
+		logEntry.Extra["pod"] = pod
+		return s.submitEntry(ctx, logEntry)
+	}
+}
@@ -0,0 +1,52 @@
+package nginxviz
+
+import (
+	"sync"
+	"time"
+)
+
+type lagUpdate struct {
+	Type       string `json:"type"`
+	LagSeconds int64  `json:"lag_seconds"`
+}
+
+// LagGauge tracks how far behind the log the watcher is, measured as the
+// time between nginx serving a request and the entry being parsed. It
+// reports when the lag first exceeds the threshold and when it recovers.
+type LagGauge struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	lag       time.Duration
+	alerting  bool
+}
+
+func NewLagGauge(threshold time.Duration) *LagGauge {
+	return &LagGauge{threshold: threshold}
+}
+
+// Observe records the lag of entry and returns an update when it crossed
+// the threshold in either direction.
+func (g *LagGauge) Observe(entry LogEntry, now time.Time) (lagUpdate, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.lag = now.Sub(entry.Timestamp)
+	if g.threshold <= 0 {
+		return lagUpdate{}, false
+	}
+
+	exceeded := g.lag > g.threshold
+	if exceeded == g.alerting {
+		return lagUpdate{}, false
+	}
+	g.alerting = exceeded
+	return lagUpdate{Type: "lag_update", LagSeconds: int64(g.lag / time.Second)}, true
+}
+
+// SetThreshold changes the alert threshold of a running gauge.
+func (g *LagGauge) SetThreshold(threshold time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.threshold = threshold
+}
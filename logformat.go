@@ -0,0 +1,188 @@
+package nginxviz
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// logFormatParser parses lines written with a custom nginx log_format,
+// e.g. `$remote_addr - $remote_user [$time_local] "$request" $status`.
+// Each variable becomes a capture group ending at the literal character
+// that follows it in the format.
+type logFormatParser struct {
+	regex    *regexp.Regexp
+	vars     []string // variable name of each capture group
+	trustXFF bool
+}
+
+// isLogFormat reports whether a -format value is a log_format definition
+// rather than the name of a built-in format.
+func isLogFormat(format string) bool {
+	return strings.Contains(format, "$")
+}
+
+func newLogFormatParser(format string, trustXFF bool) (*logFormatParser, error) {
+	format = unquoteLogFormat(format)
+
+	var pattern strings.Builder
+	var vars []string
+	pattern.WriteString("^")
+	for i := 0; i < len(format); {
+		if format[i] != '$' {
+			pattern.WriteString(regexp.QuoteMeta(format[i : i+1]))
+			i++
+			continue
+		}
+
+		name, n := logFormatVariable(format[i+1:])
+		if name == "" {
+			return nil, fmt.Errorf("invalid variable at offset %d of log format", i)
+		}
+		i += 1 + n
+		vars = append(vars, name)
+
+		switch {
+		case i == len(format):
+			pattern.WriteString("(.*)")
+		case format[i] == '$':
+			return nil, fmt.Errorf("variables $%s and the one after it need a separator", name)
+		default:
+			pattern.WriteString("([^" + regexp.QuoteMeta(format[i:i+1]) + "]*)")
+		}
+	}
+	pattern.WriteString(`\s*$`)
+
+	hasAddr := false
+	for _, name := range vars {
+		hasAddr = hasAddr || name == "remote_addr"
+	}
+	if !hasAddr {
+		return nil, fmt.Errorf("log format must include $remote_addr")
+	}
+
+	regex, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, fmt.Errorf("compiling log format: %w", err)
+	}
+	return &logFormatParser{regex: regex, vars: vars, trustXFF: trustXFF}, nil
+}
+
+// unquoteLogFormat joins the single quoted strings of a log_format directive
+// as copied from nginx.conf, such as `'$remote_addr - ' '"$request"'`.
+// Formats that are not quoted are returned unchanged.
+func unquoteLogFormat(format string) string {
+	format = strings.TrimSpace(format)
+	if !strings.HasPrefix(format, "'") {
+		return format
+	}
+
+	// Splitting on quotes leaves the quoted strings at odd indices and the
+	// whitespace between them at even ones.
+	var b strings.Builder
+	for i, part := range strings.Split(format, "'") {
+		if i%2 == 1 {
+			b.WriteString(part)
+		}
+	}
+	return b.String()
+}
+
+// logFormatVariable returns the name of the variable at the start of s,
+// written as name or {name}, and the number of bytes it occupies.
+func logFormatVariable(s string) (string, int) {
+	if strings.HasPrefix(s, "{") {
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return "", 0
+		}
+		return s[1:end], end + 1
+	}
+
+	n := 0
+	for n < len(s) && (s[n] == '_' || s[n] >= 'a' && s[n] <= 'z' || s[n] >= 'A' && s[n] <= 'Z' || s[n] >= '0' && s[n] <= '9') {
+		n++
+	}
+	return s[:n], n
+}
+
+func (p *logFormatParser) Parse(line string) (LogEntry, error) {
+	matches := p.regex.FindStringSubmatch(line)
+	if matches == nil {
+		return LogEntry{}, fmt.Errorf("failed to parse log line: %s", line)
+	}
+
+	var entry LogEntry
+	var xff string
+	for i, name := range p.vars {
+		value := matches[i+1]
+		if value == "-" {
+			value = ""
+		}
+
+		switch name {
+		case "remote_addr":
+			entry.IP = value
+		case "time_local":
+			entry.Timestamp, _ = time.Parse(nginxTimeLayout, value)
+		case "time_iso8601":
+			entry.Timestamp, _ = time.Parse(time.RFC3339, value)
+		case "request":
+			if parts := strings.Fields(value); len(parts) >= 2 {
+				entry.Method, entry.URL = parts[0], parts[1]
+				if len(parts) >= 3 {
+					entry.Protocol = parts[2]
+				}
+			}
+		case "request_method":
+			entry.Method = value
+		case "request_uri", "uri":
+			entry.URL = value
+		case "server_protocol":
+			entry.Protocol = value
+		case "ssl_protocol":
+			entry.TLSProtocol = value
+		case "ssl_cipher":
+			entry.TLSCipher = value
+		case "status":
+			entry.StatusCode, _ = strconv.Atoi(value)
+		case "body_bytes_sent", "bytes_sent":
+			entry.Size, _ = strconv.Atoi(value)
+		case "http_user_agent":
+			entry.UserAgent = value
+		case "http_referer":
+			entry.Referer = value
+		case "http_x_forwarded_for":
+			xff = value
+		case "request_id":
+			entry.RequestID = value
+		case "http_cookie":
+			entry.CookieHeader = value
+		case "sent_http_content_type":
+			entry.ResponseContentType = value
+		case "limit_req_status":
+			entry.RateLimitStatus = value
+		case "request_time":
+			entry.RequestTime = parseResponseTime(value)
+		case "upstream_response_time":
+			entry.UpstreamTime = parseResponseTime(value)
+		case "upstream_addr":
+			entry.Upstream = value
+		case "host", "server_name", "http_host":
+			entry.Host = value
+		}
+	}
+
+	if entry.IP == "" {
+		return LogEntry{}, fmt.Errorf("failed to parse log line, empty $remote_addr: %s", line)
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	if p.trustXFF && xff != "" {
+		applyForwardedFor(&entry, xff)
+	}
+	return entry, nil
+}
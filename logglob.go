@@ -0,0 +1,102 @@
+package nginxviz
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const globRescanInterval = 10 * time.Second
+
+// isLogGlob reports whether a log file path is a glob pattern such as
+// /var/log/nginx/*.access.log rather than a single file.
+func isLogGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// watchLogPath follows a single log file, every file matching a glob,
+// standard input for "-", the systemd journal of a unit for
+// "journald:unit", a file on another host for "ssh://host:path" or a named
+// pipe. Files are followed by copies of tailer in s.tailers, with their
+// Path set.
+func (s *Server) watchLogPath(ctx context.Context, path string, tailer Tailer) {
+	switch {
+	case path == stdinLogFile:
+		s.readLogStream(ctx, "stdin", os.Stdin, tailer.HandleLine)
+	case strings.HasPrefix(path, journalPrefix):
+		unit, _ := journalUnit(path)
+		s.readJournal(ctx, unit, tailer.HandleLine)
+	case strings.HasPrefix(path, sshPrefix):
+		target, _, _ := parseSSHLogFile(path)
+		s.readSSH(ctx, target, tailer.HandleLine)
+	case isLogGlob(path):
+		s.watchLogGlob(ctx, path, tailer)
+	case isFIFO(path):
+		s.readFIFO(ctx, path, tailer.HandleLine)
+	default:
+		tailer.Path = path
+		s.tailers.Start(ctx, &tailer)
+	}
+}
+
+// watchLogGlob follows every file matching pattern. The pattern is
+// rescanned every globRescanInterval: newly matching files get a tailer of
+// their own, and the tailers of files that no longer exist are stopped.
+// Only the files found by the first scan use tailer.Seek; files that appear
+// later are new and read whole.
+func (s *Server) watchLogGlob(ctx context.Context, pattern string, tailer Tailer) {
+	followed := make(map[string]bool)
+
+	ticker := time.NewTicker(globRescanInterval)
+	defer ticker.Stop()
+
+	for {
+		// The pattern is validated in New, so Glob cannot fail here
+		matches, _ := filepath.Glob(pattern)
+		if len(matches) == 0 && len(followed) == 0 {
+			slog.Info("No log files match pattern, waiting...", "pattern", pattern)
+		}
+
+		current := make(map[string]bool, len(matches))
+		for _, file := range matches {
+			current[file] = true
+			if followed[file] {
+				continue
+			}
+			followed[file] = true
+			slog.Info("Log file matched pattern", "file", file, "pattern", pattern)
+			fileTailer := tailer
+			fileTailer.Path = file
+			s.tailers.Start(ctx, &fileTailer)
+		}
+		tailer.Seek = nil
+
+		for file := range followed {
+			if !current[file] {
+				slog.Info("Log file no longer matches pattern", "file", file, "pattern", pattern)
+				s.tailers.Stop(file)
+				delete(followed, file)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// firstLogFile returns path, or the first file matching it if it is a glob.
+func firstLogFile(path string) string {
+	if !isLogGlob(path) {
+		return path
+	}
+	if matches, _ := filepath.Glob(path); len(matches) > 0 {
+		return matches[0]
+	}
+	return path
+}
@@ -0,0 +1,198 @@
+package nginxviz
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// msgpackMaxLen caps the length of a single string, binary or container
+// read from the network.
+const msgpackMaxLen = 64 << 20
+
+// msgpackExt is a MessagePack extension value, such as Fluentd's EventTime.
+type msgpackExt struct {
+	Type int8
+	Data []byte
+}
+
+// decodeMsgpack reads one MessagePack value. Integers decode to int64 or
+// uint64, strings to string, binaries to []byte, arrays to []any and maps to
+// map[string]any, with non-string keys formatted as strings.
+func decodeMsgpack(r *bufio.Reader) (any, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b >= 0x80 && b <= 0x8f:
+		return decodeMsgpackMap(r, int(b&0x0f))
+	case b >= 0x90 && b <= 0x9f:
+		return decodeMsgpackArray(r, int(b&0x0f))
+	case b >= 0xa0 && b <= 0xbf:
+		return readMsgpackString(r, int(b&0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := readMsgpackLen(r, 1<<(b-0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackBytes(r, n)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := readMsgpackLen(r, 1<<(b-0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackExt(r, n)
+	case 0xca:
+		v, err := readMsgpackUint(r, 4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := readMsgpackUint(r, 8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce:
+		v, err := readMsgpackUint(r, 1<<(b-0xcc))
+		return int64(v), err
+	case 0xcf:
+		return readMsgpackUint(r, 8)
+	case 0xd0:
+		v, err := readMsgpackUint(r, 1)
+		return int64(int8(v)), err
+	case 0xd1:
+		v, err := readMsgpackUint(r, 2)
+		return int64(int16(v)), err
+	case 0xd2:
+		v, err := readMsgpackUint(r, 4)
+		return int64(int32(v)), err
+	case 0xd3:
+		v, err := readMsgpackUint(r, 8)
+		return int64(v), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return readMsgpackExt(r, 1<<(b-0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := readMsgpackLen(r, 1<<(b-0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackString(r, n)
+	case 0xdc, 0xdd:
+		n, err := readMsgpackLen(r, 2<<(b-0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackArray(r, n)
+	case 0xde, 0xdf:
+		n, err := readMsgpackLen(r, 2<<(b-0xde))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackMap(r, n)
+	}
+	return nil, fmt.Errorf("msgpack: invalid type byte 0x%02x", b)
+}
+
+func readMsgpackUint(r io.Reader, size int) (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[8-size:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+func readMsgpackLen(r io.Reader, size int) (int, error) {
+	n, err := readMsgpackUint(r, size)
+	if err != nil {
+		return 0, err
+	}
+	if n > msgpackMaxLen {
+		return 0, fmt.Errorf("msgpack: length %d exceeds limit", n)
+	}
+	return int(n), nil
+}
+
+func readMsgpackBytes(r io.Reader, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r, buf)
+	return buf, err
+}
+
+func readMsgpackString(r io.Reader, n int) (string, error) {
+	buf, err := readMsgpackBytes(r, n)
+	return string(buf), err
+}
+
+func readMsgpackExt(r io.Reader, n int) (msgpackExt, error) {
+	var t [1]byte
+	if _, err := io.ReadFull(r, t[:]); err != nil {
+		return msgpackExt{}, err
+	}
+	data, err := readMsgpackBytes(r, n)
+	return msgpackExt{Type: int8(t[0]), Data: data}, err
+}
+
+func decodeMsgpackArray(r *bufio.Reader, n int) ([]any, error) {
+	items := make([]any, 0, min(n, 1024))
+	for range n {
+		item, err := decodeMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func decodeMsgpackMap(r *bufio.Reader, n int) (map[string]any, error) {
+	m := make(map[string]any, min(n, 1024))
+	for range n {
+		key, err := decodeMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := decodeMsgpack(r)
+		if err != nil {
+			return nil, err
+		}
+		switch k := key.(type) {
+		case string:
+			m[k] = value
+		case []byte:
+			m[string(k)] = value
+		default:
+			m[fmt.Sprint(k)] = value
+		}
+	}
+	return m, nil
+}
+
+// appendMsgpackString appends s encoded as a MessagePack string.
+func appendMsgpackString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n < 1<<8:
+		b = append(b, 0xd9, byte(n))
+	case n < 1<<16:
+		b = append(b, 0xda)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, 0xdb)
+		b = binary.BigEndian.AppendUint32(b, uint32(n))
+	}
+	return append(b, s...)
+}
@@ -0,0 +1,141 @@
+package nginxviz
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	networksDefaultLimit = 20
+	// networksMaxTracked bounds the networks counted, which an ASN database
+	// lists tens of thousands of; the first ones seen are kept.
+	networksMaxTracked = 10000
+)
+
+// hostingASNs are the autonomous systems of the large cloud and hosting
+// providers. Traffic from them is scanners, crawlers and monitoring far more
+// often than people.
+var hostingASNs = map[uint]bool{
+	16509:  true, // Amazon
+	14618:  true, // Amazon
+	15169:  true, // Google
+	396982: true, // Google Cloud
+	8075:   true, // Microsoft
+	14061:  true, // DigitalOcean
+	16276:  true, // OVH
+	24940:  true, // Hetzner
+	63949:  true, // Linode (Akamai)
+	20473:  true, // Vultr
+	45102:  true, // Alibaba Cloud
+	132203: true, // Tencent Cloud
+	31898:  true, // Oracle Cloud
+	51167:  true, // Contabo
+	12876:  true, // Scaleway
+	13335:  true, // Cloudflare
+}
+
+// isHostingASN reports whether asn belongs to a cloud or hosting provider.
+func isHostingASN(asn uint) bool {
+	return hostingASNs[asn]
+}
+
+type networkCount struct {
+	ASN      uint   `json:"asn"`
+	ASOrg    string `json:"as_org"`
+	Hosting  bool   `json:"hosting"`
+	Requests int    `json:"requests"`
+	Bytes    int64  `json:"bytes"`
+}
+
+type networkSnapshot struct {
+	Networks []networkCount `json:"networks"`
+	Hosting  int            `json:"hosting"` // requests from hosting providers
+	Other    int            `json:"other"`   // requests from any other network
+	Unknown  int            `json:"unknown"` // requests the ASN database has no network for
+}
+
+// networkBreakdown counts requests and bytes per autonomous system. Without
+// an ASN database every request is unknown.
+type networkBreakdown struct {
+	mu       sync.Mutex
+	networks map[uint]*networkCount
+	hosting  int
+	other    int
+	unknown  int
+}
+
+func newNetworkBreakdown() *networkBreakdown {
+	return &networkBreakdown{networks: make(map[uint]*networkCount)}
+}
+
+func (b *networkBreakdown) Observe(entry LogEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case entry.ASN == 0:
+		b.unknown++
+		return
+	case entry.Hosting:
+		b.hosting++
+	default:
+		b.other++
+	}
+
+	network, ok := b.networks[entry.ASN]
+	if !ok {
+		if len(b.networks) >= networksMaxTracked {
+			return
+		}
+		network = &networkCount{ASN: entry.ASN, ASOrg: entry.ASOrg, Hosting: entry.Hosting}
+		b.networks[entry.ASN] = network
+	}
+	network.Requests++
+	network.Bytes += int64(entry.Size)
+}
+
+// Snapshot returns the n networks with the most requests.
+func (b *networkBreakdown) Snapshot(n int) networkSnapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	snapshot := networkSnapshot{
+		Networks: make([]networkCount, 0, len(b.networks)),
+		Hosting:  b.hosting,
+		Other:    b.other,
+		Unknown:  b.unknown,
+	}
+	for _, network := range b.networks {
+		snapshot.Networks = append(snapshot.Networks, *network)
+	}
+	sort.Slice(snapshot.Networks, func(i, j int) bool {
+		a, b := snapshot.Networks[i], snapshot.Networks[j]
+		if a.Requests != b.Requests {
+			return a.Requests > b.Requests
+		}
+		return a.ASN < b.ASN
+	})
+	if len(snapshot.Networks) > n {
+		snapshot.Networks = snapshot.Networks[:n]
+	}
+	return snapshot
+}
+
+// MakeNetworksHandler returns the networks with the most requests, ?n of
+// them, along with how many requests came from hosting providers.
+func MakeNetworksHandler(networks *networkBreakdown) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := networksDefaultLimit
+		if param := r.URL.Query().Get("n"); param != "" {
+			var err error
+			n, err = strconv.Atoi(param)
+			if err != nil || n < 0 {
+				returnError(w, http.StatusBadRequest, "n must be a non-negative integer")
+				return
+			}
+		}
+		writeJSON(w, networks.Snapshot(n))
+	}
+}
@@ -0,0 +1,59 @@
+package nginxviz
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// URL normalization modes for Config.NormalizeURLs. Each one includes the
+// ones before it.
+const (
+	normalizeSplit    = "split"    // percent-decode the path and split off the query string
+	normalizeCollapse = "collapse" // also replace numeric and UUID segments with :id
+	normalizeStrip    = "strip"    // also drop numeric and UUID segments
+)
+
+func validNormalizeMode(mode string) error {
+	switch mode {
+	case "", normalizeSplit, normalizeCollapse, normalizeStrip:
+		return nil
+	}
+	return fmt.Errorf("invalid URL normalization %q: must be split, collapse or strip", mode)
+}
+
+// normalizeURL fills in the entry's Path, Query and NormalizedURL. The query
+// string is kept as logged, since decoding it would make escaped & and =
+// indistinguishable from separators.
+func normalizeURL(entry *LogEntry, mode string) {
+	if mode == "" || entry.URL == "" {
+		return
+	}
+
+	path, query, _ := strings.Cut(entry.URL, "?")
+	if decoded, err := url.PathUnescape(path); err == nil {
+		path = decoded
+	}
+	entry.Path, entry.Query = path, query
+
+	if mode == normalizeSplit {
+		entry.NormalizedURL = path
+		return
+	}
+
+	segments := strings.Split(path, "/")
+	kept := segments[:0]
+	for _, segment := range segments {
+		if isVariableSegment(segment) {
+			if mode == normalizeStrip {
+				continue
+			}
+			segment = ":id"
+		}
+		kept = append(kept, segment)
+	}
+	entry.NormalizedURL = strings.Join(kept, "/")
+	if entry.NormalizedURL == "" {
+		entry.NormalizedURL = "/"
+	}
+}
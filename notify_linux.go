@@ -0,0 +1,78 @@
+package nginxviz
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileNotifier signals when a file is written to, created, renamed or
+// removed, using inotify on the file's directory so the replacement of a
+// rotated file is seen as well.
+type fileNotifier struct {
+	inotify *os.File
+	name    string
+	events  chan struct{}
+}
+
+func newFileNotifier(path string) (*fileNotifier, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify init: %w", err)
+	}
+	// A non-blocking descriptor goes through the runtime poller, so Close
+	// interrupts a pending Read
+	inotify := os.NewFile(uintptr(fd), "inotify")
+
+	const mask = unix.IN_MODIFY | unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_ATTRIB
+	if _, err := unix.InotifyAddWatch(fd, filepath.Dir(path), mask); err != nil {
+		inotify.Close()
+		return nil, fmt.Errorf("inotify watch: %w", err)
+	}
+
+	n := &fileNotifier{
+		inotify: inotify,
+		name:    filepath.Base(path),
+		events:  make(chan struct{}, 1),
+	}
+	go n.read()
+	return n, nil
+}
+
+// Events delivers a value after changes to the file. Changes made while the
+// previous value has not been received are merged into it.
+func (n *fileNotifier) Events() <-chan struct{} {
+	return n.events
+}
+
+func (n *fileNotifier) Close() error {
+	return n.inotify.Close()
+}
+
+func (n *fileNotifier) read() {
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		size, err := n.inotify.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= size; {
+			nameLen := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+			name := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+nameLen]
+			offset += unix.SizeofInotifyEvent + nameLen
+
+			if strings.TrimRight(string(name), "\x00") != n.name {
+				continue
+			}
+			select {
+			case n.events <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package nginxviz
+
+import "errors"
+
+// fileNotifier is only implemented on Linux; elsewhere log files are polled.
+type fileNotifier struct{}
+
+func newFileNotifier(path string) (*fileNotifier, error) {
+	return nil, errors.New("file notifications are not supported on this platform")
+}
+
+func (n *fileNotifier) Events() <-chan struct{} {
+	return nil
+}
+
+func (n *fileNotifier) Close() error {
+	return nil
+}
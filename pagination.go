@@ -0,0 +1,115 @@
+package nginxviz
+
+import (
+	"cmp"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+)
+
+// recentSorts compare two entries for the sort orders accepted by
+// /api/recent. Ties keep the newest entry first.
+var recentSorts = map[string]func(a, b sequencedEntry) int{
+	"timestamp_desc": func(a, b sequencedEntry) int { return b.Entry.Timestamp.Compare(a.Entry.Timestamp) },
+	"timestamp_asc":  func(a, b sequencedEntry) int { return a.Entry.Timestamp.Compare(b.Entry.Timestamp) },
+	"status_code_desc": func(a, b sequencedEntry) int {
+		return cmp.Compare(b.Entry.StatusCode, a.Entry.StatusCode)
+	},
+	"size_desc": func(a, b sequencedEntry) int { return cmp.Compare(b.Entry.Size, a.Entry.Size) },
+}
+
+// recentPageRequest is a page of /api/recent. Cursor pins the listing to the
+// entries that existed when the first page was served, so entries arriving
+// while a client pages through the history do not shift later pages.
+type recentPageRequest struct {
+	Page    int
+	PerPage int
+	Sort    string
+	Cursor  uint64 // sequence number of the newest entry listed, 0 for the latest
+}
+
+type recentPage struct {
+	Total   int    `json:"total"`
+	Page    int    `json:"page"`
+	PerPage int    `json:"per_page"`
+	Sort    string `json:"sort"`
+	Cursor  string `json:"cursor"`
+	Entries any    `json:"entries"`
+}
+
+// isPaginated reports whether a /api/recent request asks for the paginated
+// response rather than the plain array of the last n entries.
+func isPaginated(query url.Values) bool {
+	return query.Has("page") || query.Has("sort") || query.Has("cursor")
+}
+
+func parseRecentPageRequest(query url.Values, perPage int) (recentPageRequest, error) {
+	req := recentPageRequest{Page: 1, PerPage: perPage, Sort: "timestamp_desc"}
+
+	if param := query.Get("page"); param != "" {
+		page, err := strconv.Atoi(param)
+		if err != nil || page < 1 {
+			return req, fmt.Errorf("page must be a positive integer")
+		}
+		req.Page = page
+	}
+
+	if param := query.Get("sort"); param != "" {
+		if _, ok := recentSorts[param]; !ok {
+			return req, fmt.Errorf("sort must be one of timestamp_desc, timestamp_asc, status_code_desc or size_desc")
+		}
+		req.Sort = param
+	}
+
+	if param := query.Get("cursor"); param != "" {
+		seq, err := decodeCursor(param)
+		if err != nil {
+			return req, fmt.Errorf("invalid cursor")
+		}
+		req.Cursor = seq
+	}
+	return req, nil
+}
+
+// paginate sorts the entries up to the cursor and returns the requested
+// page along with the total number of entries and the cursor to reuse for
+// further pages.
+func paginate(entries []sequencedEntry, req recentPageRequest) ([]LogEntry, int, uint64) {
+	cursor := req.Cursor
+	if cursor == 0 && len(entries) > 0 {
+		cursor = entries[len(entries)-1].Seq
+	}
+
+	listed := make([]sequencedEntry, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Seq <= cursor {
+			listed = append(listed, entries[i])
+		}
+	}
+	slices.SortStableFunc(listed, recentSorts[req.Sort])
+
+	start := len(listed)
+	if req.Page-1 <= len(listed)/max(req.PerPage, 1) {
+		start = min((req.Page-1)*req.PerPage, len(listed))
+	}
+	end := min(start+req.PerPage, len(listed))
+	page := make([]LogEntry, 0, end-start)
+	for _, e := range listed[start:end] {
+		page = append(page, e.Entry)
+	}
+	return page, len(listed), cursor
+}
+
+func encodeCursor(seq uint64) string {
+	return base64.RawURLEncoding.EncodeToString(strconv.AppendUint(nil, seq, 10))
+}
+
+func decodeCursor(cursor string) (uint64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(raw), 10, 64)
+}
@@ -0,0 +1,669 @@
+package nginxviz
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const nginxTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// Log formats accepted by -format
+const (
+	formatCombined = "combined"
+	formatJSON     = "json"
+	formatALB      = "alb"
+)
+
+// Parser turns a single access log line into a LogEntry.
+type Parser interface {
+	Parse(line string) (LogEntry, error)
+}
+
+// ErrSkipLine is returned by parsers for lines that carry no request, such
+// as comments or headers, so they are dropped without counting as errors.
+var ErrSkipLine = errors.New("line holds no request")
+
+// ParserFactory builds a Parser for the log format options in cfg.
+type ParserFactory func(cfg Config) (Parser, error)
+
+var (
+	parsersMu sync.RWMutex
+	parsers   = make(map[string]ParserFactory)
+)
+
+func init() {
+	RegisterParser(formatCombined, newCombinedLogParser)
+	RegisterParser(formatJSON, func(cfg Config) (Parser, error) {
+		parser, err := newJSONLogParserFromConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return parser, nil
+	})
+	RegisterParser(formatALB, func(Config) (Parser, error) { return albLogParser{}, nil })
+}
+
+// RegisterParser makes a log format selectable by name with -format. It
+// panics if the name is already registered, so it is meant to be called
+// from init functions or before New.
+func RegisterParser(name string, factory ParserFactory) {
+	parsersMu.Lock()
+	defer parsersMu.Unlock()
+
+	if _, dup := parsers[name]; dup {
+		panic("nginxviz: RegisterParser called twice for " + name)
+	}
+	parsers[name] = factory
+}
+
+// Parsers returns the names of the registered log formats, sorted.
+func Parsers() []string {
+	parsersMu.RLock()
+	defer parsersMu.RUnlock()
+
+	names := make([]string, 0, len(parsers))
+	for name := range parsers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// newParser builds the parser selected by cfg.Format, which is either the
+// name of a registered format or an nginx log_format definition.
+func newParser(cfg Config) (Parser, error) {
+	if isLogFormat(cfg.Format) {
+		parser, err := newLogFormatParser(cfg.Format, cfg.TrustXFF)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log format: %w", err)
+		}
+		return parser, nil
+	}
+
+	parsersMu.RLock()
+	factory, ok := parsers[cfg.Format]
+	parsersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+	return factory(cfg)
+}
+
+// combinedLogParser parses the nginx combined format, including the optional
+// columns of extended combined formats.
+type combinedLogParser struct {
+	json              *jsonLogParser
+	trustXFF          bool
+	requestIDField    int // 1-based column of $request_id in extended formats, 0 if not logged
+	cookieField       int // 1-based column of $http_cookie in extended formats, 0 if not logged
+	contentTypeField  int // 1-based column of $sent_http_content_type in extended formats, 0 if not logged
+	rateLimitField    int // 1-based column of $limit_req_status in extended formats, 0 if not logged
+	requestTimeField  int // 1-based column of $request_time in extended formats, 0 if not logged
+	upstreamTimeField int // 1-based column of $upstream_response_time in extended formats, 0 if not logged
+	hostField         int // 1-based column of $host in extended formats, 0 if not logged
+	sslProtocolField  int // 1-based column of $ssl_protocol in extended formats, 0 if not logged
+	sslCipherField    int // 1-based column of $ssl_cipher in extended formats, 0 if not logged
+}
+
+func newCombinedLogParser(cfg Config) (Parser, error) {
+	jsonParser, err := newJSONLogParserFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &combinedLogParser{
+		json:              jsonParser,
+		trustXFF:          cfg.TrustXFF,
+		requestIDField:    cfg.RequestIDField,
+		cookieField:       cfg.CookieField,
+		contentTypeField:  cfg.ContentTypeField,
+		rateLimitField:    cfg.RateLimitField,
+		requestTimeField:  cfg.RequestTimeField,
+		upstreamTimeField: cfg.UpstreamTimeField,
+		hostField:         cfg.HostField,
+		sslProtocolField:  cfg.SSLProtocolField,
+		sslCipherField:    cfg.SSLCipherField,
+	}, nil
+}
+
+// Parse parses a combined format line. Lines that look like JSON objects
+// are handed to the JSON parser, so mixed files are handled line by line.
+func (p *combinedLogParser) Parse(line string) (LogEntry, error) {
+	if strings.HasPrefix(line, "{") {
+		return p.json.Parse(line)
+	}
+	return p.parseNginxLog(line)
+}
+
+// combinedLine holds the fields of a well-formed combined format line as
+// substrings of it. Any fields after the user agent are kept in rest for
+// extended formats such as nginx's default "main" format, which appends
+// "$http_x_forwarded_for".
+//
+// Example: 127.0.0.1 - - [17/Nov/2025:10:30:45 +0000] "GET /api/test HTTP/1.1" 200 1234 "http://example.com" "Mozilla/5.0..."
+type combinedLine struct {
+	ip, time              string
+	method, url, protocol string
+	status, size          string
+	referer, userAgent    string
+	rest                  string
+}
+
+// scanCombinedLine splits a well-formed combined format line without
+// regular expressions or allocations, as this runs for every line on busy
+// servers. It accepts the same lines as
+//
+//	^(\S+) \S+ \S+ \[([^\]]+)\] "(\S+) ([^"]*) ([^"]*)" (\d+) (\d+) "([^"]*)" "([^"]*)"(.*)$
+//
+// apart from lines with raw quotes inside a field, which nginx escapes as
+// \x22, and leaves anything else to parseNginxLogLeniently.
+func scanCombinedLine(line string) (combinedLine, bool) {
+	var c combinedLine
+	var ok bool
+
+	if c.ip, line, ok = cutToken(line); !ok {
+		return c, false
+	}
+	for range 2 { // ident and user
+		if _, line, ok = cutToken(line); !ok {
+			return c, false
+		}
+	}
+	if c.time, line, ok = cutDelimited(line, '[', ']'); !ok || c.time == "" || !strings.HasPrefix(line, " ") {
+		return c, false
+	}
+
+	var request string
+	if request, line, ok = cutDelimited(line[1:], '"', '"'); !ok || !strings.HasPrefix(line, " ") {
+		return c, false
+	}
+	// The URL may contain spaces, so the version is whatever follows the
+	// last one
+	method, target, found := strings.Cut(request, " ")
+	sep := strings.LastIndexByte(target, ' ')
+	if !found || method == "" || containsSpace(method) || sep < 0 {
+		return c, false
+	}
+	c.method, c.url, c.protocol = method, target[:sep], target[sep+1:]
+
+	if c.status, line, ok = cutToken(line[1:]); !ok || !isDigits(c.status) {
+		return c, false
+	}
+	if c.size, line, ok = cutToken(line); !ok || !isDigits(c.size) {
+		return c, false
+	}
+	if c.referer, line, ok = cutDelimited(line, '"', '"'); !ok || !strings.HasPrefix(line, " ") {
+		return c, false
+	}
+	if c.userAgent, line, ok = cutDelimited(line[1:], '"', '"'); !ok {
+		return c, false
+	}
+	c.rest = line
+	return c, true
+}
+
+// cutToken returns the non-empty run of non-space characters at the start of
+// s and what follows the single space after it.
+func cutToken(s string) (token, rest string, ok bool) {
+	end := strings.IndexByte(s, ' ')
+	if end <= 0 || containsSpace(s[:end]) {
+		return "", s, false
+	}
+	return s[:end], s[end+1:], true
+}
+
+// cutDelimited returns the text between an opening delimiter at the start
+// of s and the first closing one, and what follows it.
+func cutDelimited(s string, open, closing byte) (field, rest string, ok bool) {
+	if s == "" || s[0] != open {
+		return "", s, false
+	}
+	end := strings.IndexByte(s[1:], closing)
+	if end < 0 {
+		return "", s, false
+	}
+	return s[1 : end+1], s[end+2:], true
+}
+
+// containsSpace reports whether s contains a character the \s class of a
+// regular expression would match.
+func containsSpace(s string) bool {
+	return strings.ContainsAny(s, " \t\n\f\r")
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *combinedLogParser) parseNginxLog(line string) (LogEntry, error) {
+	fields, ok := scanCombinedLine(line)
+	if !ok {
+		return p.parseNginxLogLeniently(line)
+	}
+
+	// Parse timestamp
+	timestamp, err := time.Parse(nginxTimeLayout, fields.time)
+	if err != nil {
+		// Fallback to current time if parsing fails
+		timestamp = time.Now()
+	}
+
+	// Parse status code
+	statusCode, err := strconv.Atoi(fields.status)
+	if err != nil {
+		statusCode = 0
+	}
+
+	// Parse size
+	size, err := strconv.Atoi(fields.size)
+	if err != nil {
+		size = 0
+	}
+
+	entry := LogEntry{
+		Timestamp:   timestamp,
+		IP:          fields.ip,
+		Method:      fields.method,
+		URL:         fields.url,
+		Protocol:    fields.protocol,
+		StatusCode:  statusCode,
+		Size:        size,
+		Referer:     fields.referer,
+		UserAgent:   fields.userAgent,
+		Country:     "",
+		CountryFull: "",
+	}
+	p.applyExtraFields(&entry, splitLogFields(fields.rest))
+
+	return entry, nil
+}
+
+// parseNginxLogLeniently handles combined format lines the regex rejects:
+// "-" for the body size, a request line without an HTTP version, missing
+// referer and user agent, or a line cut off part way. As long as the client
+// address and timestamp are there, the fields that could be read are
+// returned with zero values for the rest, and each problem is noted in
+// ParseWarnings.
+func (p *combinedLogParser) parseNginxLogLeniently(line string) (LogEntry, error) {
+	fields, truncated := splitCombinedFields(line)
+	if len(fields) < 4 {
+		return LogEntry{}, fmt.Errorf("failed to parse log line: %s", line)
+	}
+	if _, err := netip.ParseAddr(fields[0]); err != nil {
+		return LogEntry{}, fmt.Errorf("failed to parse log line, invalid client address: %s", line)
+	}
+
+	var entry LogEntry
+	var warnings []string
+	warn := func(warning string) {
+		warnings = append(warnings, warning)
+	}
+	field := func(i int) (string, bool) {
+		if i >= len(fields) {
+			return "", false
+		}
+		return fields[i], true
+	}
+
+	entry.IP = fields[0]
+
+	timestamp, err := time.Parse(nginxTimeLayout, fields[3])
+	if err != nil {
+		warn("invalid timestamp")
+		timestamp = time.Now()
+	}
+	entry.Timestamp = timestamp
+
+	if request, ok := field(4); !ok || request == "" || request == "-" {
+		warn("missing request")
+	} else {
+		parts := strings.Fields(request)
+		switch len(parts) {
+		case 1:
+			warn("request without method")
+			entry.URL = parts[0]
+		case 2:
+			warn("request without HTTP version")
+			entry.Method, entry.URL = parts[0], parts[1]
+		default:
+			entry.Method, entry.URL, entry.Protocol = parts[0], parts[1], parts[2]
+		}
+	}
+
+	if status, ok := field(5); !ok {
+		warn("missing status")
+	} else if entry.StatusCode, err = strconv.Atoi(status); err != nil {
+		warn("invalid status")
+	}
+
+	if size, ok := field(6); !ok || size == "-" {
+		warn("missing body size")
+	} else if entry.Size, err = strconv.Atoi(size); err != nil {
+		warn("invalid body size")
+	}
+
+	var ok bool
+	if entry.Referer, ok = field(7); !ok {
+		warn("missing referer")
+	}
+	if entry.UserAgent, ok = field(8); !ok {
+		warn("missing user agent")
+	}
+	if truncated {
+		warn("truncated line")
+	}
+
+	if len(fields) > 9 {
+		p.applyExtraFields(&entry, fields[9:])
+	}
+	entry.ParseWarnings = warnings
+	return entry, nil
+}
+
+// applyExtraFields sets the entry fields logged in the columns after the
+// user agent.
+func (p *combinedLogParser) applyExtraFields(entry *LogEntry, extra []string) {
+	if p.trustXFF && len(extra) > 0 {
+		applyForwardedFor(entry, extra[0])
+	}
+	entry.RequestID = extraField(extra, p.requestIDField)
+	entry.CookieHeader = extraField(extra, p.cookieField)
+	entry.ResponseContentType = extraField(extra, p.contentTypeField)
+	entry.RateLimitStatus = extraField(extra, p.rateLimitField)
+	entry.RequestTime = parseResponseTime(extraField(extra, p.requestTimeField))
+	entry.UpstreamTime = parseResponseTime(extraField(extra, p.upstreamTimeField))
+	entry.Host = extraField(extra, p.hostField)
+	entry.TLSProtocol = extraField(extra, p.sslProtocolField)
+	entry.TLSCipher = extraField(extra, p.sslCipherField)
+}
+
+// splitCombinedFields splits a combined format line like splitLogFields,
+// additionally keeping the bracketed timestamp as one field. It reports
+// whether the line ended inside a quoted or bracketed field.
+func splitCombinedFields(s string) ([]string, bool) {
+	var fields []string
+	for {
+		s = strings.TrimLeft(s, " ")
+		if s == "" {
+			return fields, false
+		}
+
+		if s[0] == '"' || s[0] == '[' {
+			closing := byte('"')
+			if s[0] == '[' {
+				closing = ']'
+			}
+			end := strings.IndexByte(s[1:], closing)
+			if end < 0 {
+				return append(fields, s[1:]), true
+			}
+			fields = append(fields, s[1:end+1])
+			s = s[end+2:]
+			continue
+		}
+
+		end := strings.IndexByte(s, ' ')
+		if end < 0 {
+			return append(fields, s), false
+		}
+		fields = append(fields, s[:end])
+		s = s[end:]
+	}
+}
+
+// splitLogFields splits the space separated fields of an extended log format,
+// removing the quotes around quoted fields.
+func splitLogFields(s string) []string {
+	var fields []string
+	for {
+		s = strings.TrimLeft(s, " ")
+		if s == "" {
+			return fields
+		}
+
+		if s[0] == '"' {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				return append(fields, s[1:])
+			}
+			fields = append(fields, s[1:end+1])
+			s = s[end+2:]
+			continue
+		}
+
+		end := strings.IndexByte(s, ' ')
+		if end < 0 {
+			return append(fields, s)
+		}
+		fields = append(fields, s[:end])
+		s = s[end:]
+	}
+}
+
+// extraField returns the value of a 1-based log column counting the eight
+// combined format fields first, so column 9 is the first extra field.
+func extraField(extra []string, column int) string {
+	i := column - 9
+	if i < 0 || i >= len(extra) || extra[i] == "-" {
+		return ""
+	}
+	return extra[i]
+}
+
+// parseResponseTime parses $request_time or $upstream_response_time in
+// seconds. When several upstreams were tried nginx logs a time for each,
+// separated by commas or colons; these are added up. Missing values are 0.
+func parseResponseTime(value string) float64 {
+	var total float64
+	for _, part := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ':' || r == ' ' }) {
+		if t, err := strconv.ParseFloat(part, 64); err == nil && t > 0 {
+			total += t
+		}
+	}
+	return total
+}
+
+// applyForwardedFor replaces entry.IP with the leftmost public address of an
+// X-Forwarded-For header, skipping the hops isPrivateIP reports, IPv4-mapped
+// or not, and unspecified ones.
+func applyForwardedFor(entry *LogEntry, xff string) {
+	for _, hop := range strings.Split(xff, ",") {
+		ip, err := netip.ParseAddr(strings.TrimSpace(hop))
+		if err != nil {
+			continue
+		}
+		if isPrivateIP(ip) || ip.Unmap().IsUnspecified() {
+			continue
+		}
+		entry.IP = ip.String()
+		return
+	}
+}
+
+// defaultJSONKeys maps LogEntry fields to the keys commonly used in nginx
+// JSON log_format definitions.
+var defaultJSONKeys = map[string]string{
+	"ip":            "remote_addr",
+	"timestamp":     "time_iso8601",
+	"method":        "request_method",
+	"url":           "request_uri",
+	"request":       "request",
+	"status":        "status",
+	"size":          "body_bytes_sent",
+	"user_agent":    "http_user_agent",
+	"referer":       "http_referer",
+	"xff":           "http_x_forwarded_for",
+	"request_id":    "request_id",
+	"cookie":        "http_cookie",
+	"content_type":  "sent_http_content_type",
+	"rate_limit":    "limit_req_status",
+	"request_time":  "request_time",
+	"upstream_time": "upstream_response_time",
+	"upstream":      "upstream_addr",
+	"host":          "host",
+	"protocol":      "server_protocol",
+	"ssl_protocol":  "ssl_protocol",
+	"ssl_cipher":    "ssl_cipher",
+}
+
+// jsonLogParser parses access logs written with a JSON log_format. keys maps
+// LogEntry fields to the JSON keys holding them; any other key ends up in
+// LogEntry.Extra.
+type jsonLogParser struct {
+	keys     map[string]string
+	mapped   map[string]bool // the JSON keys in keys
+	trustXFF bool
+}
+
+func newJSONLogParser(overrides map[string]string, trustXFF bool) (*jsonLogParser, error) {
+	keys := make(map[string]string, len(defaultJSONKeys))
+	for field, key := range defaultJSONKeys {
+		keys[field] = key
+	}
+	for field, key := range overrides {
+		if _, ok := defaultJSONKeys[field]; !ok {
+			return nil, fmt.Errorf("unknown log entry field %q", field)
+		}
+		keys[field] = key
+	}
+
+	mapped := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		mapped[key] = true
+	}
+	return &jsonLogParser{keys: keys, mapped: mapped, trustXFF: trustXFF}, nil
+}
+
+// newJSONLogParserFromConfig applies the -json-keys overrides in cfg.
+func newJSONLogParserFromConfig(cfg Config) (*jsonLogParser, error) {
+	jsonKeys, err := parseKeyMapping(cfg.JSONKeys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid json keys: %w", err)
+	}
+	jsonParser, err := newJSONLogParser(jsonKeys, cfg.TrustXFF)
+	if err != nil {
+		return nil, fmt.Errorf("invalid json keys: %w", err)
+	}
+	return jsonParser, nil
+}
+
+// parseKeyMapping parses a comma separated list of field=key pairs.
+func parseKeyMapping(mapping string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, pair := range strings.Split(mapping, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		field, key, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid key mapping %q, expected field=key", pair)
+		}
+		keys[strings.TrimSpace(field)] = strings.TrimSpace(key)
+	}
+	return keys, nil
+}
+
+func (p *jsonLogParser) Parse(line string) (LogEntry, error) {
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return LogEntry{}, fmt.Errorf("failed to parse JSON log line: %w", err)
+	}
+
+	get := func(field string) string {
+		return jsonString(fields[p.keys[field]])
+	}
+
+	entry := LogEntry{
+		IP:                  get("ip"),
+		Method:              get("method"),
+		URL:                 get("url"),
+		UserAgent:           get("user_agent"),
+		Referer:             get("referer"),
+		RequestID:           get("request_id"),
+		CookieHeader:        get("cookie"),
+		ResponseContentType: get("content_type"),
+		RateLimitStatus:     get("rate_limit"),
+		Protocol:            get("protocol"),
+	}
+	if entry.IP == "" {
+		return LogEntry{}, fmt.Errorf("failed to parse JSON log line, missing %q: %s", p.keys["ip"], line)
+	}
+	if p.trustXFF {
+		applyForwardedFor(&entry, get("xff"))
+	}
+
+	// Fall back to the raw request line when method and uri are not logged
+	parts := strings.Fields(get("request"))
+	if (entry.Method == "" || entry.URL == "") && len(parts) >= 2 {
+		entry.Method, entry.URL = parts[0], parts[1]
+	}
+	if entry.Protocol == "" && len(parts) >= 3 {
+		entry.Protocol = parts[2]
+	}
+
+	entry.Timestamp = parseJSONTimestamp(get("timestamp"))
+	entry.StatusCode, _ = strconv.Atoi(get("status"))
+	entry.Size, _ = strconv.Atoi(get("size"))
+	entry.RequestTime = parseResponseTime(get("request_time"))
+	entry.UpstreamTime = parseResponseTime(get("upstream_time"))
+	entry.Upstream = get("upstream")
+	entry.Host = get("host")
+	entry.TLSProtocol = get("ssl_protocol")
+	entry.TLSCipher = get("ssl_cipher")
+
+	for key, value := range fields {
+		if p.mapped[key] {
+			continue
+		}
+		if entry.Extra == nil {
+			entry.Extra = make(map[string]string)
+		}
+		entry.Extra[key] = jsonString(value)
+	}
+
+	return entry, nil
+}
+
+// parseJSONTimestamp accepts ISO8601 and nginx time_local timestamps,
+// falling back to the current time.
+func parseJSONTimestamp(value string) time.Time {
+	for _, layout := range []string{time.RFC3339Nano, nginxTimeLayout} {
+		if ts, err := time.Parse(layout, value); err == nil {
+			return ts
+		}
+	}
+	return time.Now()
+}
+
+// jsonString converts a decoded JSON value to its string form. nginx emits
+// every variable as a string, but hand written formats may use numbers.
+func jsonString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		if v == "-" {
+			return ""
+		}
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
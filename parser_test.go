@@ -0,0 +1,98 @@
+package nginxviz
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// combinedLogRegex is the expression scanCombinedLine replaced, kept to
+// check the scanner against and to compare their speed.
+var combinedLogRegex = regexp.MustCompile(`^(\S+) \S+ \S+ \[([^\]]+)\] "(\S+) ([^"]*) ([^"]*)" (\d+) (\d+) "([^"]*)" "([^"]*)"(.*)$`)
+
+const benchmarkLogLine = `203.0.113.7 - - [17/Nov/2025:10:30:45 +0000] "GET /api/items?page=2&sort=desc HTTP/1.1" 200 5123 "https://example.com/items" "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36" "198.51.100.1"`
+
+var combinedLines = []string{
+	testLogLine,
+	benchmarkLogLine,
+	`10.0.0.1 - alice [01/Jan/2024:00:00:00 -0500] "POST /login HTTP/2.0" 302 0 "-" "-"`,
+	`10.0.0.1 - - [01/Jan/2024:00:00:00 +0000] "GET /a b c HTTP/1.1" 404 12 "-" "curl/8.0"`,
+	`10.0.0.1 - - [01/Jan/2024:00:00:00 +0000] "GET / HTTP/1.1" 200 - "-" "curl/8.0"`,
+	`10.0.0.1 - - [01/Jan/2024:00:00:00 +0000] "GET /" 200 12 "-" "curl/8.0"`,
+	`10.0.0.1 - - [01/Jan/2024:00:00:00 +0000] "\x16\x03\x01" 400 150 "-" "-"`,
+	`10.0.0.1 - - [01/Jan/2024:00:00:00 +0000] "GET / HTTP/1.1" 200 12`,
+	`10.0.0.1 - - [] "GET / HTTP/1.1" 200 12 "-" "-"`,
+	`10.0.0.1  - - [01/Jan/2024:00:00:00 +0000] "GET / HTTP/1.1" 200 12 "-" "-"`,
+	`10.0.0.1 - - [01/Jan/2024:00:00:00 +0000] "GET / HTTP/1.1" 200 12 "-" "-" 0.005 "upstream:80"`,
+	``,
+}
+
+// checkScannerMatchesRegex fails the test when scanCombinedLine and
+// combinedLogRegex disagree on line. The scanner may reject lines whose
+// method contains a raw quote, which nginx escapes as \x22.
+func checkScannerMatchesRegex(t *testing.T, line string) {
+	t.Helper()
+
+	c, ok := scanCombinedLine(line)
+	m := combinedLogRegex.FindStringSubmatch(line)
+	if !ok {
+		if m != nil && !strings.Contains(m[3], `"`) {
+			t.Errorf("scanner rejects %q, regex accepts it", line)
+		}
+		return
+	}
+	if m == nil {
+		t.Errorf("scanner accepts %q, regex rejects it", line)
+		return
+	}
+	got := []string{c.ip, c.time, c.method, c.url, c.protocol, c.status, c.size, c.referer, c.userAgent, c.rest}
+	for i, want := range m[1:] {
+		if got[i] != want {
+			t.Errorf("field %d of %q: scanner %q, regex %q", i+1, line, got[i], want)
+		}
+	}
+}
+
+func TestScanCombinedLineMatchesRegex(t *testing.T) {
+	for _, line := range combinedLines {
+		checkScannerMatchesRegex(t, line)
+	}
+}
+
+func FuzzScanCombinedLine(f *testing.F) {
+	for _, line := range combinedLines {
+		f.Add(line)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		// Lines never contain a newline, which .* does not match
+		if strings.Contains(line, "\n") {
+			return
+		}
+		checkScannerMatchesRegex(t, line)
+	})
+}
+
+func BenchmarkParseNginxLog(b *testing.B) {
+	b.Run("regex", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			combinedLogRegex.FindStringSubmatch(benchmarkLogLine)
+		}
+	})
+	b.Run("scanner", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			scanCombinedLine(benchmarkLogLine)
+		}
+	})
+	b.Run("parser", func(b *testing.B) {
+		parser, err := newCombinedLogParser(Config{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		for b.Loop() {
+			parser.Parse(benchmarkLogLine)
+		}
+	})
+}
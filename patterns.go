@@ -0,0 +1,162 @@
+package nginxviz
+
+import (
+	"cmp"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	// patternLearningRequests is how many requests are observed before the
+	// learned patterns are considered meaningful.
+	patternLearningRequests = 10000
+	maxLearnedPatterns      = 1000
+	patternExampleURLs      = 3
+)
+
+var uuidSegment = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// LearnedPattern is a group of URL paths sharing a prefix up to their first
+// variable segment.
+type LearnedPattern struct {
+	Pattern     string   `json:"pattern"`
+	Count       int      `json:"count"`
+	ExampleURLs []string `json:"example_urls"`
+}
+
+// patternLearnerState is the part of the learner kept in the position file.
+type patternLearnerState struct {
+	Observed int              `json:"observed"`
+	Patterns []LearnedPattern `json:"patterns"`
+}
+
+// PatternLearner discovers URL patterns such as /api/v1/users/:id from the
+// paths it observes, without any configured normalization rules. Numeric
+// and UUID segments are variable; everything after the first one is folded
+// into a trailing /*.
+type PatternLearner struct {
+	mu       sync.Mutex
+	observed int
+	patterns map[string]*LearnedPattern
+}
+
+func NewPatternLearner() *PatternLearner {
+	return &PatternLearner{patterns: make(map[string]*LearnedPattern)}
+}
+
+// Observe counts the path of rawURL towards its pattern.
+func (l *PatternLearner) Observe(rawURL string) {
+	pattern, ok := urlPattern(rawURL)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.observed++
+	if !ok {
+		return
+	}
+
+	p, found := l.patterns[pattern]
+	if !found {
+		if len(l.patterns) >= maxLearnedPatterns {
+			return
+		}
+		p = &LearnedPattern{Pattern: pattern}
+		l.patterns[pattern] = p
+	}
+	p.Count++
+	if len(p.ExampleURLs) < patternExampleURLs && !slices.Contains(p.ExampleURLs, rawURL) {
+		p.ExampleURLs = append(p.ExampleURLs, rawURL)
+	}
+}
+
+// Patterns returns the learned patterns, most requested first, or nil while
+// fewer than patternLearningRequests requests have been observed.
+func (l *PatternLearner) Patterns() []LearnedPattern {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.observed < patternLearningRequests {
+		return nil
+	}
+	return l.sortedPatterns()
+}
+
+func (l *PatternLearner) sortedPatterns() []LearnedPattern {
+	patterns := make([]LearnedPattern, 0, len(l.patterns))
+	for _, p := range l.patterns {
+		patterns = append(patterns, LearnedPattern{
+			Pattern:     p.Pattern,
+			Count:       p.Count,
+			ExampleURLs: slices.Clone(p.ExampleURLs),
+		})
+	}
+	slices.SortFunc(patterns, func(a, b LearnedPattern) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Pattern, b.Pattern))
+	})
+	return patterns
+}
+
+func (l *PatternLearner) state() patternLearnerState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return patternLearnerState{Observed: l.observed, Patterns: l.sortedPatterns()}
+}
+
+func (l *PatternLearner) restore(state patternLearnerState) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.observed = state.Observed
+	for _, p := range state.Patterns {
+		if len(l.patterns) >= maxLearnedPatterns {
+			break
+		}
+		l.patterns[p.Pattern] = &p
+	}
+}
+
+// urlPattern returns the pattern of rawURL's path, or false if the path has
+// no variable segment.
+func urlPattern(rawURL string) (string, bool) {
+	path, _, _ := strings.Cut(rawURL, "?")
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	for i, segment := range segments {
+		if !isVariableSegment(segment) {
+			continue
+		}
+		pattern := "/" + strings.Join(append(slices.Clone(segments[:i]), ":id"), "/")
+		if i < len(segments)-1 {
+			pattern += "/*"
+		}
+		return pattern, true
+	}
+	return "", false
+}
+
+func isVariableSegment(segment string) bool {
+	if segment == "" {
+		return false
+	}
+	if strings.Trim(segment, "0123456789") == "" {
+		return true
+	}
+	return uuidSegment.MatchString(segment)
+}
+
+// MakeLearnedPatternsHandler returns the URL patterns discovered so far,
+// or an empty list until enough requests have been observed.
+func MakeLearnedPatternsHandler(learner *PatternLearner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		patterns := learner.Patterns()
+		if patterns == nil {
+			patterns = []LearnedPattern{}
+		}
+		writeJSON(w, patterns)
+	}
+}
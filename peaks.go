@@ -0,0 +1,132 @@
+package nginxviz
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const peakWindow = 60 // seconds, one minute for the requests per minute peak
+
+// PeakRecord is the highest value of a traffic rate and the second of log
+// time at which it was reached.
+type PeakRecord struct {
+	Value float64   `json:"value"`
+	At    time.Time `json:"at"`
+}
+
+type peaksSnapshot struct {
+	RPM            *PeakRecord `json:"rpm"`
+	RPS            *PeakRecord `json:"rps"`
+	BytesPerSecond *PeakRecord `json:"bytes_per_second"`
+}
+
+type peakBucket struct {
+	second   int64
+	requests int
+	bytes    int64
+}
+
+// PeakTracker records the all-time highest request and bandwidth rates.
+// Entries are counted in one-second buckets of log time; Run evaluates the
+// completed seconds once a second. Seconds that scroll out of the window
+// between two ticks, which only happens while catching up on a backlog,
+// are not evaluated.
+type PeakTracker struct {
+	mu        sync.Mutex
+	buckets   [peakWindow]peakBucket
+	latest    int64 // newest second observed
+	evaluated int64 // newest second compared against the peaks
+
+	PeakRPM            atomic.Value // PeakRecord
+	PeakRPS            atomic.Value // PeakRecord
+	PeakBytesPerSecond atomic.Value // PeakRecord
+}
+
+// Observe counts entry towards the second it was logged in.
+func (p *PeakTracker) Observe(entry LogEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	second := entry.Timestamp.Unix()
+	if p.latest-second >= peakWindow {
+		return
+	}
+	b := &p.buckets[second%peakWindow]
+	if b.second != second {
+		*b = peakBucket{second: second}
+	}
+	b.requests++
+	b.bytes += int64(entry.Size)
+	p.latest = max(p.latest, second)
+}
+
+// Run updates the peaks every second until ctx is cancelled.
+func (p *PeakTracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.update()
+		}
+	}
+}
+
+// update compares the seconds completed since the last call against the
+// stored peaks. The newest second is still filling up and is left for the
+// next call.
+func (p *PeakTracker) update() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, b := range p.buckets {
+		if b.second <= p.evaluated || b.second >= p.latest {
+			continue
+		}
+
+		at := time.Unix(b.second, 0).UTC()
+		raisePeak(&p.PeakRPS, float64(b.requests), at)
+		raisePeak(&p.PeakBytesPerSecond, float64(b.bytes), at)
+
+		var rpm int
+		for _, other := range p.buckets {
+			if other.second <= b.second && b.second-other.second < peakWindow {
+				rpm += other.requests
+			}
+		}
+		raisePeak(&p.PeakRPM, float64(rpm), at)
+	}
+	p.evaluated = max(p.evaluated, p.latest-1)
+}
+
+// raisePeak stores value in peak if it exceeds the current record. Only the
+// update goroutine writes peaks, so the load and store need not be atomic
+// together.
+func raisePeak(peak *atomic.Value, value float64, at time.Time) {
+	if current, ok := peak.Load().(PeakRecord); ok && current.Value >= value {
+		return
+	}
+	peak.Store(PeakRecord{Value: value, At: at})
+}
+
+func (p *PeakTracker) Snapshot() peaksSnapshot {
+	return peaksSnapshot{
+		RPM:            loadPeak(&p.PeakRPM),
+		RPS:            loadPeak(&p.PeakRPS),
+		BytesPerSecond: loadPeak(&p.PeakBytesPerSecond),
+	}
+}
+
+// loadPeak returns nil until a peak has been recorded.
+func loadPeak(peak *atomic.Value) *PeakRecord {
+	record, ok := peak.Load().(PeakRecord)
+	if !ok {
+		return nil
+	}
+	return &record
+}
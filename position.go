@@ -0,0 +1,222 @@
+package nginxviz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const positionSaveInterval = time.Minute
+
+// positionState is what the position file keeps across restarts.
+type positionState struct {
+	LearnedPatterns patternLearnerState     `json:"learned_patterns"`
+	Files           map[string]filePosition `json:"files,omitempty"`
+	Objects         []string                `json:"objects,omitempty"` // bucket objects already read
+}
+
+// filePosition is how far into a log file has been read. The inode tells
+// whether the file at the same path is still the one the offset refers to.
+type filePosition struct {
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+// fileOffsets tracks the position of every followed log file by path.
+type fileOffsets struct {
+	mu    sync.Mutex
+	files map[string]filePosition
+}
+
+func newFileOffsets(saved map[string]filePosition) *fileOffsets {
+	files := make(map[string]filePosition, len(saved))
+	for path, pos := range saved {
+		files[path] = pos
+	}
+	return &fileOffsets{files: files}
+}
+
+func (o *fileOffsets) set(path string, pos filePosition) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.files[path] = pos
+}
+
+func (o *fileOffsets) get(path string) (filePosition, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	pos, ok := o.files[path]
+	return pos, ok
+}
+
+func (o *fileOffsets) snapshot() map[string]filePosition {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	files := make(map[string]filePosition, len(o.files))
+	for path, pos := range o.files {
+		files[path] = pos
+	}
+	return files
+}
+
+// loadPositionFile reads the saved state. A missing file is not an error.
+func loadPositionFile(path string) (positionState, error) {
+	var state positionState
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+// savePositionFile replaces the file atomically so a crash mid-write never
+// leaves a truncated state behind.
+func savePositionFile(path string, state positionState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *Server) positionState() positionState {
+	state := positionState{
+		LearnedPatterns: s.patterns.state(),
+		Files:           s.offsets.snapshot(),
+	}
+	if s.objects != nil {
+		state.Objects = s.objects.snapshot()
+	}
+	return state
+}
+
+// savePosition writes the position file, logging failures.
+func (s *Server) savePosition() {
+	if err := savePositionFile(s.cfg.PositionFile, s.positionState()); err != nil {
+		slog.Warn("Error saving position file", "file", s.cfg.PositionFile, "err", err)
+	}
+}
+
+// persistPosition saves the position file periodically until ctx is
+// cancelled. Run saves it once more on shutdown.
+func (s *Server) persistPosition(ctx context.Context) {
+	ticker := time.NewTicker(positionSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.savePosition()
+		}
+	}
+}
+
+// resumeSeek returns a Tailer.Seek that continues a file from its saved
+// position. When the file was rotated while the server was down, the rest of
+// the rotated file is passed to handle first, if it can still be found next
+// to the log, and the new file is read from the beginning. Files without a
+// saved position are positioned by fallback.
+func (s *Server) resumeSeek(handle lineHandler, fallback seekFunc) seekFunc {
+	return func(ctx context.Context, file *os.File) (int64, error) {
+		path := file.Name()
+		saved, ok := s.offsets.get(path)
+		if !ok {
+			if fallback == nil {
+				return 0, nil
+			}
+			return fallback(ctx, file)
+		}
+
+		inode, err := getInode(path)
+		if err != nil {
+			return 0, err
+		}
+		if inode == saved.Inode {
+			info, err := file.Stat()
+			if err != nil {
+				return 0, err
+			}
+			if saved.Offset > info.Size() {
+				slog.Info("Log file shorter than saved position, reading from the start", "file", path, "offset", saved.Offset)
+				return 0, nil
+			}
+			slog.Info("Resuming log file from saved position", "file", path, "offset", saved.Offset)
+			return saved.Offset, nil
+		}
+
+		rotated := findRotatedFile(path, saved.Inode)
+		if rotated == "" {
+			slog.Warn("Log file rotated while stopped and the old file was not found, reading the new one from the start", "file", path)
+			return 0, nil
+		}
+		slog.Info("Log file rotated while stopped, reading the rest of the old one", "file", path, "rotated", rotated, "offset", saved.Offset)
+		if err := readRemainder(ctx, rotated, saved.Offset, handle); err != nil {
+			return 0, fmt.Errorf("reading rotated log file: %w", err)
+		}
+		return 0, nil
+	}
+}
+
+// findRotatedFile looks next to path for the file with the given inode,
+// such as access.log.1 after logrotate renamed access.log. Compressed
+// rotations cannot be resumed and are not matched.
+func findRotatedFile(path string, inode uint64) string {
+	dir, base := filepath.Split(path)
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == base || !strings.HasPrefix(name, base) || strings.HasSuffix(name, ".gz") {
+			continue
+		}
+		candidate := filepath.Join(dir, name)
+		if ino, err := getInode(candidate); err == nil && ino == inode {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// readRemainder passes every line of path after offset to handle.
+func readRemainder(ctx context.Context, path string, offset int64, handle lineHandler) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	return forEachLine(ctx, file, handle)
+}
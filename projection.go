@@ -0,0 +1,52 @@
+package nginxviz
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// logEntryFields maps the JSON names of LogEntry fields to their index, so
+// API clients can select fields by the names they see on the wire.
+var logEntryFields = jsonFieldIndex(reflect.TypeOf(LogEntry{}))
+
+func jsonFieldIndex(t reflect.Type) map[string]int {
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = i
+	}
+	return fields
+}
+
+// FieldProjection selects a subset of LogEntry fields for API responses.
+type FieldProjection []string
+
+// ParseFieldProjection parses a comma separated list of JSON field names.
+func ParseFieldProjection(list string) (FieldProjection, error) {
+	var fields FieldProjection
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := logEntryFields[name]; !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		fields = append(fields, name)
+	}
+	return fields, nil
+}
+
+// Apply returns the selected fields of entry keyed by their JSON names.
+func (p FieldProjection) Apply(entry LogEntry) map[string]any {
+	v := reflect.ValueOf(entry)
+	projected := make(map[string]any, len(p))
+	for _, name := range p {
+		projected[name] = v.Field(logEntryFields[name]).Interface()
+	}
+	return projected
+}
@@ -0,0 +1,75 @@
+package nginxviz
+
+import (
+	"net/http"
+	"sync"
+)
+
+// Protocol values are counted as logged when known and grouped otherwise,
+// since the request line's version is chosen by the client.
+var (
+	httpProtocols = []string{"HTTP/1.0", "HTTP/1.1", "HTTP/2.0", "HTTP/3.0"}
+	tlsProtocols  = []string{"SSLv3", "TLSv1", "TLSv1.1", "TLSv1.2", "TLSv1.3"}
+)
+
+// protocolBucket returns value if it is one of known, "none" if it was not
+// logged and "other" otherwise.
+func protocolBucket(value string, known []string) string {
+	if value == "" {
+		return "none"
+	}
+	for _, k := range known {
+		if value == k {
+			return value
+		}
+	}
+	return "other"
+}
+
+type protocolSnapshot struct {
+	HTTP map[string]int `json:"http"`
+	TLS  map[string]int `json:"tls"`
+}
+
+// protocolBreakdown counts requests per HTTP version and TLS protocol.
+type protocolBreakdown struct {
+	mu   sync.Mutex
+	http map[string]int
+	tls  map[string]int
+}
+
+func newProtocolBreakdown() *protocolBreakdown {
+	return &protocolBreakdown{http: make(map[string]int), tls: make(map[string]int)}
+}
+
+func (b *protocolBreakdown) Observe(entry LogEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.http[protocolBucket(entry.Protocol, httpProtocols)]++
+	b.tls[protocolBucket(entry.TLSProtocol, tlsProtocols)]++
+}
+
+func (b *protocolBreakdown) Snapshot() protocolSnapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	snapshot := protocolSnapshot{
+		HTTP: make(map[string]int, len(b.http)),
+		TLS:  make(map[string]int, len(b.tls)),
+	}
+	for name, count := range b.http {
+		snapshot.HTTP[name] = count
+	}
+	for name, count := range b.tls {
+		snapshot.TLS[name] = count
+	}
+	return snapshot
+}
+
+// MakeProtocolsHandler returns request counts per HTTP version and TLS protocol.
+func MakeProtocolsHandler(protocols *protocolBreakdown) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, protocols.Snapshot())
+	}
+}
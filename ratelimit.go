@@ -0,0 +1,126 @@
+package nginxviz
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const maxTrackedIPs = 100000
+
+// ipWindow approximates a sliding window with the counts of the current and
+// previous fixed windows, so each IP costs a constant amount of memory.
+type ipWindow struct {
+	start    time.Time
+	current  int
+	previous int
+	lastSeen time.Time
+}
+
+// rateTracker counts requests per IP over a sliding window and flags IPs
+// exceeding a threshold. Idle IPs are evicted to keep memory bounded.
+type rateTracker struct {
+	mu        sync.Mutex
+	window    time.Duration
+	threshold int
+	ips       map[string]*ipWindow
+	lastSweep time.Time
+}
+
+func newRateTracker(threshold int, window time.Duration) *rateTracker {
+	return &rateTracker{
+		window:    window,
+		threshold: threshold,
+		ips:       make(map[string]*ipWindow),
+	}
+}
+
+// Observe records a request from ip at ts and returns the current rate and
+// whether it exceeds the threshold.
+func (t *rateTracker) Observe(ip string, ts time.Time) (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if ts.Sub(t.lastSweep) > t.window || len(t.ips) >= maxTrackedIPs {
+		t.evictIdle(ts)
+	}
+
+	w, ok := t.ips[ip]
+	if !ok {
+		if len(t.ips) >= maxTrackedIPs {
+			return 0, false
+		}
+		w = &ipWindow{start: ts}
+		t.ips[ip] = w
+	}
+
+	t.advance(w, ts)
+	w.current++
+	if ts.After(w.lastSeen) {
+		w.lastSeen = ts
+	}
+
+	rate := t.rate(w, ts)
+	return rate, rate > t.threshold
+}
+
+// Rate returns the current rate for ip without recording a request.
+func (t *rateTracker) Rate(ip string, now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	w, ok := t.ips[ip]
+	if !ok {
+		return 0
+	}
+	t.advance(w, now)
+	return t.rate(w, now)
+}
+
+func (t *rateTracker) Exceeded(rate int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return rate > t.threshold
+}
+
+// SetThreshold changes the threshold of a running tracker.
+func (t *rateTracker) SetThreshold(threshold int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.threshold = threshold
+}
+
+func (t *rateTracker) advance(w *ipWindow, ts time.Time) {
+	elapsed := ts.Sub(w.start)
+	switch {
+	case elapsed < t.window:
+	case elapsed < 2*t.window:
+		w.previous = w.current
+		w.current = 0
+		w.start = w.start.Add(t.window)
+	default:
+		w.previous = 0
+		w.current = 0
+		w.start = ts
+	}
+}
+
+func (t *rateTracker) rate(w *ipWindow, ts time.Time) int {
+	elapsed := ts.Sub(w.start)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	weight := 1 - float64(elapsed)/float64(t.window)
+	return w.current + int(math.Round(float64(w.previous)*weight))
+}
+
+func (t *rateTracker) evictIdle(now time.Time) {
+	for ip, w := range t.ips {
+		if now.Sub(w.lastSeen) > 2*t.window {
+			delete(t.ips, ip)
+		}
+	}
+	t.lastSweep = now
+}
@@ -0,0 +1,98 @@
+package nginxviz
+
+import "sync"
+
+const (
+	rateLimitWindow = 60 // seconds
+	// rateLimitMinRequests keeps a handful of rejected requests on an idle
+	// site from raising an alert.
+	rateLimitMinRequests = 20
+)
+
+type rateLimitAlert struct {
+	Type      string  `json:"type"`
+	Fraction  float64 `json:"fraction"`
+	Threshold float64 `json:"threshold"`
+	Limited   int     `json:"limited"`
+	Requests  int     `json:"requests"`
+}
+
+type rateLimitBucket struct {
+	second   int64
+	requests int
+	limited  int
+}
+
+// rateLimitMonitor tracks the fraction of requests rejected or delayed by
+// nginx's limit_req over the last minute, in one-second buckets. It alerts
+// once when the fraction crosses the threshold and re-arms when it drops
+// back below it.
+type rateLimitMonitor struct {
+	mu        sync.Mutex
+	threshold float64
+	buckets   [rateLimitWindow]rateLimitBucket
+	alerting  bool
+}
+
+func newRateLimitMonitor(threshold float64) *rateLimitMonitor {
+	return &rateLimitMonitor{threshold: threshold}
+}
+
+// SetThreshold changes the alert threshold of a running monitor.
+func (m *rateLimitMonitor) SetThreshold(threshold float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.threshold = threshold
+}
+
+// Observe records entry and returns an alert when the rate-limited fraction
+// has just exceeded the threshold.
+func (m *rateLimitMonitor) Observe(entry LogEntry) (rateLimitAlert, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	second := entry.Timestamp.Unix()
+	b := &m.buckets[second%rateLimitWindow]
+	if b.second != second {
+		*b = rateLimitBucket{second: second}
+	}
+	b.requests++
+	if entry.RateLimited() {
+		b.limited++
+	}
+
+	var requests, limited int
+	for _, b := range m.buckets {
+		if second-b.second < rateLimitWindow {
+			requests += b.requests
+			limited += b.limited
+		}
+	}
+	if requests < rateLimitMinRequests {
+		return rateLimitAlert{}, false
+	}
+
+	fraction := float64(limited) / float64(requests)
+	if fraction <= m.threshold {
+		m.alerting = false
+		return rateLimitAlert{}, false
+	}
+	if m.alerting {
+		return rateLimitAlert{}, false
+	}
+	m.alerting = true
+	return rateLimitAlert{
+		Type:      "rate_limit_alert",
+		Fraction:  fraction,
+		Threshold: m.threshold,
+		Limited:   limited,
+		Requests:  requests,
+	}, true
+}
+
+// RateLimited reports whether nginx's limit_req rejected or delayed the
+// request. PASSED is logged for requests that were under the limit.
+func (e LogEntry) RateLimited() bool {
+	return e.RateLimitStatus != "" && e.RateLimitStatus != "PASSED"
+}
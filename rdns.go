@@ -0,0 +1,222 @@
+package nginxviz
+
+import (
+	"container/list"
+	"context"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rdnsWorkers     = 8
+	rdnsQueueSize   = 1024
+	rdnsTimeout     = 2 * time.Second
+	rdnsMaxEntries  = 10000
+	rdnsNegativeTTL = 5 * time.Minute // failed lookups are retried sooner than RDNSTTL
+)
+
+// verifiedCrawler is a search engine crawler whose addresses resolve to
+// hostnames under one of its domains, as the search engines document for
+// telling their crawlers apart from impostors using the same user agent.
+type verifiedCrawler struct {
+	Name      string
+	UAPattern string // lowercase substring of the user agent
+	Domains   []string
+}
+
+var verifiedCrawlers = []verifiedCrawler{
+	{Name: "Googlebot", UAPattern: "googlebot", Domains: []string{"googlebot.com", "google.com"}},
+	{Name: "Bingbot", UAPattern: "bingbot", Domains: []string{"search.msn.com"}},
+	{Name: "Applebot", UAPattern: "applebot", Domains: []string{"applebot.apple.com"}},
+	{Name: "YandexBot", UAPattern: "yandex", Domains: []string{"yandex.ru", "yandex.net", "yandex.com"}},
+	{Name: "Baiduspider", UAPattern: "baiduspider", Domains: []string{"baidu.com", "baidu.jp"}},
+	{Name: "Yahoo Slurp", UAPattern: "slurp", Domains: []string{"crawl.yahoo.net"}},
+	{Name: "PetalBot", UAPattern: "petalbot", Domains: []string{"petalsearch.com"}},
+}
+
+// rdnsResult is the outcome of a reverse lookup. Confirmed is set when the
+// hostname resolves back to the address, so the PTR record cannot simply
+// have been made up by whoever controls the address's reverse zone.
+type rdnsResult struct {
+	Hostname  string
+	Confirmed bool
+}
+
+type rdnsCacheItem struct {
+	ip      string
+	result  rdnsResult
+	expires time.Time
+}
+
+// hostnameResolver performs reverse DNS lookups in the background so that
+// slow DNS never stalls the log pipeline. Results are kept in an LRU for
+// ttl and at most rdnsWorkers lookups run at a time.
+type hostnameResolver struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	order   *list.List
+	cache   map[string]*list.Element
+	pending map[string]bool
+	jobs    chan string
+}
+
+func newHostnameResolver(ttl time.Duration) *hostnameResolver {
+	return &hostnameResolver{
+		ttl:     ttl,
+		order:   list.New(),
+		cache:   make(map[string]*list.Element),
+		pending: make(map[string]bool),
+		jobs:    make(chan string, rdnsQueueSize),
+	}
+}
+
+// Start launches the lookup workers. They exit when ctx is cancelled.
+func (h *hostnameResolver) Start(ctx context.Context) {
+	for i := 0; i < rdnsWorkers; i++ {
+		go h.worker(ctx)
+	}
+}
+
+// Hostname returns the cached PTR name for ip. On a cache miss a lookup is
+// queued and false is returned; later entries from the same IP pick it up.
+func (h *hostnameResolver) Hostname(ip string) (string, bool) {
+	result, ok := h.Lookup(ip)
+	return result.Hostname, ok
+}
+
+// Lookup is Hostname with whether the name was forward-confirmed. An
+// expired result is still returned while it is looked up again.
+func (h *hostnameResolver) Lookup(ip string) (rdnsResult, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	el, ok := h.cache[ip]
+	if ok {
+		h.order.MoveToFront(el)
+		item := el.Value.(*rdnsCacheItem)
+		if time.Now().Before(item.expires) {
+			return item.result, true
+		}
+	}
+	h.queue(ip)
+	if ok {
+		return el.Value.(*rdnsCacheItem).result, true
+	}
+	return rdnsResult{}, false
+}
+
+// queue schedules a lookup of ip unless one is already pending. h.mu must
+// be held.
+func (h *hostnameResolver) queue(ip string) {
+	if h.pending[ip] {
+		return
+	}
+	select {
+	case h.jobs <- ip:
+		h.pending[ip] = true
+	default:
+		// Queue is full, try again on the next entry from this IP
+	}
+}
+
+func (h *hostnameResolver) worker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ip := <-h.jobs:
+			h.store(ip, h.lookup(ctx, ip))
+		}
+	}
+}
+
+func (h *hostnameResolver) lookup(ctx context.Context, ip string) rdnsResult {
+	lookupCtx, cancel := context.WithTimeout(ctx, rdnsTimeout)
+	defer cancel()
+
+	names, err := net.DefaultResolver.LookupAddr(lookupCtx, ip)
+	if err != nil || len(names) == 0 {
+		slog.Debug("Reverse DNS lookup failed", "ip", ip, "err", err)
+		return rdnsResult{}
+	}
+	result := rdnsResult{Hostname: strings.TrimSuffix(names[0], ".")}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(lookupCtx, "ip", result.Hostname)
+	if err != nil {
+		slog.Debug("Forward DNS lookup failed", "ip", ip, "hostname", result.Hostname, "err", err)
+		return result
+	}
+	want, _ := netip.ParseAddr(ip)
+	for _, addr := range addrs {
+		if addr.Unmap() == want.Unmap() {
+			result.Confirmed = true
+			break
+		}
+	}
+	return result
+}
+
+func (h *hostnameResolver) store(ip string, result rdnsResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.pending, ip)
+
+	ttl := h.ttl
+	if result.Hostname == "" {
+		ttl = min(ttl, rdnsNegativeTTL)
+	}
+	item := &rdnsCacheItem{ip: ip, result: result, expires: time.Now().Add(ttl)}
+
+	if el, ok := h.cache[ip]; ok {
+		el.Value = item
+		h.order.MoveToFront(el)
+		return
+	}
+	h.cache[ip] = h.order.PushFront(item)
+
+	if h.order.Len() > rdnsMaxEntries {
+		oldest := h.order.Back()
+		h.order.Remove(oldest)
+		delete(h.cache, oldest.Value.(*rdnsCacheItem).ip)
+	}
+}
+
+// verifyCrawler labels an entry whose user agent claims to be one of the
+// verifiedCrawlers: VerifiedCrawler is set when its address resolves to
+// the crawler's domains and back, SpoofedCrawler when it resolves
+// elsewhere. Entries whose address has not been looked up yet get neither.
+func verifyCrawler(entry *LogEntry, result rdnsResult, resolved bool) {
+	if !resolved {
+		return
+	}
+	ua := strings.ToLower(entry.UserAgent)
+	for _, crawler := range verifiedCrawlers {
+		if !strings.Contains(ua, crawler.UAPattern) {
+			continue
+		}
+		if result.Confirmed && hasDomainSuffix(result.Hostname, crawler.Domains) {
+			entry.VerifiedCrawler = crawler.Name
+		} else {
+			entry.SpoofedCrawler = true
+		}
+		return
+	}
+}
+
+// hasDomainSuffix reports whether hostname is one of domains or a
+// subdomain of one.
+func hasDomainSuffix(hostname string, domains []string) bool {
+	hostname = strings.ToLower(hostname)
+	for _, domain := range domains {
+		if hostname == domain || strings.HasSuffix(hostname, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,282 @@
+package nginxviz
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	redisBlock = 5 * time.Second // how long an XREAD waits for new entries
+	redisCount = 500             // entries read at most per XREAD
+)
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisConn is a minimal RESP client, enough for reading a stream.
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialRedis connects to a redis:// or rediss:// URL, authenticating with its
+// user and password and selecting the database in its path.
+func dialRedis(ctx context.Context, rawURL string) (*redisConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "rediss" {
+		conn = tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+
+	if password, ok := u.User.Password(); ok {
+		args := []string{"AUTH", password}
+		if user := u.User.Username(); user != "" {
+			args = []string{"AUTH", user, password}
+		}
+		if _, err := c.do(args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" && db != "0" {
+		if _, err := c.do("SELECT", db); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *redisConn) Close() error {
+	return c.conn.Close()
+}
+
+// do sends a command and returns its reply: a string, an int64, nil or a
+// []any of those.
+func (c *redisConn) do(args ...string) (any, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.conn.Write([]byte(b.String())); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+func (c *redisConn) readReply() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// redisStreamEntries returns the ID and fields of each entry in an XRANGE
+// style reply.
+func redisStreamEntries(reply any) ([]string, [][]any, error) {
+	items, ok := reply.([]any)
+	if !ok {
+		return nil, nil, fmt.Errorf("redis: unexpected stream reply %T", reply)
+	}
+	ids := make([]string, len(items))
+	fields := make([][]any, len(items))
+	for i, item := range items {
+		entry, ok := item.([]any)
+		if !ok || len(entry) != 2 {
+			return nil, nil, fmt.Errorf("redis: unexpected stream entry %v", item)
+		}
+		if ids[i], ok = entry[0].(string); !ok {
+			return nil, nil, fmt.Errorf("redis: unexpected stream entry ID %v", entry[0])
+		}
+		fields[i], _ = entry[1].([]any)
+	}
+	return ids, fields, nil
+}
+
+// redisStartID returns the ID after which the stream is read on startup,
+// where -from-end and -backfill say a log file would start.
+func (s *Server) redisStartID(c *redisConn) (string, error) {
+	key := s.cfg.RedisStream
+	switch {
+	case s.cfg.FromEnd, s.cfg.Backfill > 0:
+		// The last Backfill entries are read, so start after the one
+		// before them
+		count := s.cfg.Backfill + 1
+		if s.cfg.FromEnd {
+			count = 1
+		}
+		reply, err := c.do("XREVRANGE", key, "+", "-", "COUNT", strconv.Itoa(count))
+		if err != nil {
+			return "", err
+		}
+		ids, _, err := redisStreamEntries(reply)
+		if err != nil {
+			return "", err
+		}
+		if len(ids) < count {
+			return "0-0", nil
+		}
+		return ids[len(ids)-1], nil
+	case s.cfg.BackfillSince > 0:
+		// Entry IDs start with their time in milliseconds
+		return strconv.FormatInt(time.Now().Add(-s.cfg.BackfillSince).UnixMilli(), 10) + "-0", nil
+	}
+	return "0-0", nil
+}
+
+// readRedis passes the entries of the Redis stream to the handlers until
+// ctx is cancelled, reconnecting after a pause when the connection fails.
+func (s *Server) readRedis(ctx context.Context) {
+	key := s.cfg.RedisStream
+	var lastID string
+	for {
+		err := func() error {
+			c, err := dialRedis(ctx, s.cfg.RedisURL)
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			// A blocked XREAD is ended by closing the connection
+			stop := context.AfterFunc(ctx, func() { c.Close() })
+			defer stop()
+
+			if lastID == "" {
+				if lastID, err = s.redisStartID(c); err != nil {
+					return err
+				}
+			}
+			slog.Info("Reading Redis stream", "stream", key, "after", lastID)
+			return s.followRedis(ctx, c, &lastID)
+		}()
+		if ctx.Err() != nil {
+			slog.Info("Stopped reading Redis stream", "stream", key)
+			return
+		}
+		slog.Error("Error reading Redis stream", "stream", key, "err", err)
+		if sleepContext(ctx, 2*time.Second) != nil {
+			return
+		}
+	}
+}
+
+// followRedis reads the stream after *lastID until reading fails, moving
+// *lastID past every entry handled.
+func (s *Server) followRedis(ctx context.Context, c *redisConn, lastID *string) error {
+	key := s.cfg.RedisStream
+	for ctx.Err() == nil {
+		reply, err := c.do("XREAD", "COUNT", strconv.Itoa(redisCount), "BLOCK", strconv.FormatInt(redisBlock.Milliseconds(), 10), "STREAMS", key, *lastID)
+		if err != nil {
+			return err
+		}
+		// nil when nothing arrived within redisBlock
+		streams, _ := reply.([]any)
+		for _, stream := range streams {
+			pair, ok := stream.([]any)
+			if !ok || len(pair) != 2 {
+				return fmt.Errorf("redis: unexpected XREAD reply %v", stream)
+			}
+			ids, fields, err := redisStreamEntries(pair[1])
+			if err != nil {
+				return err
+			}
+			for i, id := range ids {
+				if err := s.handleRedisEntry(ctx, fields[i]); err != nil {
+					return err
+				}
+				*lastID = id
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// handleRedisEntry handles the fields of a stream entry: a "line" holds a
+// log line parsed with the configured format, and an "entry" a LogEntry as
+// JSON, handled like one pushed to /api/ingest.
+func (s *Server) handleRedisEntry(ctx context.Context, fields []any) error {
+	for i := 0; i+1 < len(fields); i += 2 {
+		name, _ := fields[i].(string)
+		value, _ := fields[i+1].(string)
+		switch name {
+		case "line":
+			if err := s.handleAccessLogLine(ctx, value); err != nil {
+				return err
+			}
+		case "entry":
+			var entry LogEntry
+			if err := json.Unmarshal([]byte(value), &entry); err != nil {
+				slog.Debug("Skipping Redis stream entry", "err", err)
+				continue
+			}
+			if entry, ok := s.ingestedEntry(entry); ok {
+				if err := s.submitEntry(ctx, entry); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
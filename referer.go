@@ -0,0 +1,192 @@
+package nginxviz
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Referer types, the source of a visit.
+const (
+	refererDirect   = "direct"   // no referer
+	refererInternal = "internal" // a page of the same site
+	refererSearch   = "search"
+	refererSocial   = "social"
+	refererExternal = "external" // any other site
+)
+
+const (
+	referersDefaultLimit = 20
+	// referersMaxTracked bounds the referring domains counted; the first
+	// ones seen are kept.
+	referersMaxTracked = 10000
+)
+
+var (
+	searchDomains = []string{
+		"bing.com", "duckduckgo.com", "search.yahoo.com", "baidu.com", "ecosia.org",
+		"search.brave.com", "startpage.com", "qwant.com", "naver.com", "sogou.com",
+		"kagi.com", "perplexity.ai",
+	}
+	// searchBrands run search engines under country domains, such as
+	// google.co.uk and yandex.ru
+	searchBrands = []string{"google", "yandex"}
+
+	socialDomains = []string{
+		"facebook.com", "fb.com", "instagram.com", "t.co", "twitter.com", "x.com",
+		"linkedin.com", "lnkd.in", "reddit.com", "youtube.com", "pinterest.com",
+		"tiktok.com", "news.ycombinator.com", "lobste.rs", "mastodon.social",
+		"bsky.app", "threads.net", "vk.com", "t.me", "discord.com", "whatsapp.com",
+	}
+)
+
+// refererDomain returns the host of a referer, lowercased and without a
+// port or www., or "" when there is none.
+func refererDomain(referer string) string {
+	if referer == "" || referer == "-" {
+		return ""
+	}
+	u, err := url.Parse(referer)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	host := strings.ToLower(u.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimPrefix(host, "www.")
+}
+
+// classifyReferer sets the referer domain and type of entry. The referer is
+// internal when it is the entry's own host or one of internalDomains.
+func classifyReferer(entry *LogEntry, internalDomains []string) {
+	entry.RefererDomain = refererDomain(entry.Referer)
+	domain := entry.RefererDomain
+
+	switch {
+	case domain == "":
+		entry.RefererType = refererDirect
+	case domain == strings.TrimPrefix(normalizeHost(entry.Host), "www.") || hasDomainSuffix(domain, internalDomains):
+		entry.RefererType = refererInternal
+	case hasDomainSuffix(domain, searchDomains) || hasBrandLabel(domain, searchBrands):
+		entry.RefererType = refererSearch
+	case hasDomainSuffix(domain, socialDomains):
+		entry.RefererType = refererSocial
+	default:
+		entry.RefererType = refererExternal
+	}
+}
+
+// hasBrandLabel reports whether one of the labels of domain is a brand
+// followed only by what looks like a public suffix, one or two labels of up
+// to three letters, as in google.de, google.co.uk or news.google.com.
+func hasBrandLabel(domain string, brands []string) bool {
+	labels := strings.Split(domain, ".")
+	for i, label := range labels {
+		suffix := labels[i+1:]
+		if len(suffix) < 1 || len(suffix) > 2 || !slices.Contains(brands, label) {
+			continue
+		}
+		if !slices.ContainsFunc(suffix, func(l string) bool { return len(l) > 3 }) {
+			return true
+		}
+	}
+	return false
+}
+
+type refererCount struct {
+	Domain   string `json:"domain"`
+	Type     string `json:"type"`
+	Requests int    `json:"requests"`
+}
+
+type refererSnapshot struct {
+	Types   map[string]int `json:"types"`
+	Domains []refererCount `json:"domains"`
+}
+
+// refererBreakdown counts requests per referer type and referring domain.
+// Internal referers are only counted by type.
+type refererBreakdown struct {
+	mu      sync.Mutex
+	types   map[string]int
+	domains map[string]*refererCount
+}
+
+func newRefererBreakdown() *refererBreakdown {
+	return &refererBreakdown{types: make(map[string]int), domains: make(map[string]*refererCount)}
+}
+
+func (b *refererBreakdown) Observe(entry LogEntry) {
+	if entry.RefererType == "" {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.types[entry.RefererType]++
+	if entry.RefererDomain == "" || entry.RefererType == refererInternal {
+		return
+	}
+	domain, ok := b.domains[entry.RefererDomain]
+	if !ok {
+		if len(b.domains) >= referersMaxTracked {
+			return
+		}
+		domain = &refererCount{Domain: entry.RefererDomain, Type: entry.RefererType}
+		b.domains[entry.RefererDomain] = domain
+	}
+	domain.Requests++
+}
+
+// Snapshot returns the counts per type and the n domains with the most
+// requests.
+func (b *refererBreakdown) Snapshot(n int) refererSnapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	snapshot := refererSnapshot{
+		Types:   make(map[string]int, len(b.types)),
+		Domains: make([]refererCount, 0, len(b.domains)),
+	}
+	for name, count := range b.types {
+		snapshot.Types[name] = count
+	}
+	for _, domain := range b.domains {
+		snapshot.Domains = append(snapshot.Domains, *domain)
+	}
+	sort.Slice(snapshot.Domains, func(i, j int) bool {
+		a, b := snapshot.Domains[i], snapshot.Domains[j]
+		if a.Requests != b.Requests {
+			return a.Requests > b.Requests
+		}
+		return a.Domain < b.Domain
+	})
+	if len(snapshot.Domains) > n {
+		snapshot.Domains = snapshot.Domains[:n]
+	}
+	return snapshot
+}
+
+// MakeReferersHandler returns request counts per referer type and the ?n
+// referring domains with the most requests.
+func MakeReferersHandler(referers *refererBreakdown) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := referersDefaultLimit
+		if param := r.URL.Query().Get("n"); param != "" {
+			var err error
+			n, err = strconv.Atoi(param)
+			if err != nil || n < 0 {
+				returnError(w, http.StatusBadRequest, "n must be a non-negative integer")
+				return
+			}
+		}
+		writeJSON(w, referers.Snapshot(n))
+	}
+}
@@ -0,0 +1,212 @@
+package nginxviz
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReplaySpeed is how many times faster than it happened traffic is
+// replayed, written as 10 or 10x in flags and the config file.
+type ReplaySpeed float64
+
+func (s ReplaySpeed) String() string {
+	return strconv.FormatFloat(float64(s), 'g', -1, 64) + "x"
+}
+
+// Set implements flag.Value.
+func (s *ReplaySpeed) Set(value string) error {
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "x"), 64)
+	if err != nil || speed <= 0 {
+		return fmt.Errorf("invalid speed %q", value)
+	}
+	*s = ReplaySpeed(speed)
+	return nil
+}
+
+// UnmarshalText lets speeds be written as strings in the config file.
+func (s *ReplaySpeed) UnmarshalText(text []byte) error {
+	return s.Set(string(text))
+}
+
+type replayRequest struct {
+	File  string    `json:"file"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Speed float64   `json:"speed"`
+}
+
+// MakeReplayHandler starts re-broadcasting the entries of a log file within a
+// time range, paced by their original timestamps scaled by speed.
+func (s *Server) MakeReplayHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req replayRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			returnError(w, http.StatusBadRequest, "invalid replay request")
+			return
+		}
+		if req.File == "" {
+			returnError(w, http.StatusBadRequest, "file is required")
+			return
+		}
+		if req.Speed == 0 {
+			req.Speed = 1
+		}
+		if req.Speed < 0 {
+			returnError(w, http.StatusBadRequest, "speed must be positive")
+			return
+		}
+
+		if !s.replayRunning.CompareAndSwap(false, true) {
+			returnError(w, http.StatusConflict, "a replay is already running")
+			return
+		}
+
+		if !s.replayFileAllowed(req.File) {
+			s.replayRunning.Store(false)
+			returnError(w, http.StatusForbidden, "file must be the log file or in its directory")
+			return
+		}
+		file, err := os.Open(req.File)
+		if err != nil {
+			s.replayRunning.Store(false)
+			returnError(w, http.StatusBadRequest, "cannot open file")
+			return
+		}
+
+		go func() {
+			defer s.replayRunning.Store(false)
+			defer file.Close()
+			s.replayLogFile(s.ctx, file, req, func(ctx context.Context, entry LogEntry) error {
+				return s.geoPool.Submit(ctx, entry, s.replays)
+			})
+		}()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"status":"started"}`))
+	}
+}
+
+// replayFileAllowed reports whether clients may replay file. Only LogFile
+// and the files in its directory, such as its rotations, are allowed, with
+// symlinks resolved, so the API cannot be used to read other files.
+func (s *Server) replayFileAllowed(file string) bool {
+	logFile := s.cfg.LogFile
+	if logFile == "" || logFile == stdinLogFile || strings.Contains(logFile, "://") || strings.HasPrefix(logFile, journalPrefix) {
+		return false
+	}
+	dir := filepath.Dir(logFile)
+	if isLogGlob(dir) {
+		return false
+	}
+
+	dir, err := resolvePath(dir)
+	if err != nil {
+		return false
+	}
+	path, err := resolvePath(file)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolvePath returns the absolute path of path with symlinks resolved.
+func resolvePath(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(path)
+}
+
+// replayLogFile passes the entries of r within the time range of req to
+// submit, paced by their original timestamps scaled by req.Speed. Lines
+// that fail to parse are skipped without being recorded, as the file may
+// not be the one being followed.
+func (s *Server) replayLogFile(ctx context.Context, r io.Reader, req replayRequest, submit func(context.Context, LogEntry) error) {
+	slog.Info("Starting replay", "file", req.File, "start", req.Start, "end", req.End, "speed", req.Speed)
+
+	var previous time.Time
+	count := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		logEntry, ok, _ := s.parseLogLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		if !req.Start.IsZero() && logEntry.Timestamp.Before(req.Start) {
+			continue
+		}
+		if !req.End.IsZero() && logEntry.Timestamp.After(req.End) {
+			continue
+		}
+
+		if !previous.IsZero() {
+			if gap := logEntry.Timestamp.Sub(previous); gap > 0 {
+				if sleepContext(ctx, time.Duration(float64(gap)/req.Speed)) != nil {
+					return
+				}
+			}
+		}
+		previous = logEntry.Timestamp
+
+		if submit(ctx, logEntry) != nil {
+			return
+		}
+		count++
+	}
+
+	if err := scanner.Err(); err != nil {
+		slog.Error("Error reading replay file", "file", req.File, "err", err)
+	}
+	slog.Info("Replay finished", "file", req.File, "entries", count)
+}
+
+// replayInput plays LogFile back as live traffic, for -replay. Entries are
+// broadcast as log_entry messages, so the globe shows them as if they were
+// happening now. Files ending in .gz are decompressed.
+func (s *Server) replayInput(ctx context.Context) {
+	file, err := os.Open(s.cfg.LogFile)
+	if err != nil {
+		slog.Error("Cannot open replay file", "file", s.cfg.LogFile, "err", err)
+		return
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(s.cfg.LogFile, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			slog.Error("Cannot decompress replay file", "file", s.cfg.LogFile, "err", err)
+			return
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	req := replayRequest{
+		File:  s.cfg.LogFile,
+		Start: s.cfg.ReplayStart,
+		End:   s.cfg.ReplayEnd,
+		Speed: float64(s.cfg.ReplaySpeed),
+	}
+	// The lag gauge is skipped, as every replayed entry is old
+	s.replayLogFile(ctx, r, req, func(ctx context.Context, entry LogEntry) error {
+		return s.geoPool.Submit(ctx, entry, s.entries)
+	})
+}
@@ -0,0 +1,138 @@
+package nginxviz
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	resumeMaxClients = 1000
+	resumeTTL        = 10 * time.Minute
+)
+
+// clientHello is sent by clients right after connecting. LastSeq is the seq
+// of the last message the client received, if it has one.
+type clientHello struct {
+	Type     string `json:"type"`
+	ClientID string `json:"client_id"`
+	LastSeq  uint64 `json:"last_seq"`
+}
+
+// resumeRequest asks the broadcaster to catch a reconnected client up.
+type resumeRequest struct {
+	conn  *websocket.Conn
+	hello clientHello
+}
+
+type resumedUpdate struct {
+	Type     string `json:"type"`
+	Replayed int    `json:"replayed"`
+}
+
+type resumeItem struct {
+	clientID string
+	lastSeq  uint64
+	storedAt time.Time
+}
+
+// resumeCache remembers the last seq broadcast to recently disconnected
+// clients, keyed by client id, with LRU eviction and a TTL.
+type resumeCache struct {
+	mu    sync.Mutex
+	order *list.List
+	items map[string]*list.Element
+}
+
+func newResumeCache() *resumeCache {
+	return &resumeCache{
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *resumeCache) Store(clientID string, lastSeq uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[clientID]; ok {
+		c.order.Remove(el)
+	}
+	c.items[clientID] = c.order.PushFront(&resumeItem{clientID: clientID, lastSeq: lastSeq, storedAt: time.Now()})
+
+	if c.order.Len() > resumeMaxClients {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*resumeItem).clientID)
+	}
+}
+
+// Take returns and forgets the last seq stored for clientID.
+func (c *resumeCache) Take(clientID string) (uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[clientID]
+	if !ok {
+		return 0, false
+	}
+	c.order.Remove(el)
+	delete(c.items, clientID)
+
+	item := el.Value.(*resumeItem)
+	if time.Since(item.storedAt) > resumeTTL {
+		return 0, false
+	}
+	return item.lastSeq, true
+}
+
+// parseClientHello reports whether message is a hello with a client id.
+func parseClientHello(message []byte) (clientHello, bool) {
+	var hello clientHello
+	if err := json.Unmarshal(message, &hello); err != nil {
+		return clientHello{}, false
+	}
+	return hello, hello.Type == "hello" && hello.ClientID != ""
+}
+
+// resumeClient replays the entries a reconnecting client missed. It runs on
+// the broadcast goroutine so replayed and live messages are never interleaved.
+func (s *Server) resumeClient(req resumeRequest) {
+	var hosts hostFilter
+	var tor torFilter
+	s.clientsMu.Lock()
+	if info, ok := s.clients[req.conn]; ok {
+		info.ClientID = req.hello.ClientID
+		hosts, tor = info.Hosts, info.Tor
+	}
+	s.clientsMu.Unlock()
+
+	lastSeq, known := s.resumeClients.Take(req.hello.ClientID)
+	if req.hello.LastSeq > 0 {
+		lastSeq, known = req.hello.LastSeq, true
+	}
+	if !known {
+		return
+	}
+
+	replayed := 0
+	for _, e := range s.history.Since(lastSeq) {
+		if !hosts.Matches(e.Entry.Host) || !tor.Matches(e.Entry.Tor) || s.blockList.IsMuted(e.Entry.IP) {
+			continue
+		}
+		message, err := json.Marshal(LogUpdate{Type: "log_entry", LogType: logTypeAccess, Seq: e.Seq, Data: s.publicEntry(e.Entry)})
+		if err != nil {
+			continue
+		}
+		if err := req.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			return
+		}
+		replayed++
+	}
+
+	message, _ := json.Marshal(resumedUpdate{Type: "resumed", Replayed: replayed})
+	req.conn.WriteMessage(websocket.TextMessage, message)
+}
@@ -0,0 +1,177 @@
+package nginxviz
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rotatedLogFiles returns the rotations of path that logrotate left next to
+// it, newest first: access.log.1, access.log.2.gz and so on. Only numbered
+// rotations are found, compressed or not.
+func rotatedLogFiles(path string) []string {
+	dir, base := filepath.Split(path)
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil
+	}
+
+	rotations := make(map[int]string)
+	for _, entry := range entries {
+		suffix, ok := strings.CutPrefix(entry.Name(), base+".")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		compressed := strings.HasSuffix(suffix, ".gz")
+		n, err := strconv.Atoi(strings.TrimSuffix(suffix, ".gz"))
+		if err != nil || n < 1 {
+			continue
+		}
+		// Between rotations logrotate may briefly leave both; the
+		// uncompressed one is complete
+		if _, ok := rotations[n]; ok && compressed {
+			continue
+		}
+		rotations[n] = filepath.Join(dir, entry.Name())
+	}
+
+	numbers := make([]int, 0, len(rotations))
+	for n := range rotations {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	files := make([]string, len(numbers))
+	for i, n := range numbers {
+		files[i] = rotations[n]
+	}
+	return files
+}
+
+// readLogFile passes every line of path to handle, decompressing files
+// ending in .gz.
+func readLogFile(ctx context.Context, path string, handle lineHandler) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return forEachLine(ctx, r, handle)
+}
+
+// forEachLine passes every line read from r to handle until r is exhausted,
+// handle fails or ctx is cancelled.
+func forEachLine(ctx context.Context, r io.Reader, handle lineHandler) error {
+	reader := bufio.NewReader(r)
+	for ctx.Err() == nil {
+		line, err := reader.ReadString('\n')
+		// A final line without a newline is still a line
+		if line != "" {
+			if err := handle(ctx, line); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// backfillRotated reads the part of the -backfill window that lies in the
+// rotations of path, oldest first, so they land before the lines of path
+// itself. lines is how many lines are still wanted, or zero when only
+// -backfill-since limits the window.
+func (s *Server) backfillRotated(ctx context.Context, path string, lines int) error {
+	limited := lines > 0
+	var cutoff time.Time
+	if s.cfg.BackfillSince > 0 {
+		cutoff = time.Now().Add(-s.cfg.BackfillSince)
+	}
+
+	// Walk back from the newest rotation until the window is filled,
+	// noting how many of each file's lines fall before it
+	type rotatedPart struct {
+		path string
+		skip int
+	}
+	var parts []rotatedPart
+	for _, rotated := range rotatedLogFiles(path) {
+		total, old, err := s.countOldLines(ctx, rotated, cutoff)
+		if err != nil {
+			return err
+		}
+		take := total - old
+		if limited {
+			take = min(take, lines)
+			lines -= take
+		}
+		parts = append(parts, rotatedPart{path: rotated, skip: total - take})
+		if take < total || limited && lines == 0 {
+			break
+		}
+	}
+
+	for i := len(parts) - 1; i >= 0; i-- {
+		part := parts[i]
+		slog.Info("Backfilling from rotated log file", "file", part.path, "skipped_lines", part.skip)
+		skip := part.skip
+		err := readLogFile(ctx, part.path, func(ctx context.Context, line string) error {
+			if skip > 0 {
+				skip--
+				return nil
+			}
+			return s.handleAccessLogLine(ctx, line)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countOldLines returns the number of lines in path and how many of them,
+// from the start, were logged before cutoff. Lines are assumed to be in
+// order, so parsing stops at the first one that is not.
+func (s *Server) countOldLines(ctx context.Context, path string, cutoff time.Time) (total, old int, err error) {
+	inWindow := cutoff.IsZero()
+	err = readLogFile(ctx, path, func(_ context.Context, line string) error {
+		total++
+		if inWindow {
+			return nil
+		}
+		entry, err := s.parser.Parse(stripSyslogHeader(strings.TrimSpace(line)))
+		if err != nil {
+			return nil
+		}
+		if entry.Timestamp.Before(cutoff) {
+			old = total
+		} else {
+			inWindow = true
+		}
+		return nil
+	})
+	return total, old, err
+}
@@ -0,0 +1,66 @@
+package nginxviz
+
+import (
+	"net/url"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+// querySecrets holds the lowercased names of query parameters whose values
+// are redacted from URLs and referers before they are broadcast or stored.
+type querySecrets map[string]bool
+
+func newQuerySecrets(params []string) querySecrets {
+	if len(params) == 0 {
+		return nil
+	}
+	secrets := make(querySecrets, len(params))
+	for _, param := range params {
+		secrets[strings.ToLower(param)] = true
+	}
+	return secrets
+}
+
+// Scrub redacts the values of secret parameters in rawURL's query string,
+// leaving the rest of the URL byte for byte as logged.
+func (q querySecrets) Scrub(rawURL string) string {
+	base, query, ok := strings.Cut(rawURL, "?")
+	if !ok || len(q) == 0 {
+		return rawURL
+	}
+	query, fragment, hasFragment := strings.Cut(query, "#")
+
+	params := strings.Split(query, "&")
+	changed := false
+	for i, param := range params {
+		key, value, _ := strings.Cut(param, "=")
+		if value == "" || value == redacted {
+			continue
+		}
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if q[strings.ToLower(name)] {
+			params[i] = key + "=" + redacted
+			changed = true
+		}
+	}
+	if !changed {
+		return rawURL
+	}
+
+	scrubbed := base + "?" + strings.Join(params, "&")
+	if hasFragment {
+		scrubbed += "#" + fragment
+	}
+	return scrubbed
+}
+
+// scrubEntry redacts secret query parameters from the entry's URL and
+// referer.
+func (q querySecrets) scrubEntry(entry *LogEntry) {
+	entry.URL = q.Scrub(entry.URL)
+	entry.Referer = q.Scrub(entry.Referer)
+}
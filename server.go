@@ -0,0 +1,1349 @@
+package nginxviz
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+//go:embed public
+var publicDir embed.FS
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type nginxVizPage struct {
+	CountryIcons map[string]string `json:"country_icons"`
+}
+
+// ipRecord is the part of a GeoIP record that is used. Country databases
+// only have the country; city databases add the rest.
+type ipRecord struct {
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Subdivisions []struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+
+	// From an ASN or ISP database
+	ASN          uint   `maxminddb:"autonomous_system_number"`
+	ASOrg        string `maxminddb:"autonomous_system_organization"`
+	ISP          string `maxminddb:"isp"`
+	Organization string `maxminddb:"organization"`
+}
+
+type LogEntry struct {
+	Timestamp           time.Time         `json:"timestamp"`
+	IP                  string            `json:"ip"`
+	Method              string            `json:"method"`
+	URL                 string            `json:"url"`
+	StatusCode          int               `json:"status_code"`
+	Size                int               `json:"size"`
+	UserAgent           string            `json:"user_agent"`
+	Referer             string            `json:"referer"`
+	RefererDomain       string            `json:"referer_domain,omitempty"`
+	RefererType         string            `json:"referer_type,omitempty"` // direct, internal, search, social or external
+	Country             string            `json:"country"`
+	CountryFull         string            `json:"country_full"`
+	City                string            `json:"city,omitempty"`      // with a city GeoIP database
+	Region              string            `json:"region,omitempty"`    // state or province, with a city GeoIP database
+	Latitude            float64           `json:"latitude,omitempty"`  // with a city GeoIP database
+	Longitude           float64           `json:"longitude,omitempty"` // with a city GeoIP database
+	ASN                 uint              `json:"asn,omitempty"`       // with an ASN database
+	ASOrg               string            `json:"as_org,omitempty"`    // with an ASN database
+	ISP                 string            `json:"isp,omitempty"`       // with an ISP database
+	Hosting             bool              `json:"hosting,omitempty"`   // the ASN belongs to a cloud or hosting provider
+	Hostname            string            `json:"hostname,omitempty"`
+	Rate                int               `json:"rate,omitempty"`
+	Suspicious          bool              `json:"suspicious,omitempty"`
+	RequestID           string            `json:"request_id,omitempty"`
+	CookieHeader        string            `json:"cookie_header,omitempty"` // only broadcast with BroadcastCookies
+	ResponseContentType string            `json:"response_content_type,omitempty"`
+	HoneypotHit         bool              `json:"honeypot_hit,omitempty"`
+	RateLimitStatus     string            `json:"rate_limit_status,omitempty"`      // $limit_req_status: PASSED, DELAYED or REJECTED
+	RequestTime         float64           `json:"request_time,omitempty"`           // $request_time in seconds
+	UpstreamTime        float64           `json:"upstream_response_time,omitempty"` // $upstream_response_time in seconds, summed over upstreams tried
+	Upstream            string            `json:"upstream,omitempty"`               // $upstream_addr, or the HAProxy backend
+	Host                string            `json:"host,omitempty"`                   // virtual host, $host in nginx
+	Source              string            `json:"source,omitempty"`                 // agent that forwarded the entry to a collector
+	Path                string            `json:"path,omitempty"`                   // decoded path, set with NormalizeURLs
+	Query               string            `json:"query,omitempty"`                  // query string without the ?, set with NormalizeURLs
+	NormalizedURL       string            `json:"normalized_url,omitempty"`         // path with IDs collapsed or stripped, set with NormalizeURLs
+	Protocol            string            `json:"protocol,omitempty"`               // HTTP version from the request line, e.g. HTTP/2.0
+	TLSProtocol         string            `json:"tls_protocol,omitempty"`           // $ssl_protocol, e.g. TLSv1.3
+	TLSCipher           string            `json:"tls_cipher,omitempty"`             // $ssl_cipher
+	BotScore            float64           `json:"bot_score"`
+	IsBot               bool              `json:"is_bot"`
+	IsWhitelistedBot    bool              `json:"is_whitelisted_bot,omitempty"`
+	Browser             string            `json:"browser,omitempty"`         // from the user agent, e.g. Chrome or Googlebot
+	BrowserVersion      string            `json:"browser_version,omitempty"` // major version
+	OS                  string            `json:"os,omitempty"`
+	DeviceType          string            `json:"device_type,omitempty"`      // desktop, mobile, tablet or bot
+	VerifiedCrawler     string            `json:"verified_crawler,omitempty"` // crawler confirmed by reverse and forward DNS, with RDNS
+	SpoofedCrawler      bool              `json:"spoofed_crawler,omitempty"`  // a crawler's user agent from outside its domains, with RDNS
+	ThreatList          string            `json:"threat_list,omitempty"`      // the ThreatLists list the IP is on with the highest score
+	ThreatScore         int               `json:"threat_score,omitempty"`     // 0 to 100, set with ThreatList
+	Tor                 bool              `json:"tor,omitempty"`              // from a Tor exit node, with Tor
+	VisitorID           string            `json:"visitor_id,omitempty"`       // salted hash of the IP and user agent, changing daily
+	SessionID           string            `json:"session_id,omitempty"`       // the visitor's requests up to SessionTimeout apart
+	AnomalyScore        float64           `json:"anomaly_score"`
+	IsAnomalous         bool              `json:"is_anomalous,omitempty"`
+	Extra               map[string]string `json:"extra,omitempty"`          // JSON log keys not mapped to a field
+	ParseWarnings       []string          `json:"parse_warnings,omitempty"` // problems with a partially parsed line
+}
+
+// Log types tell clients which log a message came from.
+const (
+	logTypeAccess = "access"
+	logTypeError  = "error"
+)
+
+type LogUpdate struct {
+	Type    string   `json:"type"`
+	LogType string   `json:"log_type"`
+	Seq     uint64   `json:"seq,omitempty"`
+	Data    LogEntry `json:"data"`
+}
+
+type clientInfo struct {
+	ConnectedAt time.Time
+	Admin       bool       // receives admin events in addition to the log stream
+	ClientID    string     // set by the client's hello message
+	Hosts       hostFilter // virtual hosts the client receives entries for, nil for all
+	Tor         torFilter  // whether the client receives entries from Tor exit nodes
+}
+
+type clientAction struct {
+	conn   *websocket.Conn
+	action string // "register" or "unregister"
+	admin  bool
+	hosts  hostFilter
+	tor    torFilter
+}
+
+// Config holds the options of a Server. The command line flags of
+// cmd/nginxviz map one to one onto its fields, and the yaml keys are those
+// accepted in a -config file.
+type Config struct {
+	LogFile           string        `yaml:"log_file"`       // nginx log file to watch, a glob matching several, - for stdin, journald:unit or ssh://host:path
+	ErrorLogFile      string        `yaml:"error_log_file"` // nginx error log broadcast as error_entry messages, optional
+	HistorySize       int           `yaml:"history_size"`   // recent log entries kept in memory
+	RDNS              bool          `yaml:"rdns"`           // resolve client IPs to hostnames
+	RDNSTTL           time.Duration `yaml:"rdns_ttl"`       // how long a resolved hostname is cached
+	RateThreshold     int           `yaml:"rate_threshold"` // requests per RateWindow after which an IP is suspicious, 0 disables
+	RateWindow        time.Duration `yaml:"rate_window"`    // sliding window used for per-IP rate detection
+	Format            string        `yaml:"format"`         // registered parser name or an nginx log_format
+	DetectFormat      bool          `yaml:"detect_format"`  // pick the format that parses the start of LogFile, falling back to Format
+	JSONKeys          string        `yaml:"json_keys"`      // comma separated field=key overrides for JSON logs
+	DBOut             string        `yaml:"db_out"`         // SQLite database every parsed request is written to
+	AuthUser          string        `yaml:"auth_user"`
+	AuthPass          string        `yaml:"auth_pass"`
+	AuthToken         string        `yaml:"auth_token"`
+	IngestToken       string        `yaml:"ingest_token"`        // shared secret enabling POST /api/ingest
+	TrustXFF          bool          `yaml:"trust_xff"`           // use the leftmost public X-Forwarded-For address as the client IP
+	MaxFieldSize      int           `yaml:"max_field_size"`      // truncate URL, user agent and referer to this many bytes, 0 disables
+	MaxLineSize       int           `yaml:"max_line_size"`       // skip longer log lines, 0 disables
+	RequestIDField    int           `yaml:"request_id_field"`    // 1-based column of $request_id in extended formats, 0 if not logged
+	CookieField       int           `yaml:"cookie_field"`        // 1-based column of $http_cookie in extended formats, 0 if not logged
+	ContentTypeField  int           `yaml:"content_type_field"`  // 1-based column of $sent_http_content_type in extended formats, 0 if not logged
+	RateLimitField    int           `yaml:"rate_limit_field"`    // 1-based column of $limit_req_status in extended formats, 0 if not logged
+	RequestTimeField  int           `yaml:"request_time_field"`  // 1-based column of $request_time in extended formats, 0 if not logged
+	UpstreamTimeField int           `yaml:"upstream_time_field"` // 1-based column of $upstream_response_time in extended formats, 0 if not logged
+	HostField         int           `yaml:"host_field"`          // 1-based column of $host in extended formats, 0 if not logged
+	SSLProtocolField  int           `yaml:"ssl_protocol_field"`  // 1-based column of $ssl_protocol in extended formats, 0 if not logged
+	SSLCipherField    int           `yaml:"ssl_cipher_field"`    // 1-based column of $ssl_cipher in extended formats, 0 if not logged
+	Debug             bool          `yaml:"debug"`               // send unparseable lines to clients as parse_error messages
+	PingInterval      time.Duration `yaml:"ping_interval"`
+	TrackSessions     bool          `yaml:"track_sessions"`
+	SessionCookie     string        `yaml:"session_cookie"`
+	BroadcastCookies  bool          `yaml:"broadcast_cookies"`
+	TraceEndpoint     string        `yaml:"trace_endpoint"` // Zipkin v2 collector URL
+	TraceSampleRate   float64       `yaml:"trace_sample_rate"`
+	GeoWorkers        int           `yaml:"geo_workers"`
+	GeoCacheSize      int           `yaml:"geo_cache_size"`     // GeoIP records of the most recent IPs kept in memory
+	IgnoreURLs        []string      `yaml:"ignore_urls"`        // URL patterns hidden from the visualization
+	HoneypotURLs      []string      `yaml:"honeypot_urls"`      // trap URLs whose requests raise an admin alert
+	BotWhitelistFile  string        `yaml:"bot_whitelist_file"` // JSON array of known good bots exempt from the bot score
+	AllowedOrigins    []string      `yaml:"allowed_origins"`    // origins allowed to make CORS requests
+	ExtractFields     ExtractRules  `yaml:"extract_fields"`     // values copied from each line into LogEntry.Extra
+	NormalizeURLs     string        `yaml:"normalize_urls"`     // split, collapse or strip; empty leaves URLs alone
+	ScrubQueryParams  []string      `yaml:"scrub_query_params"` // query parameters whose values are redacted from URLs and referers
+
+	AdminAllowedCIDRs []string `yaml:"admin_allowed_cidrs"` // source networks allowed to reach /api/admin, empty allows all
+	NoLoopbackAdmin   bool     `yaml:"no_loopback_admin"`   // do not implicitly allow loopback addresses with AdminAllowedCIDRs
+
+	RateLimitAlertThreshold float64       `yaml:"rate_limit_alert_threshold"` // fraction of rate-limited requests per minute that raises an alert
+	LagAlertThreshold       time.Duration `yaml:"lag_alert_threshold"`        // processing lag that raises a lag_update, 0 disables
+	AnomalyThreshold        float64       `yaml:"anomaly_threshold"`          // anomaly score above which entries are flagged is_anomalous
+
+	DockerContainer string `yaml:"docker_container"` // read this container's stdout as the access log and stderr as the error log instead of LogFile
+	DockerSocket    string `yaml:"docker_socket"`    // unix socket of the Docker Engine API
+
+	ListenSyslog  string `yaml:"listen_syslog"`  // address receiving syslog messages over UDP and TCP instead of reading LogFile
+	ListenForward string `yaml:"listen_forward"` // address receiving events over the Fluentd forward protocol instead of reading LogFile
+
+	KafkaBrokers string `yaml:"kafka_brokers"` // comma separated brokers of KafkaTopic
+	KafkaTopic   string `yaml:"kafka_topic"`   // topic whose messages are read as log lines instead of LogFile
+	KafkaGroup   string `yaml:"kafka_group"`   // consumer group committing the offsets read, none when empty
+
+	RedisURL    string `yaml:"redis_url"`    // redis:// or rediss:// URL of the server holding RedisStream
+	RedisStream string `yaml:"redis_stream"` // stream whose entries are read instead of LogFile
+
+	Bucket         string        `yaml:"bucket"`          // s3:// or gs:// prefix whose objects are read as they are delivered instead of LogFile
+	BucketEndpoint string        `yaml:"bucket_endpoint"` // URL of an S3 compatible store holding Bucket, the provider's when empty
+	BucketInterval time.Duration `yaml:"bucket_interval"` // how often Bucket is listed for new objects
+
+	Collector   bool   `yaml:"collector"`    // receive entries from agents on /api/collect instead of reading LogFile, requires IngestToken
+	Agent       string `yaml:"agent"`        // URL of a collector that entries are forwarded to, with IngestToken, instead of being broadcast
+	AgentSource string `yaml:"agent_source"` // label of this agent's entries at the collector, the hostname when empty
+
+	KubeSelector  string `yaml:"kube_selector"`  // label selector of the pods whose logs are read instead of LogFile
+	KubeNamespace string `yaml:"kube_namespace"` // namespace of the pods, the service account's own when empty
+	KubeContainer string `yaml:"kube_container"` // container of the pods to read, needed when they have several
+	KubeAPI       string `yaml:"kube_api"`       // API server URL such as kubectl proxy's, the in-cluster one when empty
+
+	GeoIPFile       string        `yaml:"geoip_file"`        // MaxMind or DB-IP database used instead of the embedded one, reloaded when it changes
+	GeoIPUpdate     string        `yaml:"geoip_update"`      // maxmind, dbip or a URL the country database is downloaded from, none when empty
+	GeoIPRefresh    time.Duration `yaml:"geoip_refresh"`     // how often GeoIPUpdate is downloaded
+	GeoIPAccountID  string        `yaml:"geoip_account_id"`  // MaxMind account ID
+	GeoIPLicenseKey string        `yaml:"geoip_license_key"` // MaxMind license key
+
+	ThreatLists   []string      `yaml:"threat_lists"`   // files or URLs of IP blocklists whose entries are tagged with threat_list
+	ThreatRefresh time.Duration `yaml:"threat_refresh"` // how often ThreatLists are reloaded
+
+	SessionTimeout time.Duration `yaml:"session_timeout"` // idle time after which a visitor's next request starts a new session, 0 disables visitor IDs
+
+	InternalDomains []string `yaml:"internal_domains"` // domains of the site whose referers are internal, besides each entry's host
+
+	Tor         bool          `yaml:"tor"`           // tag entries from Tor exit nodes
+	TorExitList string        `yaml:"tor_exit_list"` // file or URL listing the exit node addresses, one per line
+	TorRefresh  time.Duration `yaml:"tor_refresh"`   // how often TorExitList is reloaded
+
+	ASNFile string `yaml:"asn_file"` // GeoLite2-ASN, DB-IP ASN Lite or GeoIP2-ISP database adding the network of each address
+
+	IncidentWindow time.Duration `yaml:"incident_window"` // how far apart a 5xx entry and its error log lines may be to form an incident, 0 disables
+
+	Demo     bool    `yaml:"demo"`      // generate fake traffic instead of reading LogFile
+	DemoRate float64 `yaml:"demo_rate"` // average requests a second Demo generates
+
+	Replay      bool        `yaml:"replay"`       // play LogFile back paced by its timestamps instead of following it
+	ReplaySpeed ReplaySpeed `yaml:"replay_speed"` // how many times faster than it happened Replay plays
+	ReplayStart time.Time   `yaml:"replay_start"` // first time Replay plays, the start of the file when zero
+	ReplayEnd   time.Time   `yaml:"replay_end"`   // last time Replay plays, the end of the file when zero
+
+	PositionFile string `yaml:"position_file"` // state kept across restarts, such as log file positions and the learned URL patterns
+
+	FromEnd        bool          `yaml:"from_end"`        // start following log files at their end, skipping what is already there
+	Backfill       int           `yaml:"backfill"`        // read only the last lines of the log on startup, 0 reads it all
+	BackfillSince  time.Duration `yaml:"backfill_since"`  // read only lines logged within this long on startup, 0 reads them all
+	BackfillReplay int           `yaml:"backfill_replay"` // recent entries sent to each new client in one backfill message, 0 disables
+
+	SnapshotFile     string        `yaml:"snapshot_file"` // JSON lines file that /api/stats snapshots are appended to
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"`
+	SnapshotMaxSize  ByteSize      `yaml:"snapshot_max_size"` // rotate the snapshot file beyond this size, 0 disables
+
+	ParseErrorSummaryInterval time.Duration `yaml:"parse_error_summary_interval"` // how often clients get a parse_error_summary, 0 disables
+
+	// ConfigFile is watched for changes to the hot-reloadable options when
+	// set. Other changes are reported at /api/admin/config/pending-restart.
+	ConfigFile          string        `yaml:"-"`
+	ConfigWatchInterval time.Duration `yaml:"-"`
+	// FlagKeys are the config file keys also given as command-line flags,
+	// which keep precedence over the file when it is reloaded.
+	FlagKeys []string `yaml:"-"`
+}
+
+// DefaultConfig returns the configuration used when no flags are given.
+func DefaultConfig() Config {
+	return Config{
+		LogFile:          "mylog.log",
+		DockerSocket:     defaultDockerSocket,
+		HistorySize:      1000,
+		RateThreshold:    100,
+		RateWindow:       10 * time.Second,
+		RedisURL:         "redis://localhost:6379",
+		BucketInterval:   time.Minute,
+		ReplaySpeed:      1,
+		DemoRate:         5,
+		IncidentWindow:   5 * time.Second,
+		GeoIPRefresh:     24 * time.Hour,
+		RDNSTTL:          time.Hour,
+		ThreatRefresh:    time.Hour,
+		TorExitList:      torExitListURL,
+		TorRefresh:       30 * time.Minute,
+		SessionTimeout:   30 * time.Minute,
+		Format:           formatCombined,
+		MaxFieldSize:     512,
+		MaxLineSize:      8192,
+		PingInterval:     30 * time.Second,
+		SessionCookie:    "PHPSESSID",
+		TraceSampleRate:  0.01,
+		GeoWorkers:       4,
+		GeoCacheSize:     10000,
+		AllowedOrigins:   []string{"http://localhost:3000", "https://codercatclub.github.io", "https://codercat.tk", "https://codercat.xyz"},
+		ScrubQueryParams: []string{"access_token", "api_key", "apikey", "auth", "key", "password", "secret", "token"},
+
+		RateLimitAlertThreshold:   0.1,
+		LagAlertThreshold:         30 * time.Second,
+		AnomalyThreshold:          0.7,
+		SnapshotInterval:          time.Minute,
+		ParseErrorSummaryInterval: time.Minute,
+		SnapshotMaxSize:           100 << 20,
+		ConfigWatchInterval:       5 * time.Second,
+	}
+}
+
+// Server follows an nginx access log and streams enriched entries to
+// websocket clients. Its Handler can be mounted into a larger application:
+//
+//	mainMux.PathPrefix("/viz").Handler(http.StripPrefix("/viz", vizServer.Handler()))
+type Server struct {
+	cfg    Config
+	db     atomic.Pointer[maxminddb.Reader] // replaced when GeoIPFile changes
+	asnDB  *maxminddb.Reader                // nil without ASNFile
+	router *mux.Router
+
+	// ctx lives until the server stops. Background work started by
+	// handlers, such as replays, is bound to it rather than to the request.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	upgrader      websocket.Upgrader
+	clients       map[*websocket.Conn]*clientInfo
+	clientsMu     sync.RWMutex
+	clientActions chan clientAction
+
+	entries             chan LogEntry
+	replays             chan LogEntry
+	resumeRequests      chan resumeRequest
+	backfillRequests    chan backfillRequest
+	events              chan any
+	adminEvents         chan any
+	parseErrorFeed      chan ParseError // nil unless Debug is set
+	parseErrorFeedLimit *parseErrorLimiter
+
+	parser         Parser
+	detected       *FormatDetection // nil unless DetectFormat is set
+	extractors     []fieldExtractor
+	querySecrets   querySecrets
+	reloadMu       sync.RWMutex // guards the hot-reloadable dropRules, extractors, honeypots and allowedOrigins
+	dropRules      []FilterRule
+	allowedOrigins []string
+	adminAllowList *CIDRAllowList // nil unless AdminAllowedCIDRs is set
+	configWatcher  *ConfigWatcher // nil unless ConfigFile is set
+	honeypots      honeypotSet
+	botWhitelist   []WhitelistedBot
+	classifier     *TrafficClassifier
+	tailers        *tailerGroup
+	inputs         sync.WaitGroup  // input goroutines, waited for so commands they run are stopped
+	forwarder      *entryForwarder // nil unless Agent is set
+	offsets        *fileOffsets    // nil unless PositionFile is set
+	objects        *bucketObjects  // nil unless Bucket is set
+	geoPool        *GeoIPWorkerPool
+	geoCache       *geoIPCache
+	resolver       *hostnameResolver // nil unless RDNS is set
+	threats        *threatIntel      // nil without ThreatLists
+	torExits       *threatIntel      // nil unless Tor is set
+	visitors       *visitorTracker   // nil when SessionTimeout is 0
+	rates          *rateTracker      // nil when rate detection is disabled
+
+	history        *entryHistory
+	store          *requestStore         // nil unless DBOut is set
+	tracer         *TraceExporter        // nil unless TraceEndpoint is set
+	snapshots      *SnapshotWriter       // nil unless SnapshotFile is set
+	sessions       *StickySessionTracker // nil unless TrackSessions is set
+	correlations   *RequestIDCorrelationStore
+	incidents      *IncidentCorrelator // nil when IncidentWindow is 0
+	geoIPUpdater   *geoIPUpdater       // nil unless GeoIPUpdate is set
+	patterns       *PatternLearner
+	blockList      *BlockList
+	resumeClients  *resumeCache
+	parseErrors    *LastErrorsCache
+	connectionAges *connectionAgeHistogram
+	contentTypes   *contentTypeBreakdown
+	protocols      *protocolBreakdown
+	networks       *networkBreakdown
+	browsers       *browserBreakdown
+	referers       *refererBreakdown
+	counters       *statsCounters
+	rateLimits     *rateLimitMonitor
+	lag            *LagGauge
+
+	syslog  *syslogListener  // nil unless ListenSyslog is set
+	forward *forwardListener // nil unless ListenForward is set
+	bucket  *bucketClient    // nil unless Bucket is set
+
+	replayRunning      atomic.Bool // guards against more than one replay at a time
+	cacheWarmupRunning atomic.Bool
+}
+
+// New validates cfg and builds a Server. Call Run to start following the log.
+func New(cfg Config) (*Server, error) {
+	if cfg.GeoWorkers < 1 {
+		return nil, fmt.Errorf("invalid geo workers: must be at least 1")
+	}
+	if cfg.GeoCacheSize < 1 {
+		return nil, fmt.Errorf("invalid geo cache size: must be at least 1")
+	}
+	if cfg.PingInterval <= 0 {
+		return nil, fmt.Errorf("invalid ping interval: must be positive")
+	}
+	if cfg.SnapshotFile != "" && cfg.SnapshotInterval <= 0 {
+		return nil, fmt.Errorf("invalid snapshot interval: must be positive")
+	}
+	if cfg.SessionTimeout < 0 {
+		return nil, fmt.Errorf("invalid session timeout: must not be negative")
+	}
+	if cfg.RDNS && cfg.RDNSTTL <= 0 {
+		return nil, fmt.Errorf("invalid rdns ttl: must be positive")
+	}
+	if cfg.AnomalyThreshold < 0 || cfg.AnomalyThreshold > 1 {
+		return nil, fmt.Errorf("invalid anomaly threshold: must be between 0 and 1")
+	}
+	if cfg.LogFile == stdinLogFile && cfg.ErrorLogFile == stdinLogFile {
+		return nil, fmt.Errorf("invalid error log: the access log already reads from stdin")
+	}
+	for _, path := range []string{cfg.LogFile, cfg.ErrorLogFile} {
+		if unit, ok := journalUnit(path); ok {
+			if unit == "" {
+				return nil, fmt.Errorf("invalid log file %q: missing systemd unit", path)
+			}
+			if _, err := exec.LookPath("journalctl"); err != nil {
+				return nil, fmt.Errorf("invalid log file %q: %w", path, err)
+			}
+			continue
+		}
+		if _, ok, err := parseSSHLogFile(path); ok {
+			if err != nil {
+				return nil, fmt.Errorf("invalid log file %q: %w", path, err)
+			}
+			if _, err := exec.LookPath("ssh"); err != nil {
+				return nil, fmt.Errorf("invalid log file %q: %w", path, err)
+			}
+			continue
+		}
+		if _, err := filepath.Match(path, ""); err != nil {
+			return nil, fmt.Errorf("invalid log file pattern %q: %w", path, err)
+		}
+	}
+	inputs := 0
+	for _, set := range []bool{cfg.DockerContainer != "", cfg.KubeSelector != "", cfg.ListenSyslog != "", cfg.ListenForward != "", cfg.KafkaTopic != "", cfg.RedisStream != "", cfg.Bucket != "", cfg.Collector, cfg.Replay, cfg.Demo} {
+		if set {
+			inputs++
+		}
+	}
+	if inputs > 1 {
+		return nil, fmt.Errorf("invalid input: only one of docker_container, kube_selector, listen_syslog, listen_forward, kafka_topic, redis_stream, bucket, collector, replay and demo can be set")
+	}
+	if cfg.KafkaTopic != "" {
+		if cfg.KafkaBrokers == "" {
+			return nil, fmt.Errorf("invalid kafka brokers: required with kafka_topic")
+		}
+		if _, err := kafkaConsumer(); err != nil {
+			return nil, fmt.Errorf("invalid kafka topic: kcat is needed to consume it: %w", err)
+		}
+	}
+	var geoIPUpdater *geoIPUpdater
+	if cfg.GeoIPUpdate != "" {
+		var err error
+		if geoIPUpdater, err = newGeoIPUpdater(cfg); err != nil {
+			return nil, fmt.Errorf("invalid geoip update: %w", err)
+		}
+		if cfg.GeoIPRefresh <= 0 {
+			return nil, fmt.Errorf("invalid geoip refresh: must be positive")
+		}
+	}
+	if cfg.Demo && cfg.DemoRate <= 0 {
+		return nil, fmt.Errorf("invalid demo rate: must be positive")
+	}
+	if cfg.Replay {
+		if cfg.LogFile == stdinLogFile || isLogGlob(cfg.LogFile) || strings.Contains(cfg.LogFile, "://") || strings.HasPrefix(cfg.LogFile, journalPrefix) {
+			return nil, fmt.Errorf("invalid replay: log file %q must be a single file", cfg.LogFile)
+		}
+		if cfg.ReplaySpeed <= 0 {
+			return nil, fmt.Errorf("invalid replay speed: must be positive")
+		}
+		if !cfg.ReplayEnd.IsZero() && cfg.ReplayEnd.Before(cfg.ReplayStart) {
+			return nil, fmt.Errorf("invalid replay end: before replay start")
+		}
+	}
+	var bucket *bucketClient
+	if cfg.Bucket != "" {
+		var err error
+		if bucket, err = newBucketClient(cfg.Bucket, cfg.BucketEndpoint); err != nil {
+			return nil, fmt.Errorf("invalid bucket %q: %w", cfg.Bucket, err)
+		}
+		if cfg.BucketInterval <= 0 {
+			return nil, fmt.Errorf("invalid bucket interval: must be positive")
+		}
+	}
+	if cfg.RedisStream != "" {
+		u, err := url.Parse(cfg.RedisURL)
+		if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") {
+			return nil, fmt.Errorf("invalid redis url %q: must be redis://host:port or rediss://host:port", cfg.RedisURL)
+		}
+	}
+	if (cfg.Collector || cfg.Agent != "") && cfg.IngestToken == "" {
+		return nil, fmt.Errorf("invalid ingest token: required by collector and agent")
+	}
+	if cfg.Collector && cfg.Agent != "" {
+		return nil, fmt.Errorf("invalid agent: a collector cannot forward to another one")
+	}
+	if cfg.Backfill < 0 || cfg.BackfillSince < 0 || cfg.BackfillReplay < 0 {
+		return nil, fmt.Errorf("invalid backfill: must not be negative")
+	}
+	if cfg.FromEnd && (cfg.Backfill > 0 || cfg.BackfillSince > 0) {
+		return nil, fmt.Errorf("invalid backfill: cannot be combined with from_end")
+	}
+	if err := validNormalizeMode(cfg.NormalizeURLs); err != nil {
+		return nil, err
+	}
+	if (cfg.AuthUser == "") != (cfg.AuthPass == "") {
+		return nil, fmt.Errorf("invalid auth: user and password must be set together")
+	}
+
+	var formatDetection *FormatDetection
+	if cfg.DetectFormat {
+		detection := detectLogFormat(cfg)
+		formatDetection = &detection
+		cfg.Format = detection.Format
+	}
+
+	parser, err := newParser(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var botWhitelist []WhitelistedBot
+	if cfg.BotWhitelistFile != "" {
+		botWhitelist, err = LoadBotWhitelist(cfg.BotWhitelistFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading bot whitelist: %w", err)
+		}
+	}
+
+	dropRules, err := compileFilterRules(cfg.IgnoreURLs)
+	if err != nil {
+		return nil, err
+	}
+
+	extractors, err := compileExtractRules(cfg.ExtractFields)
+	if err != nil {
+		return nil, err
+	}
+
+	var adminAllowList *CIDRAllowList
+	if len(cfg.AdminAllowedCIDRs) > 0 {
+		adminAllowList, err = NewCIDRAllowList(cfg.AdminAllowedCIDRs, cfg.NoLoopbackAdmin)
+		if err != nil {
+			return nil, fmt.Errorf("parsing admin allowed CIDRs: %w", err)
+		}
+	}
+
+	//read all SVG icons and store them in an array.
+
+	svgIconMap := make(map[string]string)
+
+	svgIconPaths, err := publicDir.ReadDir("public/assets/textures/1x1")
+	if err != nil {
+		return nil, fmt.Errorf("reading SVG icons: %w", err)
+	}
+
+	for _, svgIconFile := range svgIconPaths {
+		svgText, err := publicDir.ReadFile("public/assets/textures/1x1/" + svgIconFile.Name())
+		if err != nil {
+			slog.Error("Error reading SVG file", "file", svgIconFile.Name(), "err", err)
+			continue
+		}
+		svgIconMap[svgIconFile.Name()] = string(svgText)
+	}
+
+	// Use the embedded IP -> Country mapping unless a newer one is given,
+	// or until GeoIPUpdate has downloaded it
+	var db *maxminddb.Reader
+	if cfg.GeoIPFile != "" {
+		db, err = openGeoIPFile(cfg.GeoIPFile)
+		if errors.Is(err, os.ErrNotExist) && geoIPUpdater != nil {
+			slog.Info("GeoIP database not downloaded yet, using the embedded one", "file", cfg.GeoIPFile)
+			db, err = nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("opening GeoIP database %q: %w", cfg.GeoIPFile, err)
+		}
+	}
+	if db == nil {
+		dbFile, err := publicDir.ReadFile("public/assets/libs/dbip-country-lite-2023-06.mmdb")
+		if err != nil {
+			return nil, fmt.Errorf("reading GeoIP database: %w", err)
+		}
+		db, err = maxminddb.OpenBytes(dbFile)
+		if err != nil {
+			return nil, fmt.Errorf("opening GeoIP database: %w", err)
+		}
+	}
+
+	var threats *threatIntel
+	if len(cfg.ThreatLists) > 0 {
+		if cfg.ThreatRefresh <= 0 {
+			db.Close()
+			return nil, fmt.Errorf("invalid threat refresh: must be positive")
+		}
+		threats, err = newThreatIntel(cfg.ThreatLists)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("loading threat list: %w", err)
+		}
+	}
+
+	var torExits *threatIntel
+	if cfg.Tor {
+		if cfg.TorRefresh <= 0 {
+			db.Close()
+			return nil, fmt.Errorf("invalid tor refresh: must be positive")
+		}
+		torExits, err = newTorExitNodes(cfg.TorExitList)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("loading Tor exit nodes: %w", err)
+		}
+	}
+
+	var asnDB *maxminddb.Reader
+	if cfg.ASNFile != "" {
+		asnDB, err = openGeoIPFile(cfg.ASNFile)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("opening ASN database %q: %w", cfg.ASNFile, err)
+		}
+	}
+
+	s := &Server{
+		cfg: cfg,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				return true // Allow connections from any origin
+			},
+		},
+		clients:             make(map[*websocket.Conn]*clientInfo),
+		clientActions:       make(chan clientAction),
+		entries:             make(chan LogEntry),
+		replays:             make(chan LogEntry),
+		resumeRequests:      make(chan resumeRequest),
+		backfillRequests:    make(chan backfillRequest),
+		events:              make(chan any, 16),
+		adminEvents:         make(chan any, 16),
+		parseErrorFeedLimit: &parseErrorLimiter{},
+		parser:              parser,
+		dropRules:           dropRules,
+		detected:            formatDetection,
+		extractors:          extractors,
+		querySecrets:        newQuerySecrets(cfg.ScrubQueryParams),
+		tailers:             newTailerGroup(),
+		allowedOrigins:      cfg.AllowedOrigins,
+		adminAllowList:      adminAllowList,
+		honeypots:           newHoneypotSet(cfg.HoneypotURLs),
+		botWhitelist:        botWhitelist,
+		geoCache:            newGeoIPCache(cfg.GeoCacheSize),
+		history:             newEntryHistory(cfg.HistorySize),
+		correlations:        NewRequestIDCorrelationStore(),
+		incidents:           NewIncidentCorrelator(cfg.IncidentWindow),
+		patterns:            NewPatternLearner(),
+		blockList:           NewBlockList(),
+		resumeClients:       newResumeCache(),
+		parseErrors:         &LastErrorsCache{},
+		connectionAges:      &connectionAgeHistogram{},
+		contentTypes:        newContentTypeBreakdown(),
+		protocols:           newProtocolBreakdown(),
+		networks:            newNetworkBreakdown(),
+		browsers:            newBrowserBreakdown(),
+		referers:            newRefererBreakdown(),
+		counters:            &statsCounters{},
+		rateLimits:          newRateLimitMonitor(cfg.RateLimitAlertThreshold),
+		lag:                 NewLagGauge(cfg.LagAlertThreshold),
+		classifier:          NewTrafficClassifier(cfg.AnomalyThreshold),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.db.Store(db)
+	s.asnDB = asnDB
+	s.threats = threats
+	s.torExits = torExits
+	if cfg.SessionTimeout > 0 {
+		s.visitors = newVisitorTracker(cfg.SessionTimeout)
+	}
+	s.geoIPUpdater = geoIPUpdater
+	s.geoPool = NewGeoIPWorkerPool(s.enrichLogEntry)
+
+	if cfg.Debug {
+		s.parseErrorFeed = make(chan ParseError, 100)
+	}
+	if cfg.RDNS {
+		s.resolver = newHostnameResolver(cfg.RDNSTTL)
+	}
+	if cfg.RateThreshold > 0 && cfg.RateWindow > 0 {
+		s.rates = newRateTracker(cfg.RateThreshold, cfg.RateWindow)
+	}
+	if cfg.TrackSessions {
+		s.sessions = NewStickySessionTracker(cfg.SessionCookie)
+	}
+	if cfg.TraceEndpoint != "" {
+		s.tracer = NewTraceExporter(cfg.TraceEndpoint, cfg.TraceSampleRate)
+	}
+	var position positionState
+	if cfg.PositionFile != "" {
+		position, err = loadPositionFile(cfg.PositionFile)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("reading position file: %w", err)
+		}
+		s.patterns.restore(position.LearnedPatterns)
+		s.offsets = newFileOffsets(position.Files)
+	}
+	if bucket != nil {
+		s.bucket = bucket
+		s.objects = newBucketObjects(position.Objects)
+	}
+	if cfg.SnapshotFile != "" {
+		s.snapshots, err = NewSnapshotWriter(cfg.SnapshotFile, cfg.SnapshotInterval, cfg.SnapshotMaxSize, s.counters)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("opening snapshot file: %w", err)
+		}
+	}
+	if cfg.DBOut != "" {
+		s.store, err = openRequestStore(cfg.DBOut)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("opening request store: %w", err)
+		}
+	}
+
+	if cfg.Agent != "" {
+		source := cfg.AgentSource
+		if source == "" {
+			source, _ = os.Hostname()
+		}
+		collectorURL, err := collectURL(cfg.Agent, source)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("invalid agent collector URL: %w", err)
+		}
+		s.forwarder = newEntryForwarder(collectorURL, cfg.IngestToken, cfg.PingInterval)
+	}
+
+	if cfg.ConfigFile != "" {
+		s.configWatcher, err = newConfigWatcher(s, cfg.ConfigFile, cfg.ConfigWatchInterval)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("watching config file: %w", err)
+		}
+	}
+
+	// Listeners are bound last, so nothing is left open when New fails, and
+	// an address in use is reported before the server starts
+	if cfg.ListenSyslog != "" {
+		if s.syslog, err = listenSyslog(cfg.ListenSyslog); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("listening for syslog: %w", err)
+		}
+		slog.Info("Listening for syslog messages", "addr", cfg.ListenSyslog)
+	}
+	if cfg.ListenForward != "" {
+		if s.forward, err = listenForward(cfg.ListenForward); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("listening for forward protocol: %w", err)
+		}
+		slog.Info("Listening for Fluentd forward protocol", "addr", cfg.ListenForward)
+	}
+
+	s.router = s.routes(svgIconMap)
+	return s, nil
+}
+
+func (s *Server) routes(svgIconMap map[string]string) *mux.Router {
+	auth := authMiddleware(authConfig{User: s.cfg.AuthUser, Pass: s.cfg.AuthPass, Token: s.cfg.AuthToken})
+
+	r := mux.NewRouter()
+	r.Handle("/", auth(MakeNginxVizHandler(svgIconMap))).Methods("GET")
+	r.Handle("/ws", auth(s.MakeWebSocketHandler(false))).Methods("GET")
+	var adminWS http.Handler = auth(s.MakeWebSocketHandler(true))
+	if s.adminAllowList != nil {
+		adminWS = s.adminAllowList.Middleware(adminWS)
+	}
+	r.Handle("/ws/admin", adminWS).Methods("GET")
+	if s.cfg.Debug {
+		r.Handle("/debug/ws", auth(s.MakeDebugWebSocketHandler())).Methods("GET")
+	}
+	r.PathPrefix("/public/").Handler(customFileServer(http.FS(publicDir))).Methods("GET")
+
+	// Admin routes are registered ahead of the rest of the API so the
+	// address check runs before authentication.
+	admin := r.PathPrefix("/api/admin").Subrouter()
+	if s.adminAllowList != nil {
+		admin.Use(s.adminAllowList.Middleware)
+	}
+	admin.Use(auth)
+	admin.HandleFunc("/block-ip", MakeBlockIPHandler(s.blockList)).Methods("POST")
+	admin.HandleFunc("/block-ip/{ip}", MakeUnblockIPHandler(s.blockList)).Methods("DELETE")
+	admin.HandleFunc("/block-list", MakeBlockListHandler(s.blockList)).Methods("GET")
+	admin.HandleFunc("/warm-cache", s.MakeWarmCacheHandler()).Methods("POST")
+	admin.HandleFunc("/config/pending-restart", s.MakePendingRestartHandler()).Methods("GET")
+	admin.HandleFunc("/replay", s.MakeReplayHandler()).Methods("POST")
+
+	// Pushing entries takes its own token, so shippers need no dashboard
+	// credentials
+	if s.cfg.IngestToken != "" {
+		ingestAuth := authMiddleware(authConfig{Token: s.cfg.IngestToken})
+		r.Handle("/api/ingest", ingestAuth(s.MakeIngestHandler())).Methods("POST")
+		if s.cfg.Collector {
+			r.Handle("/api/collect", ingestAuth(s.MakeCollectHandler())).Methods("GET")
+		}
+	}
+
+	api := r.PathPrefix("/api").Subrouter()
+	api.Use(auth)
+	api.HandleFunc("/ip-overview/{ip}", s.MakeIPOverviewHandler()).Methods("GET")
+	api.HandleFunc("/recent", s.MakeRecentHandler()).Methods("GET")
+	api.HandleFunc("/status", s.MakeStatusHandler()).Methods("GET")
+	api.HandleFunc("/summary/markdown", s.MakeMarkdownSummaryHandler()).Methods("GET")
+	api.HandleFunc("/diagnostics/parse-errors", MakeParseErrorsHandler(s.parseErrors)).Methods("GET")
+	api.HandleFunc("/parse-errors", MakeParseErrorsHandler(s.parseErrors)).Methods("GET")
+	api.HandleFunc("/export", MakeExportHandler(s.store)).Methods("GET")
+	api.HandleFunc("/stats", MakeStatsHandler(s.counters)).Methods("GET")
+	api.HandleFunc("/stats/connection-ages", MakeConnectionAgesHandler(s.connectionAges)).Methods("GET")
+	api.HandleFunc("/stats/content-types", MakeContentTypesHandler(s.contentTypes)).Methods("GET")
+	api.HandleFunc("/stats/protocols", MakeProtocolsHandler(s.protocols)).Methods("GET")
+	api.HandleFunc("/stats/networks", MakeNetworksHandler(s.networks)).Methods("GET")
+	api.HandleFunc("/stats/browsers", MakeBrowsersHandler(s.browsers)).Methods("GET")
+	api.HandleFunc("/stats/referers", MakeReferersHandler(s.referers)).Methods("GET")
+	api.HandleFunc("/stats/visitors", MakeVisitorsHandler(s.visitors)).Methods("GET")
+	api.HandleFunc("/stats/geo-cache", MakeGeoCacheHandler(s.geoCache)).Methods("GET")
+	api.HandleFunc("/learned-patterns", MakeLearnedPatternsHandler(s.patterns)).Methods("GET")
+
+	r.Use(s.corsMiddleware)
+	return r
+}
+
+// Handler returns the router serving the dashboard, websockets and API.
+func (s *Server) Handler() http.Handler {
+	return s.router
+}
+
+// Run follows the log file and broadcasts its entries until ctx is
+// cancelled or Close is called. Queued entries are flushed to the request
+// store before it returns.
+func (s *Server) Run(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, s.cancel)
+	defer stop()
+	ctx = s.ctx
+
+	if s.resolver != nil {
+		s.resolver.Start(ctx)
+	}
+
+	storeDone := make(chan struct{})
+	if s.store != nil {
+		go func() {
+			defer close(storeDone)
+			s.store.Run(ctx)
+		}()
+	} else {
+		close(storeDone)
+	}
+
+	if s.tracer != nil {
+		go s.tracer.Run(ctx)
+	}
+
+	if s.snapshots != nil {
+		go s.snapshots.Run(ctx)
+	}
+
+	if s.forwarder != nil {
+		go s.forwarder.Run(ctx)
+	}
+
+	if s.cfg.PositionFile != "" {
+		go s.persistPosition(ctx)
+	}
+
+	if s.cfg.GeoIPFile != "" {
+		go s.watchGeoIPFile(ctx)
+	}
+	if s.geoIPUpdater != nil {
+		go s.updateGeoIP(ctx, s.geoIPUpdater)
+	}
+	if s.threats != nil {
+		go s.threats.Run(ctx, s.cfg.ThreatRefresh)
+	}
+	if s.torExits != nil {
+		go s.torExits.Run(ctx, s.cfg.TorRefresh)
+	}
+
+	if s.configWatcher != nil {
+		go s.configWatcher.Run(ctx)
+	}
+
+	if s.cfg.ParseErrorSummaryInterval > 0 {
+		go s.summarizeParseErrors(ctx, s.cfg.ParseErrorSummaryInterval)
+	}
+
+	go s.counters.Peaks.Run(ctx)
+	s.geoPool.Start(ctx, s.cfg.GeoWorkers)
+
+	accessTailer := Tailer{HandleLine: s.handleAccessLogLine, Seek: s.backfillSeek}
+	errorTailer := Tailer{HandleLine: s.handleErrorLogLine}
+	if s.cfg.FromEnd {
+		accessTailer.Seek = seekEnd
+		errorTailer.Seek = seekEnd
+	}
+	if s.offsets != nil {
+		accessTailer.Seek = s.resumeSeek(accessTailer.HandleLine, accessTailer.Seek)
+		errorTailer.Seek = s.resumeSeek(errorTailer.HandleLine, errorTailer.Seek)
+		accessTailer.Offsets = s.offsets
+		errorTailer.Offsets = s.offsets
+	}
+	switch {
+	case s.cfg.DockerContainer != "":
+		s.goInput(func() { s.readDocker(ctx, s.cfg.DockerContainer) })
+	case s.cfg.KubeSelector != "":
+		s.goInput(func() { s.watchKubePods(ctx) })
+	case s.syslog != nil:
+		s.goInput(func() { s.syslog.Serve(ctx, s.handleSyslogMessage) })
+	case s.forward != nil:
+		s.goInput(func() { s.forward.Serve(ctx, s.handleForwardEvent) })
+	case s.cfg.KafkaTopic != "":
+		s.goInput(func() { s.readKafka(ctx) })
+	case s.cfg.RedisStream != "":
+		s.goInput(func() { s.readRedis(ctx) })
+	case s.bucket != nil:
+		s.goInput(func() { s.readBucket(ctx, s.bucket) })
+	case s.cfg.Collector:
+		// Entries arrive on /api/collect
+	case s.cfg.Replay:
+		s.goInput(func() { s.replayInput(ctx) })
+	case s.cfg.Demo:
+		s.goInput(func() { s.readDemo(ctx) })
+	default:
+		s.goInput(func() { s.watchLogPath(ctx, s.cfg.LogFile, accessTailer) })
+		if s.cfg.ErrorLogFile != "" {
+			s.goInput(func() { s.watchLogPath(ctx, s.cfg.ErrorLogFile, errorTailer) })
+		}
+	}
+	go s.broadcastLogEntries()
+	go s.manageClients()
+
+	<-ctx.Done()
+	s.inputs.Wait()
+	s.tailers.Wait()
+	if s.cfg.PositionFile != "" {
+		s.savePosition()
+	}
+	<-storeDone
+	return nil
+}
+
+// goInput runs an input in a goroutine that Run waits for on shutdown.
+func (s *Server) goInput(run func()) {
+	s.inputs.Add(1)
+	go func() {
+		defer s.inputs.Done()
+		run()
+	}()
+}
+
+// Close stops Run and releases the GeoIP and ASN databases and input
+// listeners.
+func (s *Server) Close() error {
+	s.cancel()
+	if s.asnDB != nil {
+		s.asnDB.Close()
+	}
+	if s.syslog != nil {
+		s.syslog.Close()
+	}
+	if s.forward != nil {
+		s.forward.Close()
+	}
+	return s.db.Load().Close()
+}
+
+func returnError(w http.ResponseWriter, header int, msg string) {
+	payload := errorResponse{Error: msg}
+
+	js, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("error marshaling error msg", "err", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json;")
+	w.WriteHeader(header)
+	w.Write(js)
+}
+
+func find(slice []string, val string) (int, bool) {
+	for i, item := range slice {
+		if item == val {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+func (s *Server) corsMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+
+		s.reloadMu.RLock()
+		_, found := find(s.allowedOrigins, r.Header.Get("Origin"))
+		s.reloadMu.RUnlock()
+		if !found {
+			// Do not attach CORS header if origin is not allowed
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func customFileServer(root http.FileSystem) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".js") {
+			w.Header().Set("Content-Type", "application/javascript")
+		}
+		http.FileServer(root).ServeHTTP(w, r)
+	})
+}
+
+func MakeNginxVizHandler(countryIcons map[string]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		indexHtml, err := publicDir.ReadFile("public/index.html")
+		if err != nil {
+			slog.Error("Error reading index.html", "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		tmpl := template.Must(template.New("index").Parse(string(indexHtml)))
+
+		w.WriteHeader(http.StatusOK)
+
+		tmpl.Execute(w, nginxVizPage{
+			CountryIcons: countryIcons,
+		})
+	}
+}
+
+// broadcastLogEntries fans live and replayed entries, debug parse errors and
+// admin events out to clients. Only live entries are recorded in history.
+func (s *Server) broadcastLogEntries() {
+	for {
+		select {
+		case logEntry := <-s.entries:
+			s.handleLogEntry(logEntry)
+		case logEntry := <-s.replays:
+			s.broadcastLogEntry("replay", 0, logEntry)
+		case parseErr := <-s.parseErrorFeed:
+			s.broadcastJSON(parseErrorUpdate{Type: "parse_error", Data: parseErr})
+		case req := <-s.resumeRequests:
+			s.resumeClient(req)
+		case req := <-s.backfillRequests:
+			s.sendBackfill(req)
+		case event := <-s.events:
+			s.broadcastJSON(event)
+		case event := <-s.adminEvents:
+			s.broadcastAdminJSON(event)
+		}
+	}
+}
+
+// handleLogEntry records a live entry and broadcasts it together with any
+// events it triggers. Entries from muted IPs are recorded but not broadcast.
+func (s *Server) handleLogEntry(logEntry LogEntry) {
+	seq := s.history.Add(logEntry)
+	if s.store != nil {
+		s.store.Enqueue(logEntry)
+	}
+	if s.tracer != nil {
+		s.tracer.Export(logEntry)
+	}
+	s.contentTypes.Observe(logEntry)
+	s.protocols.Observe(logEntry)
+	s.networks.Observe(logEntry)
+	s.browsers.Observe(logEntry)
+	s.referers.Observe(logEntry)
+	s.counters.Peaks.Observe(logEntry)
+	if logEntry.Tor {
+		s.counters.TorRequests.Add(1)
+	}
+	s.patterns.Observe(logEntry.URL)
+
+	// Security alerts still fire for muted IPs
+	if logEntry.HoneypotHit {
+		s.counters.HoneypotHits.Add(1)
+		slog.Warn("Honeypot URL requested", "ip", logEntry.IP, "url", logEntry.URL, "user_agent", logEntry.UserAgent)
+		s.broadcastAdminJSON(honeypotAlert{Type: "honeypot_hit", Data: s.publicEntry(logEntry)})
+	}
+	if logEntry.RateLimited() {
+		s.counters.RateLimited.Add(1)
+	}
+	if logEntry.IsWhitelistedBot {
+		s.counters.WhitelistedBots.Add(1)
+	} else if logEntry.IsBot {
+		s.counters.UnwhitelistedBots.Add(1)
+	}
+	if alert, ok := s.rateLimits.Observe(logEntry); ok {
+		slog.Warn("Rate-limited request fraction exceeded threshold", "fraction", alert.Fraction, "threshold", alert.Threshold)
+		s.broadcastAdminJSON(alert)
+	}
+	if s.sessions != nil {
+		if anomaly, ok := s.sessions.Observe(logEntry); ok {
+			s.broadcastAdminJSON(anomaly)
+		}
+	}
+
+	if s.blockList.Muted(logEntry.IP) {
+		return
+	}
+
+	s.broadcastLogEntry("log_entry", seq, logEntry)
+	if entries, ok := s.correlations.Add(logEntry); ok {
+		for i := range entries {
+			entries[i] = s.publicEntry(entries[i])
+		}
+		s.broadcastJSON(correlatedRequest{
+			Type:      "correlated_request",
+			RequestID: logEntry.RequestID,
+			Entries:   entries,
+		})
+	}
+	if incident, ok := s.incidents.AddAccess(logEntry); ok {
+		incident.Access = s.publicEntry(incident.Access)
+		s.broadcastJSON(incident)
+	}
+}
+
+// broadcastLogEntry sends log updates to all connected WebSocket clients
+func (s *Server) broadcastLogEntry(updateType string, seq uint64, logEntry LogEntry) {
+	slog.Info("Broadcasting log entry", "type", updateType, "ip", logEntry.IP, "method", logEntry.Method, "url", logEntry.URL, "status", logEntry.StatusCode)
+
+	s.broadcastTo(LogUpdate{
+		Type:    updateType,
+		LogType: logTypeAccess,
+		Seq:     seq,
+		Data:    s.publicEntry(logEntry),
+	}, func(info *clientInfo) bool {
+		return info.Hosts.Matches(logEntry.Host) && info.Tor.Matches(logEntry.Tor)
+	})
+}
+
+// publicEntry strips fields that are not sent to websocket clients by default.
+func (s *Server) publicEntry(logEntry LogEntry) LogEntry {
+	if !s.cfg.BroadcastCookies {
+		logEntry.CookieHeader = ""
+	}
+	return logEntry
+}
+
+// broadcastJSON sends a JSON encoded message to all connected WebSocket clients
+func (s *Server) broadcastJSON(payload any) {
+	s.broadcastTo(payload, func(*clientInfo) bool { return true })
+}
+
+// broadcastAdminJSON sends a JSON encoded message to admin WebSocket clients only
+func (s *Server) broadcastAdminJSON(payload any) {
+	s.broadcastTo(payload, func(info *clientInfo) bool { return info.Admin })
+}
+
+// publishEvent queues a message for all clients from outside the broadcast
+// goroutine, which is the only one allowed to write to clients.
+func (s *Server) publishEvent(payload any) {
+	s.events <- payload
+}
+
+// publishAdminEvent is publishEvent for admin clients only.
+func (s *Server) publishAdminEvent(payload any) {
+	s.adminEvents <- payload
+}
+
+// broadcastTo sends a JSON encoded message to the clients include selects.
+// include is called with clientsMu held.
+func (s *Server) broadcastTo(payload any, include func(info *clientInfo) bool) {
+	message, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("Error marshaling websocket message", "err", err)
+		return
+	}
+
+	// Create a snapshot of clients to avoid holding locks during slow operations
+	s.clientsMu.RLock()
+	clientSnapshot := make([]*websocket.Conn, 0, len(s.clients))
+	for client, info := range s.clients {
+		if !include(info) {
+			continue
+		}
+		clientSnapshot = append(clientSnapshot, client)
+	}
+	s.clientsMu.RUnlock()
+
+	for _, client := range clientSnapshot {
+		err := client.WriteMessage(websocket.TextMessage, message)
+		if err != nil {
+			slog.Warn("Error writing to WebSocket client", "remote", client.RemoteAddr().String(), "err", err)
+			client.Close()
+			s.clientActions <- clientAction{conn: client, action: "unregister"}
+		}
+	}
+}
+
+func (s *Server) manageClients() {
+	for action := range s.clientActions {
+		switch action.action {
+		case "register":
+			s.clientsMu.Lock()
+			s.clients[action.conn] = &clientInfo{ConnectedAt: time.Now(), Admin: action.admin, Hosts: action.hosts, Tor: action.tor}
+			count := len(s.clients)
+			s.clientsMu.Unlock()
+			slog.Debug("Client registered", "clients", count, "admin", action.admin)
+		case "unregister":
+			// A failed write and the handler exiting may both unregister a client
+			s.clientsMu.Lock()
+			info, ok := s.clients[action.conn]
+			delete(s.clients, action.conn)
+			count := len(s.clients)
+			s.clientsMu.Unlock()
+			if !ok {
+				continue
+			}
+			s.connectionAges.Observe(time.Since(info.ConnectedAt))
+			if info.ClientID != "" {
+				s.resumeClients.Store(info.ClientID, s.history.LastSeq())
+			}
+			slog.Debug("Client unregistered", "clients", count)
+		}
+	}
+}
+
+// MakeWebSocketHandler creates a WebSocket handler for real-time log updates.
+// Admin clients additionally receive admin events such as security alerts.
+// Clients are pinged every PingInterval and dropped if no pong arrives within
+// two intervals.
+func (s *Server) MakeWebSocketHandler(admin bool) http.HandlerFunc {
+	pingInterval := s.cfg.PingInterval
+	pongWait := 2 * pingInterval
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The server's ReadTimeout and WriteTimeout are applied as deadlines on
+		// the underlying connection and survive the hijack done by Upgrade,
+		// which would kill every websocket after -write-timeout. Clear them
+		// here; the ping/pong deadlines below take over instead.
+		rc := http.NewResponseController(w)
+		if err := rc.SetReadDeadline(time.Time{}); err != nil {
+			slog.Debug("Error clearing read deadline", "err", err)
+		}
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			slog.Debug("Error clearing write deadline", "err", err)
+		}
+
+		conn, err := s.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			slog.Warn("WebSocket upgrade error", "err", err)
+			return
+		}
+		defer conn.Close()
+
+		// Register client, and unregister it however the handler exits
+		hosts := hostFilterFromQuery(r.URL.Query())
+		tor := parseTorFilter(r.URL.Query().Get("tor"))
+		s.clientActions <- clientAction{conn: conn, action: "register", admin: admin, hosts: hosts, tor: tor}
+		defer func() {
+			s.clientActions <- clientAction{conn: conn, action: "unregister"}
+		}()
+		if s.cfg.BackfillReplay > 0 {
+			s.backfillRequests <- backfillRequest{conn: conn, hosts: hosts, tor: tor}
+		}
+
+		slog.Info("New WebSocket client connected", "remote", r.RemoteAddr)
+
+		// Set up ping/pong to keep connection alive
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		conn.SetPongHandler(func(string) error {
+			conn.SetReadDeadline(time.Now().Add(pongWait))
+			return nil
+		})
+
+		// Start ping ticker
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+
+		done := make(chan struct{})
+
+		// Read messages in a goroutine
+		go func() {
+			defer close(done)
+			for {
+				_, message, err := conn.ReadMessage()
+				if err != nil {
+					slog.Debug("WebSocket read error", "remote", r.RemoteAddr, "err", err)
+					return
+				}
+				if hello, ok := parseClientHello(message); ok {
+					s.resumeRequests <- resumeRequest{conn: conn, hello: hello}
+				} else if filter, ok := parseClientFilter(message); ok {
+					s.setClientFilter(conn, filter)
+				}
+			}
+		}()
+
+		// Keep connection alive with pings
+		for {
+			select {
+			case <-ticker.C:
+				// WriteControl may run concurrently with broadcast writes
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pingInterval)); err != nil {
+					slog.Debug("WebSocket ping error", "remote", r.RemoteAddr, "err", err)
+					return
+				}
+			case <-done:
+				slog.Info("WebSocket client disconnected", "remote", r.RemoteAddr)
+				return
+			}
+		}
+	}
+}
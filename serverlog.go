@@ -0,0 +1,128 @@
+package nginxviz
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// SystemEvent is a server log record forwarded to admin websocket clients.
+type SystemEvent struct {
+	Type  string         `json:"type"`
+	Time  time.Time      `json:"time"`
+	Level string         `json:"level"`
+	Msg   string         `json:"msg"`
+	Attrs map[string]any `json:"attrs,omitempty"`
+}
+
+// WebSocketSlogHandler passes records to next and additionally sends those
+// at or above level to admin websocket clients as server_log messages.
+// Events are dropped rather than queued when the broadcaster is busy, so
+// logging never blocks, including from the broadcast goroutine itself.
+type WebSocketSlogHandler struct {
+	next   slog.Handler
+	level  slog.Leveler
+	events chan<- any
+	attrs  []slog.Attr
+	groups []string
+}
+
+// NewWebSocketSlogHandler wraps next so the server's own logs reach admin
+// clients, e.g. slog.SetDefault(slog.New(s.NewWebSocketSlogHandler(h, slog.LevelError))).
+func (s *Server) NewWebSocketSlogHandler(next slog.Handler, level slog.Leveler) *WebSocketSlogHandler {
+	return &WebSocketSlogHandler{next: next, level: level, events: s.adminEvents}
+}
+
+func (h *WebSocketSlogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() || h.next.Enabled(ctx, level)
+}
+
+func (h *WebSocketSlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= h.level.Level() {
+		h.publish(r)
+	}
+	if h.next.Enabled(ctx, r.Level) {
+		return h.next.Handle(ctx, r)
+	}
+	return nil
+}
+
+func (h *WebSocketSlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.next = h.next.WithAttrs(attrs)
+	clone.attrs = append(clone.attrs[:len(clone.attrs):len(clone.attrs)], groupAttrs(h.groups, attrs)...)
+	return &clone
+}
+
+func (h *WebSocketSlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.next = h.next.WithGroup(name)
+	clone.groups = append(clone.groups[:len(clone.groups):len(clone.groups)], name)
+	return &clone
+}
+
+func (h *WebSocketSlogHandler) publish(r slog.Record) {
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	event := SystemEvent{
+		Type:  "server_log",
+		Time:  r.Time,
+		Level: strings.ToLower(r.Level.String()),
+		Msg:   r.Message,
+		Attrs: attrMap(append(h.attrs, groupAttrs(h.groups, attrs)...)),
+	}
+
+	select {
+	case h.events <- event:
+	default:
+	}
+}
+
+// groupAttrs nests attrs inside the open groups, innermost last.
+func groupAttrs(groups []string, attrs []slog.Attr) []slog.Attr {
+	if len(attrs) == 0 {
+		return nil
+	}
+	for i := len(groups) - 1; i >= 0; i-- {
+		attrs = []slog.Attr{{Key: groups[i], Value: slog.GroupValue(attrs...)}}
+	}
+	return attrs
+}
+
+func attrMap(attrs []slog.Attr) map[string]any {
+	if len(attrs) == 0 {
+		return nil
+	}
+	m := make(map[string]any, len(attrs))
+	for _, a := range attrs {
+		v := a.Value.Resolve()
+		switch v.Kind() {
+		case slog.KindGroup:
+			if a.Key == "" {
+				for k, gv := range attrMap(v.Group()) {
+					m[k] = gv
+				}
+				continue
+			}
+			m[a.Key] = attrMap(v.Group())
+		case slog.KindAny:
+			// Errors marshal to {} otherwise
+			if err, ok := v.Any().(error); ok {
+				m[a.Key] = err.Error()
+				continue
+			}
+			m[a.Key] = v.Any()
+		default:
+			m[a.Key] = v.Any()
+		}
+	}
+	return m
+}
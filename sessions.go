@@ -0,0 +1,88 @@
+package nginxviz
+
+import (
+	"strings"
+	"sync"
+)
+
+const (
+	sessionMaxTokens   = 10000
+	sessionMaxIPs      = 10
+	sessionTokenPrefix = 8
+)
+
+type sessionAnomaly struct {
+	Type        string   `json:"type"`
+	TokenPrefix string   `json:"token_prefix"`
+	IPs         []string `json:"ips"`
+}
+
+// StickySessionTracker maps session cookie values to the IPs using them and
+// reports tokens seen from more than one IP, a sign of session hijacking.
+type StickySessionTracker struct {
+	mu         sync.Mutex
+	cookieName string
+	tokens     map[string][]string
+}
+
+func NewStickySessionTracker(cookieName string) *StickySessionTracker {
+	return &StickySessionTracker{
+		cookieName: cookieName,
+		tokens:     make(map[string][]string),
+	}
+}
+
+// Observe records the session token of entry and returns an anomaly when the
+// token has just been used from an additional IP.
+func (t *StickySessionTracker) Observe(entry LogEntry) (sessionAnomaly, bool) {
+	token := cookieValue(entry.CookieHeader, t.cookieName)
+	if token == "" {
+		return sessionAnomaly{}, false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ips, known := t.tokens[token]
+	for _, ip := range ips {
+		if ip == entry.IP {
+			return sessionAnomaly{}, false
+		}
+	}
+
+	if !known && len(t.tokens) >= sessionMaxTokens {
+		// Evict an arbitrary token to keep memory bounded
+		for k := range t.tokens {
+			delete(t.tokens, k)
+			break
+		}
+	}
+	if len(ips) < sessionMaxIPs {
+		ips = append(ips, entry.IP)
+		t.tokens[token] = ips
+	}
+	if len(ips) < 2 {
+		return sessionAnomaly{}, false
+	}
+
+	prefix := token
+	if len(prefix) > sessionTokenPrefix {
+		prefix = prefix[:sessionTokenPrefix]
+	}
+	return sessionAnomaly{
+		Type:        "session_anomaly",
+		TokenPrefix: prefix,
+		IPs:         append([]string(nil), ips...),
+	}, true
+}
+
+// cookieValue extracts a cookie from a raw Cookie header value.
+func cookieValue(header, name string) string {
+	for _, part := range strings.Split(header, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok && key == name {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
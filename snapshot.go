@@ -0,0 +1,154 @@
+package nginxviz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ByteSize is a size in bytes that can be written with a KB, MB or GB
+// suffix, in multiples of 1024, in flags and the config file.
+type ByteSize int64
+
+var byteSizeUnits = []struct {
+	suffix string
+	size   ByteSize
+}{
+	{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+}
+
+// ParseByteSize parses a size such as 512, 64KB or 100MB.
+func ParseByteSize(s string) (ByteSize, error) {
+	number := strings.ToUpper(strings.TrimSpace(s))
+	unit := ByteSize(1)
+	for _, u := range byteSizeUnits {
+		if prefix, ok := strings.CutSuffix(number, u.suffix); ok {
+			number, unit = strings.TrimSpace(prefix), u.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return ByteSize(n) * unit, nil
+}
+
+// String formats b in the largest unit that divides it, so it parses back
+// to the same size.
+func (b ByteSize) String() string {
+	for _, u := range byteSizeUnits[:3] {
+		if b >= u.size && b%u.size == 0 {
+			return strconv.FormatInt(int64(b/u.size), 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(int64(b), 10)
+}
+
+// Set implements flag.Value.
+func (b *ByteSize) Set(s string) error {
+	size, err := ParseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+// UnmarshalText lets sizes be written as strings in the config file.
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	return b.Set(string(text))
+}
+
+type statsRecord struct {
+	At string `json:"at"`
+	statsSnapshot
+}
+
+// SnapshotWriter appends a /api/stats snapshot to a JSON lines file at a
+// fixed interval. It runs on its own goroutine so a slow disk never holds
+// up log processing. When the file would grow beyond maxSize it is renamed
+// with a .1 suffix, replacing the previous one, and a new file is started.
+type SnapshotWriter struct {
+	path     string
+	interval time.Duration
+	maxSize  ByteSize
+	counters *statsCounters
+}
+
+// NewSnapshotWriter checks that path can be appended to.
+func NewSnapshotWriter(path string, interval time.Duration, maxSize ByteSize, counters *statsCounters) (*SnapshotWriter, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	return &SnapshotWriter{path: path, interval: interval, maxSize: maxSize, counters: counters}, nil
+}
+
+// Run writes a snapshot every interval until ctx is cancelled.
+func (w *SnapshotWriter) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := w.write(now); err != nil {
+				slog.Warn("Error writing stats snapshot", "file", w.path, "err", err)
+			}
+		}
+	}
+}
+
+func (w *SnapshotWriter) write(now time.Time) error {
+	line, err := json.Marshal(statsRecord{
+		At:            now.UTC().Format(time.RFC3339),
+		statsSnapshot: w.counters.Snapshot(),
+	})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if err := w.rotate(int64(len(line))); err != nil {
+		return fmt.Errorf("rotating: %w", err)
+	}
+
+	f, err := os.OpenFile(w.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rotate moves the file aside if appending n bytes would exceed maxSize.
+func (w *SnapshotWriter) rotate(n int64) error {
+	if w.maxSize <= 0 {
+		return nil
+	}
+	info, err := os.Stat(w.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if info.Size() == 0 || info.Size()+n <= int64(w.maxSize) {
+		return nil
+	}
+	return os.Rename(w.path, w.path+".1")
+}
@@ -0,0 +1,118 @@
+package nginxviz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sshPrefix marks a log file on another host, such as
+// ssh://user@host:/var/log/nginx/access.log, that is followed by running
+// tail on it over SSH.
+const sshPrefix = "ssh://"
+
+const sshReconnectDelay = 5 * time.Second
+
+// sshTarget is a log file on another host.
+type sshTarget struct {
+	Destination string // [user@]host as given to ssh
+	Port        string // empty for ssh's default
+	Path        string
+}
+
+// parseSSHLogFile splits an ssh:// log file name. The host and the path are
+// separated by a colon as in scp; a port goes between them as in
+// ssh://host:2222/var/log/nginx/access.log.
+func parseSSHLogFile(path string) (sshTarget, bool, error) {
+	rest, ok := strings.CutPrefix(path, sshPrefix)
+	if !ok {
+		return sshTarget{}, false, nil
+	}
+
+	var target sshTarget
+	target.Destination, target.Path, _ = strings.Cut(rest, ":")
+	if port, file, ok := strings.Cut(target.Path, "/"); ok && port != "" {
+		if _, err := strconv.ParseUint(port, 10, 16); err == nil {
+			target.Port, target.Path = port, "/"+file
+		}
+	}
+	if target.Destination == "" || strings.HasPrefix(target.Destination, "-") {
+		return sshTarget{}, true, fmt.Errorf("missing host")
+	}
+	if target.Path == "" {
+		return sshTarget{}, true, fmt.Errorf("missing path")
+	}
+	return target, true, nil
+}
+
+func (t sshTarget) String() string {
+	if t.Port != "" {
+		return sshPrefix + t.Destination + ":" + t.Port + t.Path
+	}
+	return sshPrefix + t.Destination + ":" + t.Path
+}
+
+// sshArgs returns the ssh arguments that run tail on the file of t, starting
+// with the last lines lines, or with the whole file if lines is negative.
+// ssh never prompts, so keys must be loaded in an agent or left unencrypted,
+// and keepalives notice a dead connection within a minute.
+func sshArgs(t sshTarget, lines int) []string {
+	args := []string{
+		"-o", "BatchMode=yes",
+		"-o", "ServerAliveInterval=15",
+		"-o", "ServerAliveCountMax=3",
+	}
+	if t.Port != "" {
+		args = append(args, "-p", t.Port)
+	}
+
+	from := "+1"
+	if lines >= 0 {
+		from = strconv.Itoa(lines)
+	}
+	// The remote command is run by the user's shell
+	return append(args, t.Destination, "--", "tail", "-F", "-n", from, shellQuote(t.Path))
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// readSSH passes each line of the remote file of t to handleLine until ctx
+// is cancelled. The first connection starts where -from-end and -backfill
+// say a local file would start; after a dropped connection, ssh reconnects
+// and follows only new lines, so lines written while it was down are
+// missed.
+func (s *Server) readSSH(ctx context.Context, t sshTarget, handleLine lineHandler) {
+	lines := -1
+	switch {
+	case s.cfg.FromEnd:
+		lines = 0
+	case s.cfg.Backfill > 0:
+		lines = s.cfg.Backfill
+	}
+
+	for {
+		slog.Info("Following remote log file", "file", t.String())
+		err := followCommand(ctx, "ssh", sshArgs(t, lines), handleLine)
+		if ctx.Err() != nil {
+			slog.Info("Stopped following remote log file", "file", t.String())
+			return
+		}
+		if errors.Is(err, exec.ErrNotFound) {
+			slog.Error("Cannot follow remote log file", "file", t.String(), "err", err)
+			return
+		}
+		slog.Error("Error following remote log file, reconnecting", "file", t.String(), "err", err)
+		lines = 0
+		if sleepContext(ctx, sshReconnectDelay) != nil {
+			return
+		}
+	}
+}
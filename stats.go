@@ -0,0 +1,131 @@
+package nginxviz
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// statsCounters holds the running totals served at /api/stats.
+type statsCounters struct {
+	HoneypotHits    atomic.Int64
+	RateLimited     atomic.Int64
+	PartiallyParsed atomic.Int64 // lines parsed with ParseWarnings
+	Unparseable     atomic.Int64 // lines the parser rejected
+
+	WhitelistedBots   atomic.Int64
+	UnwhitelistedBots atomic.Int64
+
+	TorRequests atomic.Int64 // entries from Tor exit nodes
+
+	Peaks PeakTracker
+}
+
+type statsSnapshot struct {
+	HoneypotHitsTotal             int64 `json:"honeypot_hits_total"`
+	RateLimitedRequestsTotal      int64 `json:"rate_limited_requests_total"`
+	PartiallyParsedLinesTotal     int64 `json:"partially_parsed_lines_total"`
+	UnparseableLinesTotal         int64 `json:"unparseable_lines_total"`
+	WhitelistedBotRequestsTotal   int64 `json:"whitelisted_bot_requests_total"`
+	UnwhitelistedBotRequestsTotal int64 `json:"unwhitelisted_bot_requests_total"`
+	TorRequestsTotal              int64 `json:"tor_requests_total"`
+
+	Peaks peaksSnapshot `json:"peaks"`
+}
+
+func (c *statsCounters) Snapshot() statsSnapshot {
+	return statsSnapshot{
+		HoneypotHitsTotal:             c.HoneypotHits.Load(),
+		RateLimitedRequestsTotal:      c.RateLimited.Load(),
+		PartiallyParsedLinesTotal:     c.PartiallyParsed.Load(),
+		UnparseableLinesTotal:         c.Unparseable.Load(),
+		WhitelistedBotRequestsTotal:   c.WhitelistedBots.Load(),
+		UnwhitelistedBotRequestsTotal: c.UnwhitelistedBots.Load(),
+		TorRequestsTotal:              c.TorRequests.Load(),
+		Peaks:                         c.Peaks.Snapshot(),
+	}
+}
+
+// MakeStatsHandler returns the running totals.
+func MakeStatsHandler(counters *statsCounters) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, counters.Snapshot())
+	}
+}
+
+var contentTypeBuckets = []string{"html", "json", "image", "video", "css", "js", "binary", "other"}
+
+// contentTypeBucket groups a response Content-Type into a coarse category.
+func contentTypeBucket(contentType string) string {
+	mediaType, _, _ := strings.Cut(strings.ToLower(contentType), ";")
+	mediaType = strings.TrimSpace(mediaType)
+
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		return "html"
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return "json"
+	case strings.HasPrefix(mediaType, "image/"):
+		return "image"
+	case strings.HasPrefix(mediaType, "video/"):
+		return "video"
+	case mediaType == "text/css":
+		return "css"
+	case strings.Contains(mediaType, "javascript") || mediaType == "application/ecmascript":
+		return "js"
+	case mediaType == "application/octet-stream" || mediaType == "application/zip" ||
+		mediaType == "application/gzip" || mediaType == "application/pdf" ||
+		mediaType == "application/wasm" || strings.HasPrefix(mediaType, "font/") ||
+		strings.HasPrefix(mediaType, "audio/"):
+		return "binary"
+	default:
+		return "other"
+	}
+}
+
+type contentTypeCount struct {
+	Count int   `json:"count"`
+	Bytes int64 `json:"bytes"`
+}
+
+// contentTypeBreakdown counts requests and bytes per content type bucket.
+type contentTypeBreakdown struct {
+	mu      sync.Mutex
+	buckets map[string]*contentTypeCount
+}
+
+func newContentTypeBreakdown() *contentTypeBreakdown {
+	buckets := make(map[string]*contentTypeCount, len(contentTypeBuckets))
+	for _, name := range contentTypeBuckets {
+		buckets[name] = &contentTypeCount{}
+	}
+	return &contentTypeBreakdown{buckets: buckets}
+}
+
+func (b *contentTypeBreakdown) Observe(entry LogEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bucket := b.buckets[contentTypeBucket(entry.ResponseContentType)]
+	bucket.Count++
+	bucket.Bytes += int64(entry.Size)
+}
+
+func (b *contentTypeBreakdown) Snapshot() map[string]contentTypeCount {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	snapshot := make(map[string]contentTypeCount, len(b.buckets))
+	for name, count := range b.buckets {
+		snapshot[name] = *count
+	}
+	return snapshot
+}
+
+// MakeContentTypesHandler returns request counts and bytes per content type bucket.
+func MakeContentTypesHandler(contentTypes *contentTypeBreakdown) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, contentTypes.Snapshot())
+	}
+}
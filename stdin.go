@@ -0,0 +1,82 @@
+package nginxviz
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+)
+
+// stdinLogFile is the log file name that reads lines from standard input.
+const stdinLogFile = "-"
+
+// readLogStream passes each line read from r to handleLine until r is
+// exhausted, handleLine fails or ctx is cancelled. Entries already seen stay
+// available once the stream ends. A read blocked on r cannot be interrupted,
+// so it is left behind when ctx is cancelled.
+func (s *Server) readLogStream(ctx context.Context, name string, r io.Reader, handleLine lineHandler) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		readLines(ctx, name, r, handleLine)
+	}()
+
+	select {
+	case <-ctx.Done():
+	case <-done:
+	}
+}
+
+func readLines(ctx context.Context, name string, r io.Reader, handleLine lineHandler) {
+	slog.Info("Reading log lines", "from", name)
+
+	reader := bufio.NewReader(r)
+	for ctx.Err() == nil {
+		line, err := reader.ReadString('\n')
+		// A final line without a newline is still a line
+		if line != "" {
+			if err := handleLine(ctx, line); err != nil {
+				slog.Error("Error handling log line", "from", name, "err", err)
+				return
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			slog.Info("Log stream ended", "from", name)
+			return
+		}
+		if err != nil {
+			slog.Error("Error reading log lines", "from", name, "err", err)
+			return
+		}
+	}
+}
+
+// followCommand runs a command and passes each line it writes to stdout to
+// handleLine, until the command exits or ctx is cancelled. Its stderr goes
+// to ours. A command that exits is an error, as it is expected to keep
+// following its source.
+func followCommand(ctx context.Context, name string, args []string, handleLine lineHandler) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	err = forEachLine(ctx, stdout, handleLine)
+	if waitErr := cmd.Wait(); err == nil {
+		err = waitErr
+	}
+	if err == nil {
+		err = fmt.Errorf("%s exited", name)
+	}
+	return err
+}
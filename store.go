@@ -0,0 +1,240 @@
+package nginxviz
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	storeBatchSize     = 100
+	storeFlushInterval = time.Second
+	storeQueueSize     = 10000
+)
+
+const storeSchema = `
+CREATE TABLE IF NOT EXISTS requests (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	timestamp    INTEGER NOT NULL,
+	ip           TEXT NOT NULL,
+	method       TEXT NOT NULL,
+	url          TEXT NOT NULL,
+	status_code  INTEGER NOT NULL,
+	size         INTEGER NOT NULL,
+	user_agent   TEXT NOT NULL,
+	referer      TEXT NOT NULL,
+	country      TEXT NOT NULL,
+	country_full TEXT NOT NULL,
+	hostname     TEXT NOT NULL,
+	rate         INTEGER NOT NULL,
+	suspicious   INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS requests_timestamp ON requests (timestamp);
+`
+
+const storeColumns = `timestamp, ip, method, url, status_code, size, user_agent, referer, country, country_full, hostname, rate, suspicious`
+
+// requestStore persists log entries to SQLite. Entries are queued on a
+// buffered channel and written in batches by a dedicated goroutine so that
+// disk latency never blocks the broadcast path.
+type requestStore struct {
+	db      *sql.DB
+	entries chan LogEntry
+}
+
+func openRequestStore(path string) (*requestStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer, serialize access through one connection
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(storeSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating schema: %w", err)
+	}
+
+	return &requestStore{
+		db:      db,
+		entries: make(chan LogEntry, storeQueueSize),
+	}, nil
+}
+
+// Enqueue queues an entry for writing, dropping it if the writer is behind.
+func (s *requestStore) Enqueue(entry LogEntry) {
+	select {
+	case s.entries <- entry:
+	default:
+		slog.Warn("Request store queue full, dropping entry", "ip", entry.IP, "url", entry.URL)
+	}
+}
+
+// Run writes queued entries in transactions of storeBatchSize rows or every
+// storeFlushInterval, whichever comes first. Pending rows are flushed and the
+// database closed once ctx is cancelled.
+func (s *requestStore) Run(ctx context.Context) {
+	defer s.db.Close()
+
+	ticker := time.NewTicker(storeFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]LogEntry, 0, storeBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.insert(batch); err != nil {
+			slog.Error("Error writing entries to request store", "count", len(batch), "err", err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case entry := <-s.entries:
+					batch = append(batch, entry)
+					if len(batch) == storeBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		case entry := <-s.entries:
+			batch = append(batch, entry)
+			if len(batch) == storeBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (s *requestStore) insert(batch []LogEntry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO requests (` + storeColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range batch {
+		_, err := stmt.Exec(e.Timestamp.UnixNano(), e.IP, e.Method, e.URL, e.StatusCode, e.Size,
+			e.UserAgent, e.Referer, e.Country, e.CountryFull, e.Hostname, e.Rate, e.Suspicious)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Query calls fn for every stored entry with a timestamp in [start, end).
+func (s *requestStore) Query(ctx context.Context, start, end time.Time, fn func(LogEntry) error) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+storeColumns+` FROM requests WHERE timestamp >= ? AND timestamp < ? ORDER BY timestamp`,
+		start.UnixNano(), end.UnixNano())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e LogEntry
+		var ts int64
+		err := rows.Scan(&ts, &e.IP, &e.Method, &e.URL, &e.StatusCode, &e.Size,
+			&e.UserAgent, &e.Referer, &e.Country, &e.CountryFull, &e.Hostname, &e.Rate, &e.Suspicious)
+		if err != nil {
+			return err
+		}
+		e.Timestamp = time.Unix(0, ts).UTC()
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// parseTimeParam reads an RFC3339 query parameter, returning fallback when absent.
+func parseTimeParam(r *http.Request, name string, fallback time.Time) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// MakeExportHandler streams persisted entries between start and end as JSON or CSV.
+func MakeExportHandler(store *requestStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if store == nil {
+			returnError(w, http.StatusNotFound, "export requires -db-out")
+			return
+		}
+
+		start, err := parseTimeParam(r, "start", time.Unix(0, 0))
+		if err != nil {
+			returnError(w, http.StatusBadRequest, "invalid start, expected RFC3339 timestamp")
+			return
+		}
+		end, err := parseTimeParam(r, "end", time.Now())
+		if err != nil {
+			returnError(w, http.StatusBadRequest, "invalid end, expected RFC3339 timestamp")
+			return
+		}
+
+		switch format := r.URL.Query().Get("format"); format {
+		case "", "json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("["))
+			enc := json.NewEncoder(w)
+			first := true
+			err = store.Query(r.Context(), start, end, func(e LogEntry) error {
+				if !first {
+					w.Write([]byte(","))
+				}
+				first = false
+				return enc.Encode(e)
+			})
+			w.Write([]byte("]"))
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv")
+			cw := csv.NewWriter(w)
+			cw.Write([]string{"timestamp", "ip", "method", "url", "status_code", "size", "user_agent", "referer", "country", "country_full", "hostname", "rate", "suspicious"})
+			err = store.Query(r.Context(), start, end, func(e LogEntry) error {
+				return cw.Write([]string{
+					e.Timestamp.Format(time.RFC3339Nano), e.IP, e.Method, e.URL,
+					strconv.Itoa(e.StatusCode), strconv.Itoa(e.Size), e.UserAgent, e.Referer,
+					e.Country, e.CountryFull, e.Hostname, strconv.Itoa(e.Rate), strconv.FormatBool(e.Suspicious),
+				})
+			})
+			cw.Flush()
+		default:
+			returnError(w, http.StatusBadRequest, "format must be json or csv")
+			return
+		}
+
+		if err != nil {
+			// Headers are already sent, the truncated body is all we can signal
+			slog.Error("Error exporting requests", "err", err)
+		}
+	}
+}
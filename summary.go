@@ -0,0 +1,162 @@
+package nginxviz
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const summaryTopN = 5
+
+type summaryCount struct {
+	Key   string
+	Count int
+}
+
+// trafficSummary aggregates the entries of a time window.
+type trafficSummary struct {
+	Window    time.Duration
+	Requests  int
+	Errors    int
+	Bytes     int64
+	UniqueIPs int
+	Countries []summaryCount
+	URLs      []summaryCount
+}
+
+func summarize(entries []LogEntry, since time.Time, window time.Duration) trafficSummary {
+	summary := trafficSummary{Window: window}
+	countries := make(map[string]int)
+	urls := make(map[string]int)
+	ips := make(map[string]bool)
+
+	for _, e := range entries {
+		if e.Timestamp.Before(since) {
+			continue
+		}
+		summary.Requests++
+		if e.StatusCode >= 400 {
+			summary.Errors++
+		}
+		summary.Bytes += int64(e.Size)
+		ips[e.IP] = true
+		country := e.CountryFull
+		if country == "" {
+			country = "Unknown"
+		}
+		countries[country]++
+		if e.NormalizedURL != "" {
+			urls[e.NormalizedURL]++
+		} else {
+			urls[e.URL]++
+		}
+	}
+
+	summary.UniqueIPs = len(ips)
+	summary.Countries = topCounts(countries, summaryTopN)
+	summary.URLs = topCounts(urls, summaryTopN)
+	return summary
+}
+
+func topCounts(counts map[string]int, n int) []summaryCount {
+	top := make([]summaryCount, 0, len(counts))
+	for key, count := range counts {
+		top = append(top, summaryCount{Key: key, Count: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Key < top[j].Key
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// Markdown renders the summary as GitHub flavored Markdown. Tables use the
+// pipe syntax, which Confluence's Markdown import understands as well.
+func (s trafficSummary) Markdown() string {
+	var b strings.Builder
+
+	errorRate := 0.0
+	if s.Requests > 0 {
+		errorRate = float64(s.Errors) / float64(s.Requests) * 100
+	}
+
+	fmt.Fprintf(&b, "# Traffic summary (last %s)\n\n", s.Window)
+	fmt.Fprintf(&b, "**%d requests** from **%d unique IPs**, %.1f%% errors, %s served.\n\n",
+		s.Requests, s.UniqueIPs, errorRate, formatBytes(s.Bytes))
+
+	b.WriteString("| Metric | Value |\n|---|---:|\n")
+	fmt.Fprintf(&b, "| Requests | %d |\n", s.Requests)
+	fmt.Fprintf(&b, "| Unique IPs | %d |\n", s.UniqueIPs)
+	fmt.Fprintf(&b, "| Error rate (4xx/5xx) | %.1f%% |\n", errorRate)
+	fmt.Fprintf(&b, "| Bandwidth | %s |\n\n", formatBytes(s.Bytes))
+
+	writeCountTable(&b, "Top countries", "Country", s.Countries, false)
+	writeCountTable(&b, "Top URLs", "URL", s.URLs, true)
+	return b.String()
+}
+
+func writeCountTable(b *strings.Builder, title, column string, rows []summaryCount, code bool) {
+	fmt.Fprintf(b, "## %s\n\n", title)
+	if len(rows) == 0 {
+		b.WriteString("_No requests._\n\n")
+		return
+	}
+
+	fmt.Fprintf(b, "| %s | Requests |\n|---|---:|\n", column)
+	for _, row := range rows {
+		cell := markdownCell(row.Key)
+		if code {
+			cell = "`" + strings.ReplaceAll(cell, "`", "'") + "`"
+		}
+		fmt.Fprintf(b, "| %s | %d |\n", cell, row.Count)
+	}
+	b.WriteString("\n")
+}
+
+// markdownCell escapes characters that would break a table row.
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// MakeMarkdownSummaryHandler renders a Markdown traffic report for the
+// entries in history within ?window= (default 1h).
+func (s *Server) MakeMarkdownSummaryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		window := time.Hour
+		if param := r.URL.Query().Get("window"); param != "" {
+			var err error
+			window, err = time.ParseDuration(param)
+			if err != nil || window <= 0 {
+				returnError(w, http.StatusBadRequest, "invalid window, expected a duration such as 1h")
+				return
+			}
+		}
+
+		summary := summarize(s.history.Snapshot(), time.Now().Add(-window), window)
+
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(summary.Markdown()))
+	}
+}
@@ -0,0 +1,26 @@
+package nginxviz
+
+import "regexp"
+
+// Headers that rsyslog and nginx's own syslog output put in front of a log
+// line. RFC 3164 lines may arrive without the priority when rsyslog writes
+// them to a file, and with an RFC 3339 timestamp in its high precision mode:
+//
+//	<190>Oct 16 10:00:00 web1 nginx: 1.2.3.4 - - [16/Oct/2026:10:00:00 +0000] "GET / HTTP/1.1" ...
+//	<190>1 2026-10-16T10:00:00Z web1 nginx 1234 - - 1.2.3.4 - - [16/Oct/2026:10:00:00 +0000] ...
+var (
+	rfc3164Header = regexp.MustCompile(`^(?:<\d{1,3}>)?(?:[A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}|\d{4}-\d{2}-\d{2}T\S+) \S+ [^\s:\[]+(?:\[\d+\])?: ?`)
+	rfc5424Header = regexp.MustCompile(`^<\d{1,3}>\d{1,2} \S+ \S+ \S+ \S+ \S+ (?:-|(?:\[(?:[^\]\\]|\\.)*\])+) ?(?:\x{FEFF})?`)
+)
+
+// stripSyslogHeader removes RFC 3164 or RFC 5424 framing from a line,
+// returning lines without it unchanged.
+func stripSyslogHeader(line string) string {
+	if loc := rfc5424Header.FindStringIndex(line); loc != nil {
+		return line[loc[1]:]
+	}
+	if loc := rfc3164Header.FindStringIndex(line); loc != nil {
+		return line[loc[1]:]
+	}
+	return line
+}
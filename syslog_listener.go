@@ -0,0 +1,155 @@
+package nginxviz
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// syslogMaxMessage is the largest message accepted, well above the 8 KiB
+// nginx caps its syslog messages at.
+const syslogMaxMessage = 64 << 10
+
+// syslogListener receives syslog messages over UDP and TCP on the same
+// address, as sent by nginx's access_log syslog:server=... directive.
+type syslogListener struct {
+	udp net.PacketConn
+	tcp net.Listener
+}
+
+func listenSyslog(addr string) (*syslogListener, error) {
+	udp, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		udp.Close()
+		return nil, err
+	}
+	return &syslogListener{udp: udp, tcp: tcp}, nil
+}
+
+// Serve passes every received message to handle until ctx is cancelled,
+// then closes the listener.
+func (l *syslogListener) Serve(ctx context.Context, handle lineHandler) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		l.serveUDP(ctx, handle)
+	}()
+	go func() {
+		defer wg.Done()
+		l.serveTCP(ctx, handle)
+	}()
+
+	<-ctx.Done()
+	l.Close()
+	wg.Wait()
+}
+
+// Close stops the listener, for servers that never called Serve.
+func (l *syslogListener) Close() {
+	l.udp.Close()
+	l.tcp.Close()
+}
+
+// serveUDP handles datagrams, each holding one message.
+func (l *syslogListener) serveUDP(ctx context.Context, handle lineHandler) {
+	buf := make([]byte, syslogMaxMessage)
+	for {
+		n, _, err := l.udp.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("Error receiving syslog message", "err", err)
+			}
+			return
+		}
+		if err := handle(ctx, string(buf[:n])); err != nil {
+			slog.Error("Error handling syslog message", "err", err)
+		}
+	}
+}
+
+// serveTCP accepts connections and reads messages from each until it is
+// closed.
+func (l *syslogListener) serveTCP(ctx context.Context, handle lineHandler) {
+	for {
+		conn, err := l.tcp.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("Error accepting syslog connection", "err", err)
+			}
+			return
+		}
+		go func() {
+			defer conn.Close()
+			stop := context.AfterFunc(ctx, func() { conn.Close() })
+			defer stop()
+			if err := readSyslogStream(ctx, conn, handle); err != nil && ctx.Err() == nil {
+				slog.Debug("Syslog connection closed", "remote", conn.RemoteAddr(), "err", err)
+			}
+		}()
+	}
+}
+
+// readSyslogStream splits a TCP stream into messages. Senders frame them
+// either with a newline or, per RFC 6587, by prefixing their length.
+func readSyslogStream(ctx context.Context, r io.Reader, handle lineHandler) error {
+	reader := bufio.NewReader(r)
+	for ctx.Err() == nil {
+		first, err := reader.Peek(1)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		var message string
+		if first[0] >= '1' && first[0] <= '9' {
+			length, err := reader.ReadString(' ')
+			if err != nil {
+				return err
+			}
+			n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+			if err != nil || n > syslogMaxMessage {
+				return fmt.Errorf("invalid syslog message length %q", length)
+			}
+			buf := make([]byte, n)
+			if _, err := io.ReadFull(reader, buf); err != nil {
+				return err
+			}
+			message = string(buf)
+		} else {
+			message, err = reader.ReadString('\n')
+			if err != nil && !errors.Is(err, io.EOF) {
+				return err
+			}
+		}
+
+		if err := handle(ctx, message); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// handleSyslogMessage passes a received message to the error log handler
+// when nginx sent it from error_log, and to the access log handler
+// otherwise.
+func (s *Server) handleSyslogMessage(ctx context.Context, message string) error {
+	line := stripSyslogHeader(strings.TrimSpace(message))
+	if errorLogRegex.MatchString(line) {
+		return s.handleErrorLogLine(ctx, line)
+	}
+	return s.handleAccessLogLine(ctx, line)
+}
@@ -0,0 +1,279 @@
+package nginxviz
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// rotationDrainPeriod is how long the old file is still read after a
+// rotation, as nginx writes to it until it reopens its logs on USR1.
+const rotationDrainPeriod = 2 * time.Second
+
+// lineHandler is called with each line read from a log source.
+type lineHandler func(ctx context.Context, line string) error
+
+// seekFunc returns the offset to start reading a newly opened log file at.
+type seekFunc func(ctx context.Context, file *os.File) (int64, error)
+
+// Tailer follows a single log file across rotations and truncations.
+type Tailer struct {
+	Path       string
+	HandleLine lineHandler
+
+	// Seek, if set, returns the offset to start reading at the first time
+	// the file is opened. Files are otherwise read from the beginning.
+	Seek seekFunc
+
+	// Offsets, if set, records how far into the file has been handled.
+	Offsets *fileOffsets
+}
+
+// Run passes each line appended to the file to HandleLine until ctx is
+// cancelled. Rotations are handled in place by reopening the file, and
+// errors are retried after a pause, so one goroutine follows the file for as
+// long as ctx lives.
+func (t *Tailer) Run(ctx context.Context) {
+	logFile := t.Path
+	seek := t.Seek
+	for {
+		err := t.follow(ctx, seek)
+		if ctx.Err() != nil {
+			slog.Info("Stopped watching log file", "file", logFile)
+			return
+		}
+
+		if err != nil {
+			slog.Error("Error following log file", "file", logFile, "err", err)
+			if sleepContext(ctx, 2*time.Second) != nil {
+				return
+			}
+			continue
+		}
+
+		// Files replacing a rotated one are read from the start
+		seek = nil
+		slog.Info("Restarting log file watcher", "file", logFile)
+	}
+}
+
+// follow reads the current incarnation of the file from the beginning, or
+// from the offset seek returns. It returns nil when the file has been
+// rotated and must be reopened, or the error returned by HandleLine.
+func (t *Tailer) follow(ctx context.Context, seek seekFunc) error {
+	logFile := t.Path
+
+	// Check if file exists, if not wait for it
+	for {
+		if _, err := os.Stat(logFile); os.IsNotExist(err) {
+			slog.Info("Log file does not exist, waiting...", "file", logFile)
+			if err := sleepContext(ctx, 2*time.Second); err != nil {
+				return err
+			}
+			continue
+		}
+		break
+	}
+
+	slog.Info("Starting to watch log file", "file", logFile)
+
+	file, err := openLogFile(logFile)
+	if err != nil {
+		return fmt.Errorf("opening log file: %w", err)
+	}
+	defer file.Close()
+
+	currentInode, err := getInode(logFile)
+	if err != nil {
+		return fmt.Errorf("getting log file inode: %w", err)
+	}
+
+	// The inode checker lives only as long as this file handle.
+	fileCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Wait for changes with inotify where possible. Otherwise fall back to
+	// reading every 500ms and checking for rotation every 10s.
+	rotated := make(chan bool, 1)
+	notifier, err := newFileNotifier(logFile)
+	if err != nil {
+		slog.Debug("Polling log file", "file", logFile, "err", err)
+		go inodeChecker(fileCtx, logFile, currentInode, rotated)
+	} else {
+		defer notifier.Close()
+	}
+
+	var start int64
+	if seek != nil {
+		if start, err = seek(ctx, file); err != nil {
+			return err
+		}
+	}
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return fmt.Errorf("seeking log file: %w", err)
+	}
+	reader := bufio.NewReader(file)
+
+	// partial holds the start of a line whose newline has not been written
+	// yet, and offset how far into the file the reader has got
+	var partial string
+	offset := start
+	t.recordOffset(currentInode, offset)
+
+	// Once the file has been replaced, what is written to the old one until
+	// drainUntil is read before the new one is opened
+	var drainUntil time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-rotated:
+			drainUntil = time.Now().Add(rotationDrainPeriod)
+			continue
+		default:
+			line, err := reader.ReadString('\n')
+			offset += int64(len(line))
+
+			if err != nil {
+				// EOF reached, keep what was read of an unfinished line and
+				// wait for the rest of it
+				partial += line
+
+				// logrotate's copytruncate empties the file in place, keeping
+				// the inode, so the only sign is a file shorter than what
+				// has been read
+				if info, err := file.Stat(); err == nil && info.Size() < offset {
+					slog.Info("Log file truncated, reading from the start", "file", logFile, "size", info.Size(), "offset", offset)
+					if _, err := file.Seek(0, io.SeekStart); err != nil {
+						return fmt.Errorf("rewinding truncated log file: %w", err)
+					}
+					reader.Reset(file)
+					partial, offset = "", 0
+					t.recordOffset(currentInode, offset)
+					continue
+				}
+
+				// The notifier only reports changes to the file at logFile,
+				// so the old one is polled while it is drained
+				if notifier == nil || !drainUntil.IsZero() {
+					if !drainUntil.IsZero() && time.Now().After(drainUntil) {
+						return nil
+					}
+					if err := sleepContext(ctx, 500*time.Millisecond); err != nil {
+						return err
+					}
+					continue
+				}
+
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-notifier.Events():
+				}
+				if inode, err := getInode(logFile); err == nil && inode != currentInode {
+					slog.Debug("Log file rotated, restarting", "file", logFile, "old_inode", currentInode, "new_inode", inode)
+					drainUntil = time.Now().Add(rotationDrainPeriod)
+				}
+				continue
+			}
+
+			line = partial + line
+			partial = ""
+
+			if err := t.HandleLine(ctx, line); err != nil {
+				return err
+			}
+			t.recordOffset(currentInode, offset)
+		}
+	}
+}
+
+func (t *Tailer) recordOffset(inode uint64, offset int64) {
+	if t.Offsets != nil {
+		t.Offsets.set(t.Path, filePosition{Inode: inode, Offset: offset})
+	}
+}
+
+// seekEnd is a Tailer.Seek that skips everything already in the file.
+func seekEnd(_ context.Context, file *os.File) (int64, error) {
+	return file.Seek(0, io.SeekEnd)
+}
+
+func inodeChecker(ctx context.Context, logFile string, currentInode uint64, rotated chan bool) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		newInode, err := getInode(logFile)
+		if err != nil {
+			slog.Debug("Error getting log file inode", "file", logFile, "err", err)
+			continue
+		}
+
+		if newInode != currentInode {
+			slog.Debug("Log file rotated, restarting", "file", logFile, "old_inode", currentInode, "new_inode", newInode)
+			rotated <- true
+			return
+		}
+	}
+}
+
+// tailerGroup supervises the tailers of one or more log files. Each tailer
+// runs until its own Stop or until the group's context is cancelled, and Wait
+// returns once all of them have returned.
+type tailerGroup struct {
+	wg sync.WaitGroup
+
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+}
+
+func newTailerGroup() *tailerGroup {
+	return &tailerGroup{running: make(map[string]context.CancelFunc)}
+}
+
+// Start runs tailer unless one is already running for its path.
+func (g *tailerGroup) Start(ctx context.Context, tailer *Tailer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.running[tailer.Path]; ok {
+		return
+	}
+	tailerCtx, stop := context.WithCancel(ctx)
+	g.running[tailer.Path] = stop
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		tailer.Run(tailerCtx)
+	}()
+}
+
+// Stop cancels the tailer for path.
+func (g *tailerGroup) Stop(path string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if stop, ok := g.running[path]; ok {
+		stop()
+		delete(g.running, path)
+	}
+}
+
+// Wait blocks until every tailer has returned.
+func (g *tailerGroup) Wait() {
+	g.wg.Wait()
+}
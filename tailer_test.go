@@ -0,0 +1,128 @@
+package nginxviz
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testLogLine = `127.0.0.1 - - [17/Nov/2025:10:30:45 +0000] "GET /api/test HTTP/1.1" 200 1234 "http://example.com" "Mozilla/5.0"`
+
+// tailFile follows path with a Tailer that parses each line it hands over
+// as a combined format entry, until the test ends.
+func tailFile(t *testing.T, path string) <-chan LogEntry {
+	t.Helper()
+
+	parser, err := newCombinedLogParser(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := make(chan LogEntry, 16)
+	tailer := &Tailer{
+		Path: path,
+		HandleLine: func(ctx context.Context, line string) error {
+			entry, err := parser.Parse(line)
+			if err != nil {
+				t.Errorf("parsing %q: %v", line, err)
+				return nil
+			}
+			entries <- entry
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tailer.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return entries
+}
+
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func receiveEntry(t *testing.T, entries <-chan LogEntry) LogEntry {
+	t.Helper()
+
+	select {
+	case entry := <-entries:
+		return entry
+	case <-time.After(5 * time.Second):
+		t.Fatal("no entry read")
+		return LogEntry{}
+	}
+}
+
+func expectNoEntry(t *testing.T, entries <-chan LogEntry) {
+	t.Helper()
+
+	select {
+	case entry := <-entries:
+		t.Fatalf("unexpected entry %+v", entry)
+	case <-time.After(700 * time.Millisecond):
+	}
+}
+
+func TestTailerReassemblesSplitLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	appendFile(t, path, "")
+	entries := tailFile(t, path)
+
+	half := len(testLogLine) / 2
+	appendFile(t, path, testLogLine[:half])
+	expectNoEntry(t, entries)
+
+	appendFile(t, path, testLogLine[half:]+"\n")
+	entry := receiveEntry(t, entries)
+	if entry.IP != "127.0.0.1" || entry.URL != "/api/test" || entry.UserAgent != "Mozilla/5.0" {
+		t.Errorf("got %+v, want the whole line", entry)
+	}
+	expectNoEntry(t, entries)
+}
+
+func TestTailerDrainsRotatedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	rotated := filepath.Join(dir, "access.log.1")
+	appendFile(t, path, "")
+	entries := tailFile(t, path)
+
+	line := func(url string) string {
+		return strings.Replace(testLogLine, "/api/test", url, 1) + "\n"
+	}
+	appendFile(t, path, line("/before"))
+	receiveEntry(t, entries)
+
+	// nginx keeps writing to the renamed file until it reopens its logs
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, line("/new"))
+	appendFile(t, rotated, line("/late"))
+
+	for _, want := range []string{"/late", "/new"} {
+		if entry := receiveEntry(t, entries); entry.URL != want {
+			t.Errorf("got %s, want %s", entry.URL, want)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package nginxviz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	threatMaxDownload = 64 << 20
+	// threatDefaultScore is the score of lists that do not give one per
+	// address, such as FireHOL's, which only list well established abusers.
+	threatDefaultScore = 100
+)
+
+// prefixMap maps networks to values and finds the most specific network
+// containing an address, by trying each prefix length in use.
+type prefixMap[V any] struct {
+	bits     []int // prefix lengths in use, longest first
+	prefixes map[netip.Prefix]V
+}
+
+func newPrefixMap[V any]() *prefixMap[V] {
+	return &prefixMap[V]{prefixes: make(map[netip.Prefix]V)}
+}
+
+func (m *prefixMap[V]) Add(prefix netip.Prefix, value V) {
+	prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()).Masked()
+	m.prefixes[prefix] = value
+	if i, found := slices.BinarySearchFunc(m.bits, prefix.Bits(), func(a, b int) int { return b - a }); !found {
+		m.bits = slices.Insert(m.bits, i, prefix.Bits())
+	}
+}
+
+func (m *prefixMap[V]) Lookup(ip netip.Addr) (V, bool) {
+	ip = ip.Unmap()
+	for _, bits := range m.bits {
+		if bits > ip.BitLen() {
+			continue
+		}
+		prefix, _ := ip.Prefix(bits)
+		if value, ok := m.prefixes[prefix]; ok {
+			return value, true
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+func (m *prefixMap[V]) Len() int {
+	return len(m.prefixes)
+}
+
+// threatList is a loaded IP blocklist, with a score from 0 to 100 for each
+// network.
+type threatList struct {
+	name     string
+	networks *prefixMap[int]
+}
+
+// threatListName names a list after its file, e.g. firehol_level1 for
+// https://iplists.firehol.org/files/firehol_level1.netset.
+func threatListName(source string) string {
+	name := path.Base(strings.ReplaceAll(source, `\`, "/"))
+	if i := strings.IndexAny(name, "?#"); i >= 0 {
+		name = name[:i]
+	}
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// parseThreatList reads a list in one of the formats blocklists are
+// published in: an address or CIDR network per line, with # or ; comments,
+// as in FireHOL's .netset and .ipset files, CSV whose first column is the
+// address and second, if any, the score, or AbuseIPDB's JSON blacklist
+// with its abuseConfidenceScore.
+func parseThreatList(name string, data []byte) (*threatList, error) {
+	list := &threatList{name: name, networks: newPrefixMap[int]()}
+
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "{") {
+		var abuseIPDB struct {
+			Data []struct {
+				IPAddress string `json:"ipAddress"`
+				Score     int    `json:"abuseConfidenceScore"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(data, &abuseIPDB); err != nil {
+			return nil, fmt.Errorf("parsing AbuseIPDB blacklist: %w", err)
+		}
+		for _, entry := range abuseIPDB.Data {
+			if prefix, ok := parseThreatNetwork(entry.IPAddress); ok {
+				list.networks.Add(prefix, min(max(entry.Score, 0), 100))
+			}
+		}
+		return list, nil
+	}
+
+	for line := range strings.Lines(string(data)) {
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\r' || r == '\n' })
+		if len(fields) == 0 {
+			continue
+		}
+		// CSV headers and other lines without an address are skipped
+		prefix, ok := parseThreatNetwork(strings.Trim(fields[0], `"`))
+		if !ok {
+			continue
+		}
+		score := threatDefaultScore
+		if len(fields) > 1 {
+			if n, err := strconv.Atoi(strings.Trim(fields[1], `"`)); err == nil {
+				score = min(max(n, 0), 100)
+			}
+		}
+		list.networks.Add(prefix, score)
+	}
+
+	if list.networks.Len() == 0 && strings.TrimSpace(string(data)) != "" {
+		return nil, fmt.Errorf("no addresses found in %s", name)
+	}
+	return list, nil
+}
+
+func parseThreatNetwork(s string) (netip.Prefix, bool) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix, err == nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), true
+}
+
+// threatIntel tags entries from addresses on IP blocklists, loaded from
+// local files or URLs and reloaded every ThreatRefresh.
+type threatIntel struct {
+	sources []string
+	client  *http.Client
+	lists   atomic.Pointer[[]*threatList] // in the order of sources, nil until loaded
+}
+
+// newThreatIntel loads the lists that are local files, so entries read on
+// startup are tagged. Those at URLs are downloaded once Run starts.
+func newThreatIntel(sources []string) (*threatIntel, error) {
+	t := &threatIntel{
+		sources: sources,
+		client:  &http.Client{Timeout: time.Minute},
+	}
+
+	lists := make([]*threatList, len(sources))
+	for i, source := range sources {
+		if isThreatURL(source) {
+			continue
+		}
+		list, err := t.load(context.Background(), source)
+		if err != nil {
+			return nil, err
+		}
+		lists[i] = list
+	}
+	t.lists.Store(&lists)
+	return t, nil
+}
+
+func isThreatURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+func (t *threatIntel) load(ctx context.Context, source string) (*threatList, error) {
+	var data []byte
+	var err error
+	if isThreatURL(source) {
+		data, err = t.download(ctx, source)
+	} else {
+		data, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseThreatList(threatListName(source), data)
+}
+
+func (t *threatIntel) download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading threat list: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, threatMaxDownload+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > threatMaxDownload {
+		return nil, fmt.Errorf("threat list larger than %d bytes", threatMaxDownload)
+	}
+	return data, nil
+}
+
+// Run downloads the lists at URLs, then reloads every list each interval
+// until ctx is cancelled. A list that fails to load keeps its previous
+// version.
+func (t *threatIntel) Run(ctx context.Context, interval time.Duration) {
+	wait := interval
+	if slices.ContainsFunc(t.sources, isThreatURL) {
+		wait = 0
+	}
+
+	for {
+		if sleepContext(ctx, wait) != nil {
+			return
+		}
+		wait = interval
+
+		lists := slices.Clone(*t.lists.Load())
+		for i, source := range t.sources {
+			list, err := t.load(ctx, source)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				slog.Error("Error loading threat list", "source", source, "err", err)
+				continue
+			}
+			slog.Debug("Loaded threat list", "name", list.name, "networks", list.networks.Len())
+			lists[i] = list
+		}
+		t.lists.Store(&lists)
+	}
+}
+
+// Match returns the list with the highest score for ip, if any lists it.
+func (t *threatIntel) Match(ip netip.Addr) (string, int, bool) {
+	var name string
+	var score int
+	var found bool
+	for _, list := range *t.lists.Load() {
+		if list == nil {
+			continue
+		}
+		if s, ok := list.networks.Lookup(ip); ok && (!found || s > score) {
+			name, score, found = list.name, s, true
+		}
+	}
+	return name, score, found
+}
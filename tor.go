@@ -0,0 +1,41 @@
+package nginxviz
+
+// torExitListURL lists the addresses Tor exit nodes currently connect
+// from, one per line. The Tor Project updates it about every 30 minutes.
+const torExitListURL = "https://check.torproject.org/torbulkexitlist"
+
+// newTorExitNodes loads the exit node list from a file or URL. It is read
+// like a threat list, only used for the tor flag rather than a threat_list.
+func newTorExitNodes(source string) (*threatIntel, error) {
+	return newThreatIntel([]string{source})
+}
+
+// torFilter narrows the entries a websocket client receives by whether
+// they came through Tor.
+type torFilter string
+
+const (
+	torAll     torFilter = ""
+	torOnly    torFilter = "only"
+	torExclude torFilter = "exclude"
+)
+
+// parseTorFilter reads ?tor=only or ?tor=exclude, any other value
+// letting every entry through.
+func parseTorFilter(value string) torFilter {
+	switch f := torFilter(value); f {
+	case torOnly, torExclude:
+		return f
+	}
+	return torAll
+}
+
+func (f torFilter) Matches(tor bool) bool {
+	switch f {
+	case torOnly:
+		return tor
+	case torExclude:
+		return !tor
+	}
+	return true
+}
@@ -0,0 +1,191 @@
+package nginxviz
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	traceBatchSize     = 100
+	traceFlushInterval = time.Second
+	traceQueueSize     = 1000
+	// traceDefaultService names the service of entries without a host
+	traceDefaultService = "nginx"
+)
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName,omitempty"`
+	IPv4        string `json:"ipv4,omitempty"`
+	IPv6        string `json:"ipv6,omitempty"`
+}
+
+// zipkinSpan is a span in the Zipkin v2 JSON format.
+type zipkinSpan struct {
+	TraceID        string            `json:"traceId"`
+	ID             string            `json:"id"`
+	Name           string            `json:"name"`
+	Kind           string            `json:"kind"`
+	Timestamp      int64             `json:"timestamp"`
+	Duration       int64             `json:"duration,omitempty"`
+	LocalEndpoint  zipkinEndpoint    `json:"localEndpoint"`
+	RemoteEndpoint *zipkinEndpoint   `json:"remoteEndpoint,omitempty"`
+	Tags           map[string]string `json:"tags"`
+}
+
+// TraceExporter models sampled log entries as completed server spans and
+// posts them in batches to a Zipkin v2 compatible collector (Zipkin, or
+// Jaeger's Zipkin endpoint).
+type TraceExporter struct {
+	endpoint   string
+	sampleRate float64
+	client     *http.Client
+	spans      chan zipkinSpan
+}
+
+func NewTraceExporter(endpoint string, sampleRate float64) *TraceExporter {
+	return &TraceExporter{
+		endpoint:   endpoint,
+		sampleRate: sampleRate,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		spans:      make(chan zipkinSpan, traceQueueSize),
+	}
+}
+
+// Export queues a span for entry if it is sampled. It never blocks.
+func (t *TraceExporter) Export(entry LogEntry) {
+	if mathrand.Float64() >= t.sampleRate {
+		return
+	}
+
+	select {
+	case t.spans <- entryToSpan(entry):
+	default:
+		slog.Debug("Trace queue full, dropping span", "ip", entry.IP, "url", entry.URL)
+	}
+}
+
+// Run posts queued spans in batches until ctx is cancelled.
+func (t *TraceExporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]zipkinSpan, 0, traceBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.post(ctx, batch); err != nil {
+			slog.Warn("Error exporting spans", "endpoint", t.endpoint, "count", len(batch), "err", err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case span := <-t.spans:
+			batch = append(batch, span)
+			if len(batch) == traceBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (t *TraceExporter) post(ctx context.Context, spans []zipkinSpan) error {
+	body, err := json.Marshal(spans)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+func entryToSpan(entry LogEntry) zipkinSpan {
+	path := entry.URL
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	// Spans are named after the route, so requests for different IDs are
+	// grouped when NormalizeURLs collapses them
+	route := path
+	if entry.NormalizedURL != "" {
+		route = entry.NormalizedURL
+	}
+	service := entry.Host
+	if service == "" {
+		service = traceDefaultService
+	}
+
+	// nginx logs the time a request finished; spans start when it began
+	duration := time.Duration(entry.RequestTime * float64(time.Second))
+
+	span := zipkinSpan{
+		TraceID:       randomSpanID(),
+		ID:            randomSpanID(),
+		Name:          entry.Method + " " + route,
+		Kind:          "SERVER",
+		Timestamp:     entry.Timestamp.Add(-duration).UnixMicro(),
+		Duration:      duration.Microseconds(),
+		LocalEndpoint: zipkinEndpoint{ServiceName: service},
+		Tags: map[string]string{
+			"http.method":      entry.Method,
+			"http.path":        path,
+			"http.status_code": strconv.Itoa(entry.StatusCode),
+			"country":          entry.Country,
+			"ip":               entry.IP,
+			"is_bot":           strconv.FormatBool(entry.IsBot),
+			"suspicious":       strconv.FormatBool(entry.Suspicious),
+		},
+	}
+
+	if entry.Host != "" {
+		span.Tags["http.host"] = entry.Host
+	}
+
+	if ip, err := netip.ParseAddr(entry.IP); err == nil {
+		if ip.Is4() {
+			span.RemoteEndpoint = &zipkinEndpoint{IPv4: ip.String()}
+		} else {
+			span.RemoteEndpoint = &zipkinEndpoint{IPv6: ip.String()}
+		}
+	}
+
+	return span
+}
+
+// randomSpanID returns a random 64-bit id encoded as 16 hex characters.
+func randomSpanID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -12,7 +12,8 @@ export class WebSocketHandler {
   }
 
   connect(): void {
-    const wsUrl = `ws`;
+    // Forward ?token= so token protected servers accept the upgrade
+    const wsUrl = `ws${window.location.search}`;
 
     this.ws = new WebSocket(wsUrl);
 
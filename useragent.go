@@ -0,0 +1,252 @@
+package nginxviz
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Device types, for clients to pick an icon by.
+const (
+	deviceDesktop = "desktop"
+	deviceMobile  = "mobile"
+	deviceTablet  = "tablet"
+	deviceBot     = "bot"
+)
+
+// uaBrowser maps the product token a browser puts in its user agent to its
+// name. Browsers built on Chrome or Safari carry those tokens as well, so
+// they are listed before them.
+type uaBrowser struct {
+	token string // product name before the /
+	name  string
+}
+
+var uaBrowsers = []uaBrowser{
+	{"Edg", "Edge"},
+	{"EdgA", "Edge"},
+	{"EdgiOS", "Edge"},
+	{"OPR", "Opera"},
+	{"SamsungBrowser", "Samsung Internet"},
+	{"YaBrowser", "Yandex Browser"},
+	{"Vivaldi", "Vivaldi"},
+	{"Firefox", "Firefox"},
+	{"FxiOS", "Firefox"},
+	{"CriOS", "Chrome"},
+	{"Chromium", "Chromium"},
+	{"Chrome", "Chrome"},
+}
+
+// userAgent is what parseUserAgent makes of a User-Agent header. Unknown
+// parts are empty.
+type userAgent struct {
+	Browser        string
+	BrowserVersion string // major version
+	OS             string
+	DeviceType     string
+}
+
+// parseUserAgent recognizes the common browsers, operating systems and
+// device types from a user agent. Crawlers and HTTP libraries are named
+// after their product token, e.g. Googlebot or curl, with device type bot.
+func parseUserAgent(ua string) userAgent {
+	var parsed userAgent
+	if ua == "" || ua == "-" {
+		return parsed
+	}
+	parsed.OS = uaOS(ua)
+
+	lower := strings.ToLower(ua)
+	if containsAny(lower, crawlerPatterns) || containsAny(lower, scriptPatterns) {
+		parsed.Browser, parsed.BrowserVersion = uaBotProduct(ua)
+		parsed.DeviceType = deviceBot
+		return parsed
+	}
+
+	parsed.Browser, parsed.BrowserVersion = uaBrowserProduct(ua)
+	switch {
+	case strings.Contains(ua, "iPad") || parsed.OS == "Android" && !strings.Contains(ua, "Mobile"):
+		parsed.DeviceType = deviceTablet
+	case strings.Contains(ua, "Mobi") || strings.Contains(ua, "iPhone") || strings.Contains(ua, "iPod"):
+		parsed.DeviceType = deviceMobile
+	case parsed.OS != "" && parsed.Browser != "":
+		parsed.DeviceType = deviceDesktop
+	}
+	return parsed
+}
+
+func uaOS(ua string) string {
+	switch {
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"), strings.Contains(ua, "iPod"):
+		return "iOS"
+	case strings.Contains(ua, "Android"):
+		return "Android"
+	case strings.Contains(ua, "CrOS"):
+		return "Chrome OS"
+	case strings.Contains(ua, "Windows"):
+		return "Windows"
+	case strings.Contains(ua, "Mac OS X"), strings.Contains(ua, "Macintosh"):
+		return "macOS"
+	case strings.Contains(ua, "FreeBSD"):
+		return "FreeBSD"
+	case strings.Contains(ua, "Linux"), strings.Contains(ua, "X11"):
+		return "Linux"
+	}
+	return ""
+}
+
+func uaBrowserProduct(ua string) (string, string) {
+	for _, b := range uaBrowsers {
+		if version, ok := uaProductVersion(ua, b.token); ok {
+			return b.name, version
+		}
+	}
+	// Safari gives its version in a separate Version/ token
+	if _, ok := uaProductVersion(ua, "Safari"); ok {
+		version, _ := uaProductVersion(ua, "Version")
+		return "Safari", version
+	}
+	if version, ok := uaProductVersion(ua, "MSIE"); ok {
+		return "Internet Explorer", version
+	}
+	if strings.Contains(ua, "Trident/") {
+		version, _ := uaProductVersion(ua, "rv")
+		return "Internet Explorer", version
+	}
+	return "", ""
+}
+
+// uaBotProduct returns the product token that identified a crawler or HTTP
+// library, such as Googlebot/2.1 or python-requests/2.31.
+func uaBotProduct(ua string) (string, string) {
+	fields := strings.FieldsFunc(ua, func(r rune) bool {
+		return r == ' ' || r == ';' || r == '(' || r == ')' || r == ','
+	})
+	for _, field := range fields {
+		name, version, _ := strings.Cut(field, "/")
+		lower := strings.ToLower(field)
+		if name != "" && (containsAny(strings.ToLower(name), crawlerPatterns) || containsAny(lower, scriptPatterns)) {
+			return name, uaMajorVersion(version)
+		}
+	}
+	if len(fields) == 0 {
+		return "", ""
+	}
+	name, version, _ := strings.Cut(fields[0], "/")
+	return name, uaMajorVersion(version)
+}
+
+// uaProductVersion finds token/version or, for MSIE and rv, "token version"
+// and "token:version", returning the major version.
+func uaProductVersion(ua, token string) (string, bool) {
+	for i := 0; ; {
+		j := strings.Index(ua[i:], token)
+		if j < 0 {
+			return "", false
+		}
+		start := i + j
+		end := start + len(token)
+		i = end
+		// The token must be a whole word
+		if start > 0 && isUAWordByte(ua[start-1]) {
+			continue
+		}
+		if end < len(ua) && (ua[end] == '/' || ua[end] == ' ' || ua[end] == ':') {
+			return uaMajorVersion(ua[end+1:]), true
+		}
+	}
+}
+
+func isUAWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// uaMajorVersion returns the leading digits of a version.
+func uaMajorVersion(version string) string {
+	n := 0
+	for n < len(version) && version[n] >= '0' && version[n] <= '9' {
+		n++
+	}
+	return version[:n]
+}
+
+// setUserAgentFields fills in the browser, OS and device fields of entry.
+func setUserAgentFields(entry *LogEntry) {
+	ua := parseUserAgent(entry.UserAgent)
+	entry.Browser, entry.BrowserVersion = ua.Browser, ua.BrowserVersion
+	entry.OS, entry.DeviceType = ua.OS, ua.DeviceType
+}
+
+type browserSnapshot struct {
+	Browsers map[string]int `json:"browsers"`
+	OS       map[string]int `json:"os"`
+	Devices  map[string]int `json:"devices"`
+}
+
+// browserBreakdown counts requests per browser, operating system and device
+// type. Unrecognized user agents are counted as "other".
+type browserBreakdown struct {
+	mu       sync.Mutex
+	browsers map[string]int
+	os       map[string]int
+	devices  map[string]int
+}
+
+func newBrowserBreakdown() *browserBreakdown {
+	return &browserBreakdown{
+		browsers: make(map[string]int),
+		os:       make(map[string]int),
+		devices:  make(map[string]int),
+	}
+}
+
+func (b *browserBreakdown) Observe(entry LogEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Bots are counted together among browsers, as every crawler and
+	// library is a product of its own
+	browser := entry.Browser
+	if entry.DeviceType == deviceBot {
+		browser = deviceBot
+	}
+	b.browsers[browserBucket(browser)]++
+	b.os[browserBucket(entry.OS)]++
+	b.devices[browserBucket(entry.DeviceType)]++
+}
+
+// browserBucket returns value, or "other" when it is empty.
+func browserBucket(value string) string {
+	if value == "" {
+		return "other"
+	}
+	return value
+}
+
+func (b *browserBreakdown) Snapshot() browserSnapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	snapshot := browserSnapshot{
+		Browsers: make(map[string]int, len(b.browsers)),
+		OS:       make(map[string]int, len(b.os)),
+		Devices:  make(map[string]int, len(b.devices)),
+	}
+	for name, count := range b.browsers {
+		snapshot.Browsers[name] = count
+	}
+	for name, count := range b.os {
+		snapshot.OS[name] = count
+	}
+	for name, count := range b.devices {
+		snapshot.Devices[name] = count
+	}
+	return snapshot
+}
+
+// MakeBrowsersHandler returns request counts per browser, OS and device type.
+func MakeBrowsersHandler(browsers *browserBreakdown) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, browsers.Snapshot())
+	}
+}
@@ -0,0 +1,128 @@
+package nginxviz
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxTrackedVisitors bounds the visitors whose sessions are tracked in a
+// day. Visitors beyond it still get an ID, but no session.
+const maxTrackedVisitors = 100000
+
+type visitorSession struct {
+	id       string
+	lastSeen time.Time
+}
+
+// visitorTracker identifies visitors by a salted hash of their IP and user
+// agent and groups their requests into sessions that end after timeout
+// without a request. The salt is random, kept in memory only and replaced
+// every day (UTC), so IDs cannot be traced back to an address or followed
+// from one day to the next. Sessions running at midnight end there.
+type visitorTracker struct {
+	mu       sync.Mutex
+	timeout  time.Duration
+	day      time.Time // UTC midnight of the day salt is used for
+	salt     [32]byte
+	visitors map[string]*visitorSession // by visitor ID, for day
+	sessions int                        // sessions started on day
+	latest   time.Time                  // newest request seen
+}
+
+func newVisitorTracker(timeout time.Duration) *visitorTracker {
+	return &visitorTracker{timeout: timeout, visitors: make(map[string]*visitorSession)}
+}
+
+// Observe returns the visitor and session IDs of a request from ip with
+// user agent ua at ts.
+func (t *visitorTracker) Observe(ip, ua string, ts time.Time) (string, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Requests from an earlier day, such as a late backfill, are hashed
+	// with the current salt rather than bringing an old one back
+	if day := ts.UTC().Truncate(24 * time.Hour); day.After(t.day) {
+		t.rotate(day)
+	}
+	if ts.After(t.latest) {
+		t.latest = ts
+	}
+
+	visitorID := t.hash([]byte(ip), []byte{0}, []byte(ua))
+	session, ok := t.visitors[visitorID]
+	if !ok {
+		if len(t.visitors) >= maxTrackedVisitors {
+			return visitorID, ""
+		}
+		session = &visitorSession{}
+		t.visitors[visitorID] = session
+	}
+
+	if session.id == "" || ts.Sub(session.lastSeen) > t.timeout {
+		var start [8]byte
+		binary.BigEndian.PutUint64(start[:], uint64(ts.UnixNano()))
+		session.id = t.hash([]byte(visitorID), start[:])
+		t.sessions++
+	}
+	if ts.After(session.lastSeen) {
+		session.lastSeen = ts
+	}
+	return visitorID, session.id
+}
+
+// rotate starts day with a new salt, forgetting the visitors of the last.
+func (t *visitorTracker) rotate(day time.Time) {
+	t.day = day
+	rand.Read(t.salt[:])
+	clear(t.visitors)
+	t.sessions = 0
+}
+
+// hash returns the first 8 bytes of the salted SHA-256 of parts in hex.
+func (t *visitorTracker) hash(parts ...[]byte) string {
+	h := sha256.New()
+	h.Write(t.salt[:])
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+type visitorSnapshot struct {
+	Day            string `json:"day"`
+	Visitors       int    `json:"visitors"`        // unique visitors on day
+	Sessions       int    `json:"sessions"`        // sessions started on day
+	ActiveSessions int    `json:"active_sessions"` // sessions with a request within the timeout of the newest one
+}
+
+func (t *visitorTracker) Snapshot() visitorSnapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	snapshot := visitorSnapshot{Visitors: len(t.visitors), Sessions: t.sessions}
+	if !t.day.IsZero() {
+		snapshot.Day = t.day.Format(time.DateOnly)
+	}
+	for _, session := range t.visitors {
+		if session.id != "" && t.latest.Sub(session.lastSeen) <= t.timeout {
+			snapshot.ActiveSessions++
+		}
+	}
+	return snapshot
+}
+
+// MakeVisitorsHandler returns today's unique visitor and session counts.
+func MakeVisitorsHandler(visitors *visitorTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if visitors == nil {
+			returnError(w, http.StatusNotFound, "visitor tracking requires -session-timeout")
+			return
+		}
+		writeJSON(w, visitors.Snapshot())
+	}
+}
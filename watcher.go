@@ -0,0 +1,225 @@
+package nginxviz
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/netip"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// sleepContext waits for d or until ctx is cancelled, whichever comes first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// handleAccessLogLine parses an access log line and hands the entry to the
+// GeoIP workers, which pass it on to the broadcaster.
+func (s *Server) handleAccessLogLine(ctx context.Context, line string) error {
+	logEntry, ok := s.processLogLine(line)
+	if !ok {
+		return nil
+	}
+	return s.submitEntry(ctx, logEntry)
+}
+
+// submitEntry hands a processed entry to the GeoIP workers, or on an agent
+// to the collector.
+func (s *Server) submitEntry(ctx context.Context, logEntry LogEntry) error {
+	if s.forwarder != nil {
+		s.forwarder.Enqueue(logEntry)
+		return nil
+	}
+
+	if update, ok := s.lag.Observe(logEntry, time.Now()); ok {
+		slog.Info("Log processing lag changed", "lag_seconds", update.LagSeconds)
+		s.publishEvent(update)
+	}
+
+	return s.geoPool.Submit(ctx, logEntry, s.entries)
+}
+
+// processLogLine parses and filters a raw log line. It returns false when the
+// line should not be broadcast. GeoIP enrichment happens in the worker pool.
+func (s *Server) processLogLine(line string) (LogEntry, bool) {
+	logEntry, ok, err := s.parseLogLine(line)
+	if err != nil {
+		slog.Warn("Error parsing log line", "err", err)
+		s.counters.Unparseable.Add(1)
+		s.parseErrors.Add(line, err)
+		s.publishParseError(line, err)
+	}
+	return logEntry, ok
+}
+
+// parseLogLine is processLogLine without recording lines that fail to
+// parse, which it returns the error of.
+func (s *Server) parseLogLine(line string) (LogEntry, bool, error) {
+	line = stripSyslogHeader(strings.TrimSpace(line))
+	if line == "" {
+		return LogEntry{}, false, nil
+	}
+
+	// Refuse oversized lines before they reach the parser's regex
+	if s.cfg.MaxLineSize > 0 && len(line) > s.cfg.MaxLineSize {
+		slog.Warn("Skipping log line exceeding -max-line-size", "size", len(line), "max", s.cfg.MaxLineSize)
+		return LogEntry{}, false, nil
+	}
+
+	logEntry, err := s.parser.Parse(line)
+	if errors.Is(err, ErrSkipLine) {
+		return LogEntry{}, false, nil
+	}
+	if err != nil {
+		return LogEntry{}, false, err
+	}
+	if len(logEntry.ParseWarnings) > 0 {
+		slog.Debug("Partially parsed log line", "warnings", logEntry.ParseWarnings)
+		s.counters.PartiallyParsed.Add(1)
+	}
+
+	s.reloadMu.RLock()
+	extractors := s.extractors
+	s.reloadMu.RUnlock()
+	extractFields(&logEntry, line, extractors)
+	logEntry, ok := s.filterEntry(logEntry)
+	return logEntry, ok, nil
+}
+
+// filterEntry scrubs, normalizes and truncates a parsed entry and applies
+// the drop rules. It returns false when the entry should not be broadcast.
+func (s *Server) filterEntry(logEntry LogEntry) (LogEntry, bool) {
+	s.querySecrets.scrubEntry(&logEntry)
+	normalizeURL(&logEntry, s.cfg.NormalizeURLs)
+
+	s.reloadMu.RLock()
+	honeypots, dropRules := s.honeypots, s.dropRules
+	s.reloadMu.RUnlock()
+
+	// Check traps before truncation so long trap URLs still match exactly
+	logEntry.HoneypotHit = honeypots.Matches(logEntry.URL)
+	truncateFields(&logEntry, s.cfg.MaxFieldSize)
+
+	// Skip requests to flag SVG files to prevent infinite loop
+	if strings.Contains(logEntry.URL, "nginxviz") {
+		return LogEntry{}, false
+	}
+
+	for _, rule := range dropRules {
+		if rule.Matches(logEntry) {
+			return LogEntry{}, false
+		}
+	}
+
+	return logEntry, true
+}
+
+// truncateFields shortens the free-form fields of an entry to at most limit
+// bytes, marking truncated values with a trailing ellipsis.
+func truncateFields(entry *LogEntry, limit int) {
+	if limit <= 0 {
+		return
+	}
+	entry.URL = truncateString(entry.URL, limit)
+	entry.Path = truncateString(entry.Path, limit)
+	entry.Query = truncateString(entry.Query, limit)
+	entry.NormalizedURL = truncateString(entry.NormalizedURL, limit)
+	entry.UserAgent = truncateString(entry.UserAgent, limit)
+	entry.Referer = truncateString(entry.Referer, limit)
+	for key, value := range entry.Extra {
+		entry.Extra[key] = truncateString(value, limit)
+	}
+}
+
+func truncateString(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	// Cut on a rune boundary so the result stays valid UTF-8
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "…"
+}
+
+// enrichLogEntry adds GeoIP, hostname and rate data to a parsed entry. It
+// returns false when the entry's IP cannot be resolved.
+func (s *Server) enrichLogEntry(logEntry LogEntry) (LogEntry, bool) {
+	ip, err := netip.ParseAddr(logEntry.IP)
+	if err != nil {
+		slog.Warn("Error parsing ip", "ip", logEntry.IP, "err", err)
+		return LogEntry{}, false
+	}
+
+	record, err := s.lookupIP(ip)
+	if err != nil {
+		slog.Warn("Error decoding ip", "ip", logEntry.IP, "err", err)
+		return LogEntry{}, false
+	}
+
+	record.apply(&logEntry)
+	if s.threats != nil {
+		logEntry.ThreatList, logEntry.ThreatScore, _ = s.threats.Match(ip)
+	}
+	if s.torExits != nil {
+		_, _, logEntry.Tor = s.torExits.Match(ip)
+	}
+
+	if s.resolver != nil {
+		result, ok := s.resolver.Lookup(logEntry.IP)
+		logEntry.Hostname = result.Hostname
+		verifyCrawler(&logEntry, result, ok)
+	}
+
+	if s.visitors != nil {
+		logEntry.VisitorID, logEntry.SessionID = s.visitors.Observe(logEntry.IP, logEntry.UserAgent, logEntry.Timestamp)
+	}
+
+	if s.rates != nil {
+		logEntry.Rate, logEntry.Suspicious = s.rates.Observe(logEntry.IP, logEntry.Timestamp)
+	}
+
+	scoreBot(&logEntry, s.botWhitelist)
+	setUserAgentFields(&logEntry)
+	classifyReferer(&logEntry, s.cfg.InternalDomains)
+	s.classifier.Classify(&logEntry)
+
+	return logEntry, true
+}
+
+// lookupIP resolves the GeoIP record for an address, with its network from
+// the ASN database if there is one, consulting the cache first. Private
+// addresses are not looked up and get privateCountry.
+func (s *Server) lookupIP(ip netip.Addr) (ipRecord, error) {
+	if isPrivateIP(ip) {
+		return privateIPRecord(), nil
+	}
+	if record, ok := s.geoCache.Get(ip); ok {
+		return record, nil
+	}
+
+	var record ipRecord
+	if err := s.db.Load().Lookup(ip).Decode(&record); err != nil {
+		return record, err
+	}
+	// The ASN fields sit at the top level of the record, so decoding into
+	// the same struct leaves the location alone
+	if s.asnDB != nil {
+		if err := s.asnDB.Lookup(ip).Decode(&record); err != nil {
+			return record, err
+		}
+	}
+	s.geoCache.Put(ip, record)
+	return record, nil
+}